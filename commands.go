@@ -0,0 +1,548 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/juju/guiproxy/internal/capture"
+	"github.com/juju/guiproxy/internal/daemon"
+	"github.com/juju/guiproxy/internal/guiconfig"
+	"github.com/juju/guiproxy/internal/juju"
+	"github.com/juju/guiproxy/internal/mock"
+	"github.com/juju/guiproxy/internal/profile"
+	"github.com/juju/guiproxy/logger"
+	"github.com/juju/guiproxy/server"
+)
+
+// defaultCommand holds the name of the command run when none is provided.
+const defaultCommand = "serve"
+
+// command holds a guiproxy subcommand.
+type command struct {
+	// help holds a short description of the command.
+	help string
+
+	// args describes the positional arguments accepted by the command.
+	args string
+
+	// run runs the command with the given name and arguments.
+	run func(name string, args []string) error
+}
+
+// commands holds the available subcommands, keyed by name. It is populated
+// on initialization as the usage of commands refers to it.
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"serve": {
+			help: "start the GUI proxy server",
+			run:  runServe,
+		},
+		"start": {
+			help: "start the GUI proxy server in the background",
+			run:  runStart,
+		},
+		"stop": {
+			help: "stop the GUI proxy server running in the background",
+			run:  runStop,
+		},
+		"status": {
+			help: "show the URLs, controller and environment of the GUI proxy server running in the background",
+			run:  runStatus,
+		},
+		"config": {
+			help: "print the GUI configuration file that would be served with the given flags, without connecting to Juju",
+			run:  runConfig,
+		},
+		"envs": {
+			help: "list the predefined environments",
+			run:  runEnvs,
+		},
+		"profiles": {
+			help: "list the profiles defined in the " + profile.DefaultPath() + " configuration file",
+			run:  runProfiles,
+		},
+		"controllers": {
+			help: "show the controller the GUI would connect to",
+			run:  runControllers,
+		},
+		"record": {
+			help: "start the GUI proxy server, capturing the WebSocket traffic to the given directory",
+			args: "dir",
+			run:  runRecord,
+		},
+		"replay": {
+			help: "start the GUI proxy server, replaying captured traffic with a fake controller",
+			args: "file.capture...",
+			run:  runReplay,
+		},
+		"diff": {
+			help: "compare two captures, aligning RPC calls by method and sequence",
+			args: "a.capture b.capture",
+			run:  runDiff,
+		},
+	}
+}
+
+// newFlagSet returns the flag set used to parse the arguments of the command
+// with the given name.
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(program+" "+name, flag.ExitOnError)
+	fs.Usage = func() {
+		if name == defaultCommand {
+			usage()
+		} else {
+			cmd := commands[name]
+			fmt.Fprintln(os.Stderr, strings.TrimSpace(fmt.Sprintf("Usage: %s %s [flags] %s", program, name, cmd.args)))
+			fmt.Fprintf(os.Stderr, "The %s command will %s.\n", name, cmd.help)
+		}
+		fmt.Fprintf(os.Stderr, "Flags:\n")
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// runServe implements the serve command.
+func runServe(name string, args []string) error {
+	fs := newFlagSet(name)
+	options, err := parseOptions(fs, args)
+	if err != nil {
+		return fmt.Errorf("cannot parse configuration options: %s", err)
+	}
+	if fs.NArg() != 0 {
+		return fmt.Errorf("cannot parse configuration options: unexpected arguments %q", fs.Args())
+	}
+	return serve(options)
+}
+
+// runRecord implements the record command.
+func runRecord(name string, args []string) error {
+	fs := newFlagSet(name)
+	options, err := parseOptions(fs, args)
+	if err != nil {
+		return fmt.Errorf("cannot parse configuration options: %s", err)
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("cannot parse configuration options: a single capture directory must be provided")
+	}
+	options.captureDir = fs.Arg(0)
+	return serve(options)
+}
+
+// runReplay implements the replay command.
+func runReplay(name string, args []string) error {
+	fs := newFlagSet(name)
+	options, err := parseOptions(fs, args)
+	if err != nil {
+		return fmt.Errorf("cannot parse configuration options: %s", err)
+	}
+	if fs.NArg() == 0 {
+		return fmt.Errorf("cannot parse configuration options: at least a capture file must be provided")
+	}
+	if options.mockScript != "" {
+		return fmt.Errorf("cannot parse configuration options: cannot use a mock script when replaying captures")
+	}
+	options.replay = fs.Args()
+	return serve(options)
+}
+
+// runStart implements the start command.
+func runStart(name string, args []string) error {
+	fs := newFlagSet(name)
+	// Options are parsed here so that errors are reported immediately.
+	options, err := parseOptions(fs, args)
+	if err != nil {
+		return fmt.Errorf("cannot parse configuration options: %s", err)
+	}
+	if fs.NArg() != 0 {
+		return fmt.Errorf("cannot parse configuration options: unexpected arguments %q", fs.Args())
+	}
+	if options.showVersion {
+		log.Printf("%s %s\n", program, version)
+		return nil
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("cannot start in the background: %s", err)
+	}
+	d := daemon.New(daemon.DefaultDir())
+	s, err := d.Start(exec.Command(exe, append([]string{defaultCommand}, args...)...), daemonTimeout)
+	if err != nil {
+		return fmt.Errorf("cannot start in the background: %s", err)
+	}
+	s.Log = d.LogFile()
+	printState(s)
+	return nil
+}
+
+// runStop implements the stop command.
+func runStop(name string, args []string) error {
+	fs := newFlagSet(name)
+	fs.Parse(args)
+	pid, err := daemon.New(daemon.DefaultDir()).Stop(daemonTimeout)
+	if err == daemon.ErrNotRunning {
+		fmt.Printf("%s is not running\n", program)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s stopped (pid %d)\n", program, pid)
+	return nil
+}
+
+// runStatus implements the status command. The program exits with status 3
+// if no server is running in the background.
+func runStatus(name string, args []string) error {
+	fs := newFlagSet(name)
+	fs.Parse(args)
+	s, err := daemon.New(daemon.DefaultDir()).Status()
+	if err == daemon.ErrNotRunning {
+		fmt.Printf("%s is not running\n", program)
+		os.Exit(3)
+	}
+	if err != nil {
+		return err
+	}
+	printState(s)
+	return nil
+}
+
+// daemonTimeout holds the time waited for the server running in the
+// background to start or stop.
+const daemonTimeout = 30 * time.Second
+
+// printState prints the given state of the server running in the background.
+func printState(s *daemon.State) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 1, ' ', 0)
+	fmt.Fprintf(w, "%s is running\n", program)
+	fmt.Fprintf(w, "pid:\t%d\n", s.PID)
+	if !s.Started.IsZero() {
+		fmt.Fprintf(w, "started:\t%s\n", s.Started.Format(time.RFC1123))
+	}
+	if s.Controller != "" {
+		fmt.Fprintf(w, "controller:\t%s\n", s.Controller)
+	}
+	if s.JujuVersion != "" {
+		fmt.Fprintf(w, "Juju version:\t%s\n", s.JujuVersion)
+	}
+	if s.Environment != "" {
+		fmt.Fprintf(w, "environment:\t%s\n", s.Environment)
+	}
+	if s.Profile != "" {
+		fmt.Fprintf(w, "profile:\t%s\n", s.Profile)
+	}
+	fmt.Fprintf(w, "log file:\t%s\n", s.Log)
+	w.Flush()
+	if len(s.URLs) != 0 {
+		fmt.Printf("visit the GUI at:\n  %s\n", strings.Join(s.URLs, "\n  "))
+	}
+}
+
+// runConfig implements the config command.
+func runConfig(name string, args []string) error {
+	fs := newFlagSet(name)
+	options, err := parseOptions(fs, args)
+	if err != nil {
+		return fmt.Errorf("cannot parse configuration options: %s", err)
+	}
+	// Printing the configuration has no side effects: neither Juju nor the
+	// fake controller are involved, so the controller address must be known.
+	p := optionParams(options)
+	if p.ControllerAddr == "" {
+		return fmt.Errorf("cannot print the configuration: controller address not known, use -controller or -env")
+	}
+	host := options.listenHost
+	if host == "" {
+		host = "localhost"
+	}
+	fmt.Println(server.Config(p, net.JoinHostPort(host, strconv.Itoa(options.port))))
+	return nil
+}
+
+// runEnvs implements the envs command.
+func runEnvs(name string, args []string) error {
+	fs := newFlagSet(name)
+	fs.Parse(args)
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "ENVIRONMENT\tCONTROLLER")
+	for _, env := range guiconfig.Environments {
+		fmt.Fprintf(w, "%s\t%s\n", env, env.ControllerAddr)
+	}
+	return w.Flush()
+}
+
+// runProfiles implements the profiles command.
+func runProfiles(name string, args []string) error {
+	fs := newFlagSet(name)
+	fs.Parse(args)
+	profiles, err := profile.Read(profile.DefaultPath())
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 1, ' ', 0)
+	for _, name := range names {
+		fmt.Fprintf(w, "%s:\n", name)
+		p := profiles[name]
+		for _, option := range p.Names() {
+			fmt.Fprintf(w, "  -%s\t%s\n", option, p[option])
+		}
+	}
+	return w.Flush()
+}
+
+// runControllers implements the controllers command.
+func runControllers(name string, args []string) error {
+	fs := newFlagSet(name)
+	controllerAddr := fs.String("controller", "", "controller address (defaults to the address of the current controller)")
+	envName := fs.String("env", "", "use the controller of the given predefined environment")
+	fs.Parse(args)
+	if err := profile.Environ(fs, envPrefix).Apply(fs); err != nil {
+		return fmt.Errorf("cannot use %s* environment variables: %s", envPrefix, err)
+	}
+	if *controllerAddr == "" && *envName != "" {
+		env, err := guiconfig.GetEnvironment(*envName)
+		if err != nil {
+			return fmt.Errorf("cannot get the environment: %s", err)
+		}
+		*controllerAddr = env.ControllerAddr
+	}
+	controller, err := juju.Info(*controllerAddr)
+	if err != nil {
+		return fmt.Errorf("cannot retrieve Juju URLs: %s", err)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 1, ' ', 0)
+	if controller.Name != "" {
+		fmt.Fprintf(w, "name:\t%s\n", controller.Name)
+	}
+	fmt.Fprintf(w, "address:\t%s\n", controller.Addr)
+	if len(controller.Endpoints) != 0 {
+		fmt.Fprintf(w, "endpoints:\t%s\n", strings.Join(controller.Endpoints, ", "))
+	}
+	if controller.Version != "" {
+		fmt.Fprintf(w, "Juju version:\t%s\n", controller.Version)
+	}
+	if controller.Legacy() {
+		fmt.Fprintf(w, "Juju 1:\tyes\n")
+	}
+	return w.Flush()
+}
+
+// serve starts the GUI proxy server with the given options.
+func serve(options *config) error {
+	log.Printf("%s %s\n", program, version)
+	if options.showVersion {
+		return nil
+	}
+	// Make sure juju commands do not inherit the pidfile of servers running in
+	// the background.
+	if err := daemon.Inherit(); err != nil {
+		return fmt.Errorf("cannot run in the background: %s", err)
+	}
+	p, err := serverParams(options)
+	if err != nil {
+		return err
+	}
+	srv := server.New(p)
+
+	// Start the GUI proxy server.
+	log.Print("starting the server\n\n")
+	l, err := net.Listen("tcp", net.JoinHostPort(options.listenHost, strconv.Itoa(options.port)))
+	if err != nil {
+		return fmt.Errorf("cannot start server: %s", err)
+	}
+	printAddresses(options.listenHost, options.port, options.baseURL, options.access.Token)
+	if path := os.Getenv(daemon.StateEnv); path != "" {
+		// The server has been started in the background: report its state.
+		err := daemon.WriteState(path, daemon.State{
+			PID:         os.Getpid(),
+			Started:     time.Now(),
+			URLs:        guiURLs(options.listenHost, options.port, options.baseURL, options.access.Token),
+			Controller:  p.ControllerAddr,
+			JujuVersion: p.JujuVersion,
+			Environment: options.envName,
+			Profile:     options.profile,
+		})
+		if err != nil {
+			return fmt.Errorf("cannot report server state: %s", err)
+		}
+	}
+	if err := http.Serve(l, srv); err != nil {
+		return fmt.Errorf("cannot start server: %s", err)
+	}
+	return nil
+}
+
+// serverParams returns the parameters used to set up the GUI proxy server
+// with the given options, retrieving information from Juju itself or starting
+// a fake controller if required.
+func serverParams(options *config) (server.Params, error) {
+	log.Println("configuring the server")
+	if options.profile != "" {
+		log.Printf("using profile: %s\n", options.profile)
+	}
+	var controller *juju.Controller
+	var err error
+	if options.mockScript != "" || options.mockStatus != "" || len(options.replay) != 0 {
+		controller, err = startMockController(options)
+		if err != nil {
+			return server.Params{}, fmt.Errorf("cannot start the mock controller: %s", err)
+		}
+	} else {
+		controller, err = juju.Info(options.controllerAddr)
+		if err != nil {
+			return server.Params{}, fmt.Errorf("cannot retrieve Juju URLs: %s", err)
+		}
+	}
+	legacyJuju := options.legacyJuju || controller.Legacy()
+	log.Printf("GUI sandbox: %s\n", options.guiURL)
+	log.Printf("controller: %s\n", controller.Addr)
+	if controller.Version != "" {
+		log.Printf("Juju version: %s\n", controller.Version)
+	}
+	if legacyJuju {
+		log.Println("using Juju 1")
+	}
+	var jar *juju.CookieJar
+	if options.cookieJar != "" {
+		path := options.cookieJar
+		if path == currentCookieJar {
+			if controller.Name == "" {
+				return server.Params{}, fmt.Errorf("cannot use the cookie jar of the current controller: controller name not known")
+			}
+			path = juju.CookieJarPath(controller.Name)
+		}
+		if jar, err = juju.ReadCookieJar(path); err != nil {
+			return server.Params{}, fmt.Errorf("cannot use the cookie jar: %s", err)
+		}
+		log.Printf("logging in with macaroons from: %s\n", path)
+	}
+	if options.envName != "" {
+		log.Printf("environment: %s\n", options.envName)
+	}
+	if options.shellURL != "" {
+		log.Printf("jujushell: %s\n", options.shellURL)
+	}
+	if options.throttle != nil {
+		log.Printf("emulating network conditions: %s\n", options.throttle)
+	}
+	if len(options.facadeVersions) != 0 {
+		log.Printf("overriding facade versions: %v\n", options.facadeVersions)
+	}
+	if options.logDir != "" {
+		log.Printf("WebSocket traffic logged to: %s\n", options.logDir)
+	}
+	if options.captureDir != "" {
+		log.Printf("WebSocket traffic captured to: %s\n", options.captureDir)
+	}
+	if len(options.guiConfig) != 0 {
+		log.Println("GUI config has been customized")
+	}
+	if options.access.Username != "" || options.access.Token != "" {
+		log.Println("client authentication required")
+	}
+	if len(options.access.AllowedNetworks) != 0 {
+		log.Printf("clients allowed from: %v\n", options.access.AllowedNetworks)
+	}
+	p := optionParams(options)
+	p.ControllerAddr = controller.Addr
+	p.LegacyJuju = legacyJuju
+	p.JujuVersion = controller.Version
+	p.CookieJar = jar
+	p.AllowedHosts = append(controller.Endpoints, options.allowedHosts...)
+	return p, nil
+}
+
+// optionParams returns the parameters used to set up the GUI proxy server
+// resolved from the given options only, with no side effects. Information
+// about the controller is only included if provided in the options.
+func optionParams(options *config) server.Params {
+	return server.Params{
+		ControllerAddr:        options.controllerAddr,
+		GUIURL:                options.guiURL,
+		GUIConfig:             options.guiConfig,
+		BaseURL:               options.baseURL,
+		LegacyJuju:            options.legacyJuju,
+		AllowedHosts:          options.allowedHosts,
+		NoColor:               options.noColor,
+		PrettyLog:             options.prettyLog,
+		LogLimit:              options.logLimit,
+		ShellURL:              options.shellURL,
+		Compress:              options.compress,
+		BufferSize:            options.bufferSize,
+		ReadLimit:             options.readLimit,
+		Throttle:              options.throttle,
+		PingInterval:          options.pingInterval,
+		ReadTimeout:           options.readTimeout,
+		IdleTimeout:           options.idleTimeout,
+		Transformers:          options.transformers,
+		FacadeVersions:        options.facadeVersions,
+		RewriteFacadeVersions: options.rewriteVersions,
+		LogDir:                options.logDir,
+		LogMaxSize:            options.logMaxSize,
+		CaptureDir:            options.captureDir,
+		LogFilter:             options.logFilter,
+		Redactor:              options.redactor,
+		Access:                options.access,
+	}
+}
+
+// startMockController starts a fake controller replaying the captures, or
+// driven by the script, and serving the model status configured in the given
+// options, and returns information about it.
+func startMockController(options *config) (*juju.Controller, error) {
+	script := &mock.Script{}
+	switch {
+	case len(options.replay) != 0:
+		var calls []capture.Call
+		for _, path := range options.replay {
+			entries, err := capture.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+			calls = append(calls, capture.Calls(entries)...)
+		}
+		sort.SliceStable(calls, func(i, j int) bool {
+			return calls[i].Time.Before(calls[j].Time)
+		})
+		script = mock.CaptureScript(calls)
+		log.Printf("mock controller replaying: %s\n", strings.Join(options.replay, ", "))
+	case options.mockScript != "":
+		var err error
+		if script, err = mock.ReadScript(options.mockScript); err != nil {
+			return nil, err
+		}
+		log.Printf("mock controller driven by: %s\n", options.mockScript)
+	}
+	if options.mockStatus != "" {
+		if err := script.ReadStatus(options.mockStatus); err != nil {
+			return nil, err
+		}
+		log.Printf("mock model status: %s\n", options.mockStatus)
+	}
+	ctl, err := mock.NewController(script, logger.New(logger.AddPrefix("mock")))
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("mock model UUID: %s\n", ctl.ModelUUID())
+	return &juju.Controller{
+		Addr:    ctl.Addr,
+		Version: ctl.JujuVersion(),
+	}, nil
+}
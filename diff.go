@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/frankban/flagutils"
+
+	"github.com/juju/guiproxy/internal/capture"
+	"github.com/juju/guiproxy/internal/jsonpath"
+)
+
+// runDiff implements the diff command, comparing the two capture files
+// provided in the given arguments, and writing a report of the differences to
+// the standard output.
+func runDiff(name string, args []string) error {
+	fs := newFlagSet(name)
+	var ignore flagutils.StringSlice
+	fs.Var(&ignore, "ignore", `a comma separated list of paths, applied to objects including the "params", "response" and "error" of each call, selecting values ignored when comparing payloads, for instance:
+		-ignore 'response.applications.*.status.since,response.machines.*.agent-status.since'`)
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return fmt.Errorf("cannot compare captures: two capture files required, got %d", fs.NArg())
+	}
+	paths := make([]jsonpath.Path, len(ignore))
+	for i, s := range ignore {
+		p, err := jsonpath.Parse(s)
+		if err != nil {
+			return fmt.Errorf("cannot compare captures: invalid ignored path %q: %s", s, err)
+		}
+		paths[i] = p
+	}
+	a, err := capture.ReadFile(fs.Arg(0))
+	if err != nil {
+		return fmt.Errorf("cannot compare captures: %s", err)
+	}
+	b, err := capture.ReadFile(fs.Arg(1))
+	if err != nil {
+		return fmt.Errorf("cannot compare captures: %s", err)
+	}
+	fmt.Printf("--- %s\n+++ %s\n", fs.Arg(0), fs.Arg(1))
+	capture.Report(os.Stdout, capture.Diff(capture.Calls(a), capture.Calls(b), paths))
+	return nil
+}
@@ -1,8 +1,17 @@
 module github.com/juju/guiproxy
 
+go 1.19
+
 require (
 	github.com/frankban/flagutils v1.0.0
 	github.com/frankban/quicktest v1.0.0
 	github.com/google/go-cmp v0.2.0
 	github.com/gorilla/websocket v1.2.0
+	gopkg.in/yaml.v2 v2.4.0
+)
+
+require (
+	github.com/kr/pretty v0.1.0 // indirect
+	github.com/kr/pty v1.1.1 // indirect
+	github.com/kr/text v0.1.0 // indirect
 )
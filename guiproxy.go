@@ -4,19 +4,24 @@ import (
 	"flag"
 	"fmt"
 	"log"
-	"net/http"
+	"net"
 	"net/url"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"text/tabwriter"
+	"time"
 
 	"github.com/frankban/flagutils"
 
 	"github.com/juju/guiproxy/internal/guiconfig"
-	"github.com/juju/guiproxy/internal/juju"
 	"github.com/juju/guiproxy/internal/network"
+	"github.com/juju/guiproxy/internal/profile"
 	"github.com/juju/guiproxy/server"
+	"github.com/juju/guiproxy/throttle"
+	"github.com/juju/guiproxy/wsproxy"
 )
 
 // version holds the guiproxy program version.
@@ -24,70 +29,111 @@ const version = "1.2.0"
 
 var program = filepath.Base(os.Args[0])
 
-// main starts the proxy server.
+// main runs the command selected by the first argument, defaulting to the
+// serve command, which starts the proxy server.
 func main() {
-	// Retrieve information from flags and from Juju itself (if required).
-	options, err := parseOptions()
-	if err != nil {
-		log.Fatalf("cannot parse configuration options: %s", err)
-	}
-	log.Printf("%s %s\n", program, version)
-	if options.showVersion {
-		return
-	}
-	log.Println("configuring the server")
-	controllerAddr, err := juju.Info(options.controllerAddr)
-	if err != nil {
-		log.Fatalf("cannot retrieve Juju URLs: %s", err)
+	name, args := defaultCommand, os.Args[1:]
+	if len(args) != 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
 	}
-	log.Printf("GUI sandbox: %s\n", options.guiURL)
-	log.Printf("controller: %s\n", controllerAddr)
-	if options.legacyJuju {
-		log.Println("using Juju 1")
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "%s: unknown command %q\n\n", program, name)
+		usage()
+		os.Exit(2)
 	}
-	if options.envName != "" {
-		log.Printf("environment: %s\n", options.envName)
-	}
-	if len(options.guiConfig) != 0 {
-		log.Println("GUI config has been customized")
-	}
-
-	// Set up the HTTP server.
-	srv := server.New(server.Params{
-		ControllerAddr: controllerAddr,
-		GUIURL:         options.guiURL,
-		GUIConfig:      options.guiConfig,
-		BaseURL:        options.baseURL,
-		LegacyJuju:     options.legacyJuju,
-		NoColor:        options.noColor,
-	})
-
-	// Start the GUI proxy server.
-	log.Print("starting the server\n\n")
-	printAddresses(options.port, options.baseURL)
-	if err := http.ListenAndServe(":"+strconv.Itoa(options.port), srv); err != nil {
-		log.Fatalf("cannot start server: %s", err)
+	if err := cmd.run(name, args); err != nil {
+		log.Fatal(err)
 	}
 }
 
-// parseOptions returns the GUI proxy server configuration options.
-func parseOptions() (*config, error) {
-	flag.Usage = usage
-	port := flag.Int("port", defaultPort, "GUI proxy server port")
-	guiAddr := flag.String("gui", defaultGUIAddr, "address on which the GUI in sandbox mode is listening")
-	controllerAddr := flag.String("controller", "", `controller address (defaults to the address of the current controller), for instance:
+// parseOptions defines the GUI proxy server flags in the given flag set, and
+// returns the configuration options resulting from parsing the given
+// arguments. Positional arguments are left in the flag set.
+func parseOptions(fs *flag.FlagSet, args []string) (*config, error) {
+	port := fs.Int("port", defaultPort, "GUI proxy server port")
+	listen := fs.String("listen", "", `address on which the GUI proxy server listens, as "host" or "host:port" (defaults to all interfaces), for instance:
+		-listen localhost
+		-listen 10.0.0.1:8042`)
+	auth := fs.String("auth", "", `require clients to authenticate with the given "username:password" credentials, using HTTP basic authentication; clients also sending Juju credentials, for instance to stream debug logs, must provide the proxy ones in the Proxy-Authorization header, as the Authorization one is forwarded to Juju`)
+	token := fs.String("token", "", "require clients to provide the given secret token, either in the "+server.TokenParam+" query parameter or in the resulting cookie")
+	allowFrom := sliceFlag(fs, "allow-from", `a comma separated list of networks, in CIDR notation, or IP addresses clients are allowed to connect from, for instance:
+		-allow-from 10.0.0.0/8,192.168.1.42`)
+	guiAddr := fs.String("gui", defaultGUIAddr, "address on which the GUI in sandbox mode is listening")
+	controllerAddr := fs.String("controller", "", `controller address (defaults to the address of the current controller), for instance:
 		-controller jimm.jujucharms.com:443`)
-	guiConfig := flagutils.Map("config", nil, `override or extend GUI options with a JSON key/value string, with or without enclosing braces, for instance:
+	guiConfig := mapFlag(fs, "config", `override or extend GUI options with a JSON key/value string, with or without enclosing braces, for instance:
 		-config '{"gisf": true}'
 		-config '"gisf": true, "charmstoreURL": "https://1.2.3.4/cs"'
 		-config '"flags": {"exterminate": true}'`)
-	envName := flag.String("env", "", "select a predefined environment to run against between the following:\n"+envChoices())
-	flags := flagutils.Slice("flags", nil, `a comma separated list of GUI feature flags to activate, for instance:
+	envName := fs.String("env", "", "select a predefined environment to run against between the following:\n"+envChoices())
+	flags := sliceFlag(fs, "flags", `a comma separated list of GUI feature flags to activate, for instance:
 		- flags profile,status`)
-	legacyJuju := flag.Bool("juju1", false, "connect to a Juju 1 model")
-	noColor := flag.Bool("nocolor", false, "do not use colors")
-	showVersion := flag.Bool("version", false, "show application version and exit")
-	flag.Parse()
+	redact := sliceFlag(fs, "redact", `a comma separated list of additional "[Facade.Method:]path" rules selecting JSON values to hide in the logged WebSocket frames, for instance:
+		-redact 'Application.Deploy:params.applications.*.config,params.secret'`)
+	noRedact := fs.Bool("noredact", false, "do not hide known sensitive values (like credentials, macaroons, passwords and SSH keys) in the logged WebSocket frames")
+	shellAddr := fs.String("shell", "", `address of a jujushell server to proxy, also used to configure the GUI terminal, for instance:
+		-shell localhost:8047
+		-shell wss://shell.jujugui.org/ws/`)
+	compress := fs.Bool("compress", false, "negotiate permessage-deflate compression on WebSocket connections, and report compression ratios")
+	bufferSize := fs.Int("ws-buffer-size", 65536, "WebSocket read and write buffer sizes in bytes")
+	readLimit := fs.Int64("ws-read-limit", 0, "maximum size in bytes of WebSocket messages (0 means no limit)")
+	throttleProfile := fs.String("throttle", "", `emulate network conditions on the proxied traffic, using a predefined profile between `+strings.Join(throttle.ProfileNames(), ", ")+`, or custom settings, for instance:
+		-throttle 3g
+		-throttle 'slow-vpn,loss=5%'
+		-throttle 'bandwidth=2mbit,latency=100ms,jitter=10ms,loss=0.5%'`)
+	pingInterval := fs.Duration("ping-interval", 0, `interval at which WebSocket pings are sent to both the GUI and Juju, so that idle connections are kept alive (0 means no pings), for instance:
+		-ping-interval 30s`)
+	readTimeout := fs.Duration("read-timeout", 0, `close WebSocket connections when no frames, including pongs, are received from the GUI or Juju for the given duration, usually used with -ping-interval (0 means no timeout), for instance:
+		-read-timeout 90s`)
+	idleTimeout := fs.Duration("idle-timeout", 0, "close WebSocket connections when no messages are exchanged for the given duration (0 means no timeout)")
+	rulesPath := fs.String("rules", "", `path to a JSON file with a list of rules used to rewrite WebSocket frames, each one including an optional "Facade.Method" pattern and "request" or "response" direction, a JSON path, and a "set", "replace" (optionally only values equal to "match") or "delete" action, for instance:
+		[{"method": "Admin.Login", "direction": "response", "path": "response.server-version", "action": "set", "value": "2.42.0"}]`)
+	facadeVersions := mapFlag(fs, "facade-versions", `override the facade versions advertised by Juju on login with a JSON facade/version string, with or without enclosing braces, where a zero version hides the facade, for instance:
+		-facade-versions '"Application": 1, "Bundle": 0'`)
+	rewriteVersions := fs.Bool("rewrite-versions", false, "when -facade-versions is set, also rewrite the version in requests to the overridden facades")
+	cookieJar := fs.String("reuse-login-macaroons", "", `path to a Juju cookie jar whose macaroons, previously discharged by "juju login", are reused to log in on behalf of the GUI when it does not provide its own; no discharge is performed by the proxy, so the GUI falls back to its interactive identity flow if the jar has no valid macaroons for the controller (in which case run "juju login" again); use "`+currentCookieJar+`" for the jar of the current controller, for instance:
+		-reuse-login-macaroons `+currentCookieJar+`
+		-reuse-login-macaroons ~/.local/share/juju/cookies/jaas.json`)
+	allowedHosts := sliceFlag(fs, "allow", `a comma separated list of additional "host[:port]" addresses, with optional * wildcards, the GUI is allowed to connect to through the proxy (the controller and its endpoints are always allowed), for instance:
+		-allow '*.jujucharms.com:443,10.0.0.1'`)
+	mockScript := fs.String("mock", "", `path to a YAML or JSON script driving an in-process fake controller used in place of Juju, describing canned responses, expected calls and AllWatcher deltas emitted on a timer, for instance:
+		-mock scale-up.yaml`)
+	mockStatus := fs.String("mock-status", "", `path to the output of "juju status --format json", or to a recorded Client.FullStatus response, served as the model of the fake controller, also used when -mock is not set, for instance:
+		-mock-status customer-status.json`)
+	legacyJuju := fs.Bool("juju1", false, "connect to a Juju 1 model (automatically detected when connecting to the current Juju 1 environment)")
+	noColor := fs.Bool("nocolor", false, "do not use colors")
+	prettyLog := fs.Bool("log-pretty", false, "indent and highlight JSON WebSocket frames in the log output")
+	logLimit := fs.Int("log-limit", 0, "when -log-pretty is set, truncate strings and arrays longer than this limit (0 means no truncation)")
+	logDir := fs.String("log-dir", "", "log the WebSocket traffic of each connection to a separate file in the given directory, only showing connection lifecycle in the terminal")
+	captureDir := fs.String("capture", "", `capture the JSON WebSocket frames of each connection to a separate file in the given directory, with sensitive values hidden as in the log output; captures can be compared by running:
+		`+program+` diff a.capture b.capture`)
+	logMaxSize := fs.Int("log-max-size", 10, "when -log-dir is set, rotate log files bigger than the given size in megabytes (0 means no rotation)")
+	logInclude := sliceFlag(fs, "log-include", `a comma separated list of "Facade.Method" patterns (with optional * wildcards) or /regular expressions/ matched against the frame content, selecting the WebSocket frames to log, for instance:
+		-log-include 'Client.FullStatus,Application.*'
+		-log-include '/"error":/'`)
+	logExclude := sliceFlag(fs, "log-exclude", `a comma separated list of expressions, with the same syntax used by -log-include, selecting the WebSocket frames not to log, for instance:
+		-log-exclude 'Pinger.Ping,AllWatcher.*'`)
+	profileName := fs.String("profile", "", `use the options defined in the given profile of the `+profile.DefaultPath()+` configuration file for the flags not provided on the command line or with environment variables, for instance:
+		-profile jaas`)
+	showVersion := fs.Bool("version", false, "show application version and exit")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	// Flags provided on the command line take precedence over environment
+	// variables, which in turn take precedence over profile options.
+	if err := profile.Environ(fs, envPrefix).Apply(fs); err != nil {
+		return nil, fmt.Errorf("cannot use %s* environment variables: %s", envPrefix, err)
+	}
+	if *profileName != "" {
+		p, err := profile.Get(profile.DefaultPath(), *profileName)
+		if err != nil {
+			return nil, fmt.Errorf("cannot use profile: %s", err)
+		}
+		if err := p.Apply(fs); err != nil {
+			return nil, fmt.Errorf("cannot use profile %q: %s", *profileName, err)
+		}
+	}
 
 	if !strings.HasPrefix(*guiAddr, "http") {
 		*guiAddr = "http://" + *guiAddr
@@ -106,45 +152,258 @@ func parseOptions() (*config, error) {
 		return nil, fmt.Errorf("cannot parse base URL in config: %s", err)
 	}
 
+	logFilter, err := wsproxy.NewFilter(*logInclude, *logExclude)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse log filter: %s", err)
+	}
+
+	shellURL, err := shellURL(*shellAddr)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse jujushell address: %s", err)
+	}
+	profile, err := throttle.Parse(*throttleProfile)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse network conditions: %s", err)
+	}
+	redactor, err := wsproxy.NewRedactor(*redact, !*noRedact)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse redaction rules: %s", err)
+	}
+
+	listenHost, listenPort, err := parseListenAddr(*listen, *port)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse listen address: %s", err)
+	}
+	access, err := parseAccess(*auth, *token, *allowFrom)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse access control options: %s", err)
+	}
+
+	var transformers []wsproxy.Transformer
+	if *rulesPath != "" {
+		rules, err := wsproxy.ReadRules(*rulesPath)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read rewriting rules: %s", err)
+		}
+		transformers = append(transformers, rules)
+	}
+
+	versions, err := parseFacadeVersions(*facadeVersions)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse facade versions: %s", err)
+	}
+
 	if *controllerAddr == "" && env.ControllerAddr != "" {
 		*controllerAddr = env.ControllerAddr
 	}
 	return &config{
-		port:           *port,
-		guiURL:         guiURL,
-		controllerAddr: *controllerAddr,
-		envName:        env.Name,
-		guiConfig:      overrides,
-		baseURL:        baseURL,
-		legacyJuju:     *legacyJuju,
-		noColor:        *noColor,
-		showVersion:    *showVersion,
+		port:            listenPort,
+		listenHost:      listenHost,
+		access:          access,
+		guiURL:          guiURL,
+		controllerAddr:  *controllerAddr,
+		mockScript:      *mockScript,
+		mockStatus:      *mockStatus,
+		envName:         env.Name,
+		guiConfig:       overrides,
+		baseURL:         baseURL,
+		legacyJuju:      *legacyJuju,
+		noColor:         *noColor,
+		prettyLog:       *prettyLog,
+		logLimit:        *logLimit,
+		shellURL:        shellURL,
+		compress:        *compress,
+		bufferSize:      *bufferSize,
+		readLimit:       *readLimit,
+		throttle:        profile,
+		pingInterval:    *pingInterval,
+		readTimeout:     *readTimeout,
+		idleTimeout:     *idleTimeout,
+		transformers:    transformers,
+		facadeVersions:  versions,
+		rewriteVersions: *rewriteVersions,
+		cookieJar:       *cookieJar,
+		allowedHosts:    *allowedHosts,
+		logDir:          *logDir,
+		logMaxSize:      int64(*logMaxSize) * 1024 * 1024,
+		captureDir:      *captureDir,
+		logFilter:       logFilter,
+		redactor:        redactor,
+		profile:         *profileName,
+		showVersion:     *showVersion,
 	}, nil
 }
 
 const (
+	// envPrefix holds the prefix of the environment variables that can be
+	// used in place of flags.
+	envPrefix = "GUIPROXY_"
+
 	defaultPort    = 8042
 	defaultGUIAddr = "http://localhost:6543"
+
+	// currentCookieJar holds the value used to select the cookie jar of the
+	// current Juju controller.
+	currentCookieJar = "current"
+
+	// defaultShellPath holds the path on which jujushell servers listen for
+	// WebSocket connections.
+	defaultShellPath = "/ws/"
 )
 
 // config holds the GUI proxy server configuration options.
 type config struct {
-	port           int
-	guiURL         *url.URL
-	controllerAddr string
-	envName        string
-	guiConfig      map[string]interface{}
-	baseURL        string
-	legacyJuju     bool
-	noColor        bool
-	showVersion    bool
+	port            int
+	listenHost      string
+	access          server.Access
+	guiURL          *url.URL
+	controllerAddr  string
+	mockScript      string
+	mockStatus      string
+	replay          []string
+	envName         string
+	guiConfig       map[string]interface{}
+	baseURL         string
+	legacyJuju      bool
+	noColor         bool
+	prettyLog       bool
+	logLimit        int
+	shellURL        string
+	compress        bool
+	bufferSize      int
+	readLimit       int64
+	throttle        *throttle.Profile
+	pingInterval    time.Duration
+	readTimeout     time.Duration
+	idleTimeout     time.Duration
+	transformers    []wsproxy.Transformer
+	facadeVersions  map[string]int
+	rewriteVersions bool
+	cookieJar       string
+	allowedHosts    []string
+	logDir          string
+	logMaxSize      int64
+	captureDir      string
+	logFilter       *wsproxy.Filter
+	redactor        *wsproxy.Redactor
+	profile         string
+	showVersion     bool
+}
+
+// shellURL returns the WebSocket URL of the jujushell server at the given
+// address. If the address does not include the scheme, an insecure WebSocket
+// connection to the default jujushell path is assumed.
+func shellURL(addr string) (string, error) {
+	if addr == "" {
+		return "", nil
+	}
+	if !strings.HasPrefix(addr, "ws://") && !strings.HasPrefix(addr, "wss://") {
+		addr = "ws://" + strings.TrimSuffix(addr, "/") + defaultShellPath
+	}
+	u, err := url.Parse(addr)
+	if err != nil {
+		return "", err
+	}
+	if u.Host == "" {
+		return "", fmt.Errorf("invalid address %q: missing host", addr)
+	}
+	return u.String(), nil
+}
+
+// parseFacadeVersions returns the facade versions included in the given
+// decoded JSON map, validating them.
+func parseFacadeVersions(m map[string]interface{}) (map[string]int, error) {
+	if len(m) == 0 {
+		return nil, nil
+	}
+	versions := make(map[string]int, len(m))
+	for name, value := range m {
+		v, ok := value.(float64)
+		if !ok || v < 0 || v != float64(int(v)) {
+			return nil, fmt.Errorf("invalid version %v for facade %q", value, name)
+		}
+		versions[name] = int(v)
+	}
+	return versions, nil
+}
+
+// parseListenAddr returns the host and port on which the server listens,
+// based on the given listen address, in the "host" or "host:port" form, and
+// default port.
+func parseListenAddr(addr string, defaultPort int) (string, int, error) {
+	if addr == "" || !strings.Contains(addr, ":") || net.ParseIP(addr) != nil {
+		return strings.Trim(addr, "[]"), defaultPort, nil
+	}
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", 0, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid port %q", portStr)
+	}
+	return host, port, nil
+}
+
+// parseAccess returns the access control settings from the given basic
+// authentication credentials, in the "username:password" form, token and
+// allowed networks.
+func parseAccess(auth, token string, allowFrom []string) (server.Access, error) {
+	var access server.Access
+	if auth != "" {
+		parts := strings.SplitN(auth, ":", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return access, fmt.Errorf(`invalid credentials: must be in the "username:password" form`)
+		}
+		access.Username, access.Password = parts[0], parts[1]
+	}
+	access.Token = token
+	nets, err := server.ParseNetworks(allowFrom)
+	if err != nil {
+		return access, fmt.Errorf("invalid allowed networks: %s", err)
+	}
+	if len(nets) != 0 {
+		access.AllowedNetworks = nets
+	}
+	return access, nil
 }
 
 // usage provides the command help and usage information.
 func usage() {
 	fmt.Fprintf(os.Stderr, "The %s command proxies WebSocket requests from the GUI sandbox to a Juju controller.\n", program)
-	fmt.Fprintf(os.Stderr, "Usage of %s:\n", program)
-	flag.PrintDefaults()
+	fmt.Fprintf(os.Stderr, "Usage: %s [command] [flags] [args]\n", program)
+	fmt.Fprintf(os.Stderr, "Commands:\n")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	w := tabwriter.NewWriter(os.Stderr, 0, 8, 2, ' ', 0)
+	for _, name := range names {
+		help := commands[name].help
+		if name == defaultCommand {
+			help += " (default)"
+		}
+		fmt.Fprintf(w, "  %s\t%s\n", name, help)
+	}
+	w.Flush()
+	fmt.Fprintf(os.Stderr, "Run \"%s <command> -h\" for help on each command.\n", program)
+	fmt.Fprintf(os.Stderr, "Flags can also be set with %s* environment variables, for instance %s=1024 for -ws-buffer-size 1024; flags on the command line take precedence.\n", envPrefix, profile.EnvName(envPrefix, "ws-buffer-size"))
+}
+
+// sliceFlag defines a flag in the given flag set holding a comma separated
+// list of strings.
+func sliceFlag(fs *flag.FlagSet, name, usage string) *flagutils.StringSlice {
+	var s flagutils.StringSlice
+	fs.Var(&s, name, usage)
+	return &s
+}
+
+// mapFlag defines a flag in the given flag set holding a JSON object.
+func mapFlag(fs *flag.FlagSet, name, usage string) *flagutils.StringMap {
+	var m flagutils.StringMap
+	fs.Var(&m, name, usage)
+	return &m
 }
 
 // envChoices pretty formats GUI environment choices.
@@ -157,16 +416,39 @@ func envChoices() string {
 }
 
 // printAddresses prints the URL addresses from which is possible to reach the
-// GUI as served by guiproxy.
-func printAddresses(port int, base string) {
-	addrs, err := network.Addresses()
-	if err != nil || len(addrs) == 0 {
-		log.Printf("visit the GUI at http://localhost:%d%s\n", port, base)
+// GUI as served by guiproxy listening on the given host. If a token is
+// required, it is included in the URLs.
+func printAddresses(host string, port int, base, token string) {
+	urls := guiURLs(host, port, base, token)
+	if len(urls) == 1 {
+		log.Printf("visit the GUI at %s\n", urls[0])
 		return
 	}
+	lines := make([]string, len(urls))
+	for i, u := range urls {
+		lines[i] = "  " + u + "\n"
+	}
+	log.Printf("visit the GUI at any of the following addresses:\n%s\n", strings.Join(lines, ""))
+}
+
+// guiURLs returns the URLs from which is possible to reach the GUI, as
+// described in printAddresses.
+func guiURLs(host string, port int, base, token string) []string {
+	if token != "" {
+		base += "?" + url.Values{server.TokenParam: {token}}.Encode()
+	}
+	var addrs []string
+	if ip := net.ParseIP(host); host != "" && (ip == nil || !ip.IsUnspecified()) {
+		addrs = []string{host}
+	} else if all, err := network.Addresses(); err == nil {
+		addrs = all
+	}
+	if len(addrs) == 0 {
+		return []string{fmt.Sprintf("http://localhost:%d%s", port, base)}
+	}
 	urls := make([]string, len(addrs))
 	for i, addr := range addrs {
-		urls[i] = fmt.Sprintf("  http://%s:%d%s\n", addr, port, base)
+		urls[i] = fmt.Sprintf("http://%s%s", net.JoinHostPort(addr, strconv.Itoa(port)), base)
 	}
-	log.Printf("visit the GUI at any of the following addresses:\n%s\n", strings.Join(urls, ""))
+	return urls
 }
@@ -0,0 +1,73 @@
+package guiproxytest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// Client is a minimal Juju API client used to make RPC calls through the
+// proxy, as the GUI would do.
+type Client struct {
+	// Conn holds the underlying WebSocket connection.
+	Conn *websocket.Conn
+
+	mu     sync.Mutex
+	lastID uint64
+}
+
+// Call calls the given facade method with the given parameters, and decodes
+// the response into result, if not nil. Calls are made sequentially.
+func (c *Client) Call(facade string, version int, method string, params, result interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lastID++
+	req := map[string]interface{}{
+		"request-id": c.lastID,
+		"type":       facade,
+		"version":    version,
+		"request":    method,
+	}
+	if params != nil {
+		req["params"] = params
+	}
+	if err := c.Conn.WriteJSON(req); err != nil {
+		return fmt.Errorf("cannot send request: %s", err)
+	}
+	var resp struct {
+		RequestID uint64          `json:"request-id"`
+		Response  json.RawMessage `json:"response"`
+		Error     string          `json:"error"`
+	}
+	if err := c.Conn.ReadJSON(&resp); err != nil {
+		return fmt.Errorf("cannot read response: %s", err)
+	}
+	if resp.RequestID != c.lastID {
+		return fmt.Errorf("unexpected response id %d, expected %d", resp.RequestID, c.lastID)
+	}
+	if resp.Error != "" {
+		return errors.New(resp.Error)
+	}
+	if result == nil || len(resp.Response) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(resp.Response, result); err != nil {
+		return fmt.Errorf("cannot decode response: %s", err)
+	}
+	return nil
+}
+
+// Login logs in as the admin user.
+func (c *Client) Login() error {
+	return c.Call("Admin", 3, "Login", map[string]interface{}{
+		"auth-tag": "user-admin",
+	}, nil)
+}
+
+// Close closes the client connection.
+func (c *Client) Close() error {
+	return c.Conn.Close()
+}
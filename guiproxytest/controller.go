@@ -0,0 +1,148 @@
+package guiproxytest
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/juju/guiproxy/internal/mock"
+)
+
+// NewController starts and returns a fake Juju controller, serving the Juju
+// WebSocket API over TLS on all paths, for instance "/api" for the controller
+// and "/model/<uuid>/api" for models. By default, login, ping and AllWatcher
+// requests succeed and all other calls return an error: use Handle and Expect
+// to script responses. The controller must be closed when done.
+func NewController() *Controller {
+	ctl, err := mock.NewController(&mock.Script{}, nil)
+	if err != nil {
+		panic(err)
+	}
+	return &Controller{
+		Addr:       ctl.Addr,
+		controller: ctl,
+		handlers:   make(map[string]HandlerFunc),
+		expected:   make(map[string][]HandlerFunc),
+	}
+}
+
+// DefaultJujuVersion holds the Juju version reported by fake controllers.
+const DefaultJujuVersion = mock.DefaultJujuVersion
+
+// Controller is a fake Juju controller.
+type Controller struct {
+	// Addr holds the "host:port" address of the controller.
+	Addr string
+
+	controller *mock.Controller
+
+	mu       sync.Mutex
+	handlers map[string]HandlerFunc
+	expected map[string][]HandlerFunc
+}
+
+// Call holds an RPC call received by the fake controller.
+type Call = mock.Call
+
+// HandlerFunc is used to respond to RPC calls. The returned response is
+// encoded as JSON. If an error is returned, an RPC error response is sent.
+type HandlerFunc = mock.HandlerFunc
+
+// Handle registers the given handler for all calls to the given
+// "Facade.Method", replacing any previously registered handler.
+func (c *Controller) Handle(method string, h HandlerFunc) {
+	c.mu.Lock()
+	c.handlers[method] = h
+	c.mu.Unlock()
+	c.controller.Handle(method, c.dispatch)
+}
+
+// Expect queues the given response for the next call to the given
+// "Facade.Method". Queued responses are used once, in order, and take
+// precedence over handlers registered with Handle.
+func (c *Controller) Expect(method string, response interface{}) {
+	c.expect(method, func(Call) (interface{}, error) {
+		return response, nil
+	})
+}
+
+// ExpectError queues an error response for the next call to the given
+// "Facade.Method", as described in Expect.
+func (c *Controller) ExpectError(method string, err error) {
+	c.expect(method, func(Call) (interface{}, error) {
+		return nil, err
+	})
+}
+
+// Pending returns the sorted "Facade.Method" of expected calls not received
+// yet, repeated for each queued response.
+func (c *Controller) Pending() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var pending []string
+	for method, handlers := range c.expected {
+		for range handlers {
+			pending = append(pending, method)
+		}
+	}
+	sort.Strings(pending)
+	return pending
+}
+
+// Calls returns the RPC calls received so far, in order.
+func (c *Controller) Calls() []Call {
+	return c.controller.Calls()
+}
+
+// Close shuts down the fake controller.
+func (c *Controller) Close() {
+	c.controller.Close()
+}
+
+// expect queues the given handler for the next call to the given
+// "Facade.Method".
+func (c *Controller) expect(method string, h HandlerFunc) {
+	c.mu.Lock()
+	c.expected[method] = append(c.expected[method], h)
+	c.mu.Unlock()
+	c.controller.Handle(method, c.dispatch)
+}
+
+// dispatch responds to the given call using the next queued response or the
+// registered handler, and lets the underlying controller respond otherwise.
+func (c *Controller) dispatch(call Call) (interface{}, error) {
+	h := c.handler(call.Facade + "." + call.Method)
+	if h == nil {
+		return nil, mock.ErrNotHandled
+	}
+	return h(call)
+}
+
+// handler returns the handler to use for the next call to the given
+// "Facade.Method", consuming queued responses, or nil if there is none.
+func (c *Controller) handler(method string) HandlerFunc {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if handlers := c.expected[method]; len(handlers) != 0 {
+		if len(handlers) == 1 {
+			delete(c.expected, method)
+		} else {
+			c.expected[method] = handlers[1:]
+		}
+		return handlers[0]
+	}
+	return c.handlers[method]
+}
+
+// LoginHandler returns a handler for Admin.Login calls, responding with a
+// successful login result including the given server version.
+func LoginHandler(version string) HandlerFunc {
+	return func(Call) (interface{}, error) {
+		return map[string]interface{}{
+			"server-version": version,
+			"user-info": map[string]interface{}{
+				"identity":          "user-admin",
+				"controller-access": "superuser",
+			},
+		}, nil
+	}
+}
@@ -0,0 +1,138 @@
+package guiproxytest_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/juju/guiproxy/guiproxytest"
+	"github.com/juju/guiproxy/server"
+)
+
+func TestProxy(t *testing.T) {
+	c := qt.New(t)
+	proxy := guiproxytest.New(guiproxytest.Params{})
+	defer proxy.Close()
+
+	// Script the controller responses.
+	proxy.Controller.Handle("Client.FullStatus", func(call guiproxytest.Call) (interface{}, error) {
+		return map[string]interface{}{
+			"model": map[string]interface{}{"name": "default"},
+		}, nil
+	})
+	proxy.Controller.Expect("Application.Get", map[string]interface{}{"application": "django"})
+	proxy.Controller.ExpectError("Application.Get", errors.New("application not found"))
+	proxy.Controller.Expect("Pinger.Ping", nil)
+
+	// Connect to a model and make some calls.
+	client, err := proxy.Dial(proxy.ModelPath("uuid"))
+	c.Assert(err, qt.Equals, nil)
+	defer client.Close()
+	err = client.Login()
+	c.Assert(err, qt.Equals, nil)
+
+	var status struct {
+		Model struct {
+			Name string `json:"name"`
+		} `json:"model"`
+	}
+	err = client.Call("Client", 1, "FullStatus", nil, &status)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(status.Model.Name, qt.Equals, "default")
+
+	var app map[string]string
+	err = client.Call("Application", 4, "Get", map[string]string{"application": "django"}, &app)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(app, qt.DeepEquals, map[string]string{"application": "django"})
+	err = client.Call("Application", 4, "Get", map[string]string{"application": "rails"}, &app)
+	c.Assert(err, qt.ErrorMatches, "application not found")
+	err = client.Call("Application", 4, "Get", nil, nil)
+	c.Assert(err, qt.ErrorMatches, "unexpected call to Application.Get")
+
+	// Calls have been received by the controller.
+	calls := proxy.Controller.Calls()
+	c.Assert(calls, qt.HasLen, 5)
+	c.Assert(calls[1].Path, qt.Equals, "/model/uuid/api")
+	c.Assert(calls[1].Facade, qt.Equals, "Client")
+	c.Assert(calls[1].Method, qt.Equals, "FullStatus")
+	c.Assert(string(calls[2].Params), qt.Equals, `{"application":"django"}`)
+	c.Assert(calls[2].Version, qt.Equals, 4)
+	c.Assert(proxy.Controller.Pending(), qt.DeepEquals, []string{"Pinger.Ping"})
+
+	// Traffic has been captured.
+	c.Assert(proxy.Traffic.Requests("Application.Get"), qt.DeepEquals, []string{
+		`{"params":{"application":"django"},"request":"Get","request-id":3,"type":"Application","version":4}`,
+		`{"params":{"application":"rails"},"request":"Get","request-id":4,"type":"Application","version":4}`,
+		`{"request":"Get","request-id":5,"type":"Application","version":4}`,
+	})
+	logins := proxy.Traffic.Responses("Admin.Login")
+	c.Assert(logins, qt.HasLen, 1)
+	c.Assert(logins[0], qt.Matches, `{"request-id":1,"response":{.*"server-version":"2.3.1",.*}}`)
+	c.Assert(proxy.Traffic.Frames(), qt.HasLen, 10)
+	proxy.Traffic.Reset()
+	c.Assert(proxy.Traffic.Frames(), qt.HasLen, 0)
+}
+
+func TestProxyGUI(t *testing.T) {
+	c := qt.New(t)
+	proxy := guiproxytest.New(guiproxytest.Params{})
+	defer proxy.Close()
+
+	// The fake GUI is served.
+	resp, err := http.Get(proxy.URL + "/static/gui.js")
+	c.Assert(err, qt.Equals, nil)
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(string(b), qt.Equals, "gui: /static/gui.js")
+
+	// The GUI configuration points to the fake controller.
+	resp, err = http.Get(proxy.URL + "/config.js")
+	c.Assert(err, qt.Equals, nil)
+	defer resp.Body.Close()
+	b, err = ioutil.ReadAll(resp.Body)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(string(b), qt.Matches, `(?s).*"apiAddress": "`+proxy.Controller.Addr+`".*`)
+}
+
+func TestProxyControllerPath(t *testing.T) {
+	c := qt.New(t)
+	proxy := guiproxytest.New(guiproxytest.Params{})
+	defer proxy.Close()
+	client, err := proxy.Dial(proxy.ControllerPath())
+	c.Assert(err, qt.Equals, nil)
+	defer client.Close()
+	err = client.Login()
+	c.Assert(err, qt.Equals, nil)
+	calls := proxy.Controller.Calls()
+	c.Assert(calls, qt.HasLen, 1)
+	c.Assert(calls[0].Path, qt.Equals, "/api")
+}
+
+func TestProxyTrafficAfterChanges(t *testing.T) {
+	c := qt.New(t)
+	proxy := guiproxytest.New(guiproxytest.Params{
+		Server: server.Params{
+			FacadeVersions:        map[string]int{"Client": 1},
+			RewriteFacadeVersions: true,
+		},
+	})
+	defer proxy.Close()
+	proxy.Controller.Expect("Client.FullStatus", nil)
+	client, err := proxy.Dial(proxy.ModelPath("uuid"))
+	c.Assert(err, qt.Equals, nil)
+	defer client.Close()
+	err = client.Call("Client", 3, "FullStatus", nil, nil)
+	c.Assert(err, qt.Equals, nil)
+
+	// The captured request is the one actually sent to the controller.
+	c.Assert(proxy.Traffic.Requests("Client.FullStatus"), qt.DeepEquals, []string{
+		`{"request":"FullStatus","request-id":1,"type":"Client","version":1}`,
+	})
+	calls := proxy.Controller.Calls()
+	c.Assert(calls, qt.HasLen, 1)
+	c.Assert(calls[0].Version, qt.Equals, 1)
+}
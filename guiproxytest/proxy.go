@@ -0,0 +1,119 @@
+// Package guiproxytest provides a test harness for GUI integration tests,
+// running the GUI proxy server wired to a fake Juju controller and a fake or
+// real GUI, with helpers to script RPC responses and inspect traffic.
+package guiproxytest
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/juju/guiproxy/server"
+	"github.com/juju/guiproxy/wsproxy"
+)
+
+// Params holds parameters for starting a proxy test harness.
+type Params struct {
+	// Server optionally holds parameters for the proxy server. The
+	// controller address is always set to the one of the fake controller.
+	Server server.Params
+
+	// GUIURL optionally holds the URL of a running GUI sandbox instance. If
+	// empty, a fake GUI is started, serving "gui: <path>" on all paths.
+	GUIURL *url.URL
+}
+
+// New starts and returns a GUI proxy server wired to a new fake controller
+// and, if required, a fake GUI. The proxy must be closed when done.
+func New(p Params) *Proxy {
+	prx := &Proxy{
+		Controller: NewController(),
+		Traffic:    &Traffic{},
+	}
+	guiURL := p.GUIURL
+	if guiURL == nil {
+		prx.GUI = httptest.NewServer(http.HandlerFunc(serveGUI))
+		guiURL = mustParseURL(prx.GUI.URL)
+	}
+	params := p.Server
+	params.ControllerAddr = prx.Controller.Addr
+	params.GUIURL = guiURL
+	if params.BaseURL == "" {
+		params.BaseURL = "/"
+	}
+	// Capture frames as sent, after all changes made by the proxy.
+	params.Observers = append(append([]wsproxy.Transformer(nil), params.Observers...), prx.Traffic)
+	prx.server = httptest.NewServer(server.New(params))
+	prx.URL = prx.server.URL
+	return prx
+}
+
+// Proxy is a running GUI proxy server used for testing.
+type Proxy struct {
+	// URL holds the URL of the proxy server, for instance
+	// "http://127.0.0.1:42424".
+	URL string
+
+	// Controller holds the fake controller behind the proxy.
+	Controller *Controller
+
+	// GUI holds the fake GUI server, or nil if a real GUI is used.
+	GUI *httptest.Server
+
+	// Traffic holds the frames captured while proxying.
+	Traffic *Traffic
+
+	server *httptest.Server
+}
+
+// ControllerPath returns the proxy path for controller connections.
+func (p *Proxy) ControllerPath() string {
+	return "/controller/?controller=" + p.Controller.Addr
+}
+
+// ModelPath returns the proxy path for connections to the model with the
+// given UUID.
+func (p *Proxy) ModelPath(uuid string) string {
+	return fmt.Sprintf("/model/?model=%s&uuid=%s", p.Controller.Addr, url.QueryEscape(uuid))
+}
+
+// Dial opens a WebSocket connection to the given proxy path, for instance the
+// one returned by ModelPath, and returns a client using it.
+func (p *Proxy) Dial(path string) (*Client, error) {
+	u := strings.Replace(p.URL, "http://", "ws://", 1) + path
+	conn, _, err := websocket.DefaultDialer.Dial(u, nil)
+	if err != nil {
+		return nil, fmt.Errorf("cannot dial %s: %s", u, err)
+	}
+	return &Client{
+		Conn: conn,
+	}, nil
+}
+
+// Close shuts down the proxy and the fake servers.
+func (p *Proxy) Close() {
+	p.server.Close()
+	p.Controller.Close()
+	if p.GUI != nil {
+		p.GUI.Close()
+	}
+}
+
+// serveGUI serves the fake GUI.
+func serveGUI(w http.ResponseWriter, req *http.Request) {
+	io.WriteString(w, "gui: "+req.URL.Path)
+}
+
+// mustParseURL parses the given URL, and panics if it is not valid.
+func mustParseURL(rawurl string) *url.URL {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		panic(err)
+	}
+	return u
+}
@@ -0,0 +1,89 @@
+package guiproxytest
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"sync"
+
+	"github.com/juju/guiproxy/wsproxy"
+)
+
+// Traffic captures the JSON frames proxied between the GUI and the fake
+// controller. It implements wsproxy.Transformer without changing frames.
+type Traffic struct {
+	mu     sync.Mutex
+	frames []Frame
+}
+
+// Frame holds a captured frame.
+type Frame struct {
+	// Method holds the "Facade.Method" of the RPC call the frame is part of,
+	// or an empty string if not known.
+	Method string
+
+	// Request holds whether the frame is an RPC request.
+	Request bool
+
+	// Content holds the compact JSON content of the frame, as sent.
+	Content string
+}
+
+// Transform implements wsproxy.Transformer by capturing the given frame.
+func (t *Traffic) Transform(f *wsproxy.Frame) bool {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(f.Message); err != nil {
+		// This should never happen, as the message has been decoded from
+		// JSON in the first place.
+		panic(err)
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.frames = append(t.frames, Frame{
+		Method:  f.Method,
+		Request: f.Request,
+		Content: strings.TrimSuffix(buf.String(), "\n"),
+	})
+	return false
+}
+
+// Frames returns all the captured frames, in order.
+func (t *Traffic) Frames() []Frame {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]Frame(nil), t.frames...)
+}
+
+// Requests returns the content of the captured requests for the given
+// "Facade.Method", in order.
+func (t *Traffic) Requests(method string) []string {
+	return t.filter(method, true)
+}
+
+// Responses returns the content of the captured responses for the given
+// "Facade.Method", in order.
+func (t *Traffic) Responses(method string) []string {
+	return t.filter(method, false)
+}
+
+// filter returns the content of the frames with the given method and type.
+func (t *Traffic) filter(method string, request bool) []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var contents []string
+	for _, f := range t.frames {
+		if f.Method == method && f.Request == request {
+			contents = append(contents, f.Content)
+		}
+	}
+	return contents
+}
+
+// Reset discards all the captured frames.
+func (t *Traffic) Reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.frames = nil
+}
@@ -0,0 +1,140 @@
+// Package capture implements recording of the WebSocket traffic proxied
+// between the GUI and Juju, and comparison of recorded sessions.
+package capture
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/juju/guiproxy/wsproxy"
+)
+
+// Entry holds a captured JSON frame. Captures are stored as sequences of JSON
+// encoded entries, one per line.
+type Entry struct {
+	// Time holds when the frame was proxied.
+	Time time.Time `json:"time"`
+
+	// Request holds whether the frame is an RPC request.
+	Request bool `json:"request,omitempty"`
+
+	// Method holds the "Facade.Method" of the RPC call the frame is part of,
+	// if known.
+	Method string `json:"method,omitempty"`
+
+	// RequestID holds the id of the RPC call the frame is part of.
+	RequestID uint64 `json:"request-id,omitempty"`
+
+	// Content holds the frame content, with sensitive values redacted.
+	Content json.RawMessage `json:"content"`
+}
+
+// NewWriter returns a transformer capturing all frames to the given writer.
+// Sensitive values are hidden using the given redactor, which can be nil.
+func NewWriter(w io.Writer, redactor *wsproxy.Redactor) *Writer {
+	return &Writer{
+		w:        w,
+		redactor: redactor,
+	}
+}
+
+// Writer implements wsproxy.Transformer by capturing frames, which are left
+// unchanged. Since transformers are applied in order, it should be the last
+// one in order to capture the frames actually sent.
+type Writer struct {
+	mu       sync.Mutex
+	w        io.Writer
+	redactor *wsproxy.Redactor
+}
+
+// Transform implements wsproxy.Transformer by writing the frame as an entry.
+func (w *Writer) Transform(f *wsproxy.Frame) bool {
+	data, err := json.Marshal(f.Message)
+	if err != nil {
+		return false
+	}
+	content := w.redactor.Redact(f.Method, string(data))
+	e := Entry{
+		Time:      timeNow().UTC(),
+		Request:   f.Request,
+		Method:    f.Method,
+		RequestID: requestID(f.Message),
+		Content:   json.RawMessage(content),
+	}
+	data, err = json.Marshal(e)
+	if err != nil {
+		return false
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.w.Write(append(data, '\n'))
+	return false
+}
+
+// timeNow is defined as a variable for testing purposes.
+var timeNow = time.Now
+
+// requestID returns the request id included in the given decoded message,
+// in both the Juju 2 and Juju 1 formats, or zero if not found.
+func requestID(msg interface{}) uint64 {
+	m, ok := msg.(map[string]interface{})
+	if !ok {
+		return 0
+	}
+	for key, value := range m {
+		if !strings.EqualFold(key, "request-id") && !strings.EqualFold(key, "RequestId") {
+			continue
+		}
+		var id uint64
+		if _, err := fmt.Sscan(fmt.Sprint(value), &id); err == nil {
+			return id
+		}
+	}
+	return 0
+}
+
+// Read reads and returns the entries in the given capture.
+func Read(r io.Reader) ([]Entry, error) {
+	var entries []Entry
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, maxEntrySize)
+	for line := 1; scanner.Scan(); line++ {
+		data := scanner.Bytes()
+		if len(strings.TrimSpace(string(data))) == 0 {
+			continue
+		}
+		var e Entry
+		if err := json.Unmarshal(data, &e); err != nil {
+			return nil, fmt.Errorf("cannot decode entry at line %d: %s", line, err)
+		}
+		entries = append(entries, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("cannot read capture: %s", err)
+	}
+	return entries, nil
+}
+
+// maxEntrySize holds the maximum size of captured entries.
+const maxEntrySize = 64 * 1024 * 1024
+
+// ReadFile reads and returns the entries in the capture file at the given
+// path.
+func ReadFile(path string) ([]Entry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open capture file: %s", err)
+	}
+	defer f.Close()
+	entries, err := Read(f)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read capture file %q: %s", path, err)
+	}
+	return entries, nil
+}
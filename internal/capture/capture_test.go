@@ -0,0 +1,92 @@
+package capture_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/juju/guiproxy/internal/capture"
+	"github.com/juju/guiproxy/wsproxy"
+)
+
+func TestWriter(t *testing.T) {
+	c := qt.New(t)
+	defer c.Cleanup()
+	c.Patch(capture.TimeNow, func() time.Time {
+		return time.Date(2018, 1, 1, 12, 0, 0, 0, time.UTC)
+	})
+	redactor, err := wsproxy.NewRedactor([]string{"params.secret"}, false)
+	c.Assert(err, qt.Equals, nil)
+	var buf bytes.Buffer
+	w := capture.NewWriter(&buf, redactor)
+
+	changed := w.Transform(&wsproxy.Frame{
+		Method:  "Admin.Login",
+		Request: true,
+		Message: map[string]interface{}{
+			"request-id": json.Number("1"),
+			"type":       "Admin",
+			"request":    "Login",
+			"params":     map[string]interface{}{"secret": "bad wolf"},
+		},
+	})
+	c.Assert(changed, qt.Equals, false)
+	w.Transform(&wsproxy.Frame{
+		Method: "Admin.Login",
+		Message: map[string]interface{}{
+			"RequestId": json.Number("1"),
+			"Response":  map[string]interface{}{},
+		},
+	})
+
+	entries, err := capture.Read(&buf)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(entries, qt.HasLen, 2)
+	c.Assert(entries[0].Time.Equal(time.Date(2018, 1, 1, 12, 0, 0, 0, time.UTC)), qt.Equals, true)
+	c.Assert(entries[0].Request, qt.Equals, true)
+	c.Assert(entries[0].Method, qt.Equals, "Admin.Login")
+	c.Assert(entries[0].RequestID, qt.Equals, uint64(1))
+	c.Assert(string(entries[0].Content), qt.Equals, `{"params":{"secret":"[REDACTED]"},"request":"Login","request-id":1,"type":"Admin"}`)
+	c.Assert(entries[1].Request, qt.Equals, false)
+	c.Assert(entries[1].RequestID, qt.Equals, uint64(1))
+	c.Assert(string(entries[1].Content), qt.Equals, `{"RequestId":1,"Response":{}}`)
+}
+
+func TestRead(t *testing.T) {
+	c := qt.New(t)
+	entries, err := capture.Read(strings.NewReader(`
+{"time": "2018-01-01T12:00:00Z", "request": true, "method": "Pinger.Ping", "request-id": 1, "content": {}}
+
+{"time": "2018-01-01T12:00:01Z", "method": "Pinger.Ping", "request-id": 1, "content": {"response": {}}}
+`))
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(entries, qt.HasLen, 2)
+	c.Assert(entries[1].Method, qt.Equals, "Pinger.Ping")
+	c.Assert(string(entries[1].Content), qt.Equals, `{"response": {}}`)
+
+	entries, err = capture.Read(strings.NewReader("{}\nbad wolf\n"))
+	c.Assert(err, qt.ErrorMatches, "cannot decode entry at line 2: .*")
+	c.Assert(entries, qt.IsNil)
+}
+
+func TestReadFile(t *testing.T) {
+	c := qt.New(t)
+	defer c.Cleanup()
+	dir := c.Mkdir()
+	path := filepath.Join(dir, "a.capture")
+	err := ioutil.WriteFile(path, []byte(`{"method": "Pinger.Ping", "content": {}}`), 0600)
+	c.Assert(err, qt.Equals, nil)
+	entries, err := capture.ReadFile(path)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(entries, qt.HasLen, 1)
+
+	entries, err = capture.ReadFile(filepath.Join(dir, "missing.capture"))
+	c.Assert(err, qt.ErrorMatches, "cannot open capture file: .*")
+	c.Assert(entries, qt.IsNil)
+}
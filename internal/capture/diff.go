@@ -0,0 +1,433 @@
+package capture
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/juju/guiproxy/internal/jsonpath"
+)
+
+// Call holds a recorded RPC call.
+type Call struct {
+	// Method holds the called "Facade.Method".
+	Method string
+
+	// Params holds the decoded request parameters.
+	Params interface{}
+
+	// Response holds the decoded response, or nil if no response has been
+	// recorded or the call failed.
+	Response interface{}
+
+	// Error holds the returned error, if any.
+	Error interface{}
+
+	// Time and ResponseTime hold when the request and the response have
+	// been proxied. ResponseTime is zero if no response has been recorded.
+	Time, ResponseTime time.Time
+}
+
+// Calls returns the RPC calls in the given entries, in request order.
+// Responses are associated with requests using request ids.
+func Calls(entries []Entry) []Call {
+	var calls []Call
+	index := make(map[uint64]int)
+	for _, e := range entries {
+		var doc interface{}
+		if err := json.Unmarshal(e.Content, &doc); err != nil {
+			continue
+		}
+		if e.Request {
+			index[e.RequestID] = len(calls)
+			calls = append(calls, Call{
+				Method: e.Method,
+				Params: get(paramsPath, doc),
+				Time:   e.Time,
+			})
+			continue
+		}
+		i, ok := index[e.RequestID]
+		if !ok {
+			continue
+		}
+		delete(index, e.RequestID)
+		calls[i].Response = get(responsePath, doc)
+		calls[i].Error = get(errorPath, doc)
+		calls[i].ResponseTime = e.Time
+	}
+	return calls
+}
+
+var (
+	paramsPath   = mustParsePath("params")
+	responsePath = mustParsePath("response")
+	errorPath    = mustParsePath("error")
+)
+
+// mustParsePath parses the given JSON path, and panics if it is not valid.
+func mustParsePath(s string) jsonpath.Path {
+	p, err := jsonpath.Parse(s)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+// get returns the value at the given path in the given decoded document, or
+// nil if not found.
+func get(p jsonpath.Path, doc interface{}) interface{} {
+	if values := p.Get(doc); len(values) != 0 {
+		return values[0]
+	}
+	return nil
+}
+
+// ChangeKind describes how a call differs between two captures.
+type ChangeKind int
+
+const (
+	// Same is used for calls equal in both captures.
+	Same ChangeKind = iota
+	// Changed is used for calls with different payloads.
+	Changed
+	// Added is used for calls only present in the second capture.
+	Added
+	// Removed is used for calls only present in the first capture.
+	Removed
+)
+
+// Change holds the difference between two captures for a single call.
+type Change struct {
+	// Kind holds the kind of change.
+	Kind ChangeKind
+
+	// Method holds the "Facade.Method" of the call.
+	Method string
+
+	// Differences holds the payload differences of changed calls, in the
+	// "path: old -> new" form.
+	Differences []string
+}
+
+// Diff aligns the calls in the two given captures by method and sequence and
+// returns the resulting changes, in order. Values selected by the given paths,
+// applied to objects including the call "params", "response" and "error",
+// are ignored when comparing payloads.
+func Diff(a, b []Call, ignore []jsonpath.Path) []Change {
+	ids := make(map[string]int)
+	methodIDs := func(calls []Call) []int {
+		s := make([]int, len(calls))
+		for i, c := range calls {
+			id, ok := ids[c.Method]
+			if !ok {
+				id = len(ids)
+				ids[c.Method] = id
+			}
+			s[i] = id
+		}
+		return s
+	}
+	var changes []Change
+	i, j := 0, 0
+	for _, p := range commonSubsequence(methodIDs(a), methodIDs(b)) {
+		for ; i < p.a; i++ {
+			changes = append(changes, Change{
+				Kind:   Removed,
+				Method: a[i].Method,
+			})
+		}
+		for ; j < p.b; j++ {
+			changes = append(changes, Change{
+				Kind:   Added,
+				Method: b[j].Method,
+			})
+		}
+		diffs := differences("", payload(a[i], ignore), payload(b[j], ignore))
+		kind := Same
+		if len(diffs) != 0 {
+			kind = Changed
+		}
+		changes = append(changes, Change{
+			Kind:        kind,
+			Method:      a[i].Method,
+			Differences: diffs,
+		})
+		i++
+		j++
+	}
+	for ; i < len(a); i++ {
+		changes = append(changes, Change{
+			Kind:   Removed,
+			Method: a[i].Method,
+		})
+	}
+	for ; j < len(b); j++ {
+		changes = append(changes, Change{
+			Kind:   Added,
+			Method: b[j].Method,
+		})
+	}
+	return changes
+}
+
+// pair holds the indexes of equal items in two sequences.
+type pair struct {
+	a, b int
+}
+
+// commonSubsequence returns the indexes of the items of a longest common
+// subsequence of the given sequences, in order. Hirschberg's algorithm is
+// used, so that memory is linear in the length of the sequences, as captures
+// can include tens of thousands of calls. Earlier items are preferred when
+// several alignments are possible.
+func commonSubsequence(a, b []int) []pair {
+	var pairs []pair
+	hirschberg(a, b, 0, 0, &pairs)
+	return pairs
+}
+
+// hirschberg appends to pairs the indexes of a longest common subsequence of
+// the given sequences, which start at the given offsets in the original ones.
+func hirschberg(a, b []int, offA, offB int, pairs *[]pair) {
+	// Common prefixes are frequent and cheap to match.
+	n := 0
+	for n < len(a) && n < len(b) && a[n] == b[n] {
+		*pairs = append(*pairs, pair{offA + n, offB + n})
+		n++
+	}
+	a, b, offA, offB = a[n:], b[n:], offA+n, offB+n
+	switch {
+	case len(a) == 0 || len(b) == 0:
+		return
+	case len(a) == 1:
+		for j, v := range b {
+			if v == a[0] {
+				*pairs = append(*pairs, pair{offA, offB + j})
+				return
+			}
+		}
+		return
+	}
+	// Split b where the longest common subsequences of the two halves of a
+	// with the two parts of b are the longest.
+	mid := len(a) / 2
+	fwd := prefixLengths(a[:mid], b)
+	bwd := suffixLengths(a[mid:], b)
+	k, best := 0, -1
+	for j := range fwd {
+		if l := fwd[j] + bwd[j]; l > best {
+			k, best = j, l
+		}
+	}
+	hirschberg(a[:mid], b[:k], offA, offB, pairs)
+	hirschberg(a[mid:], b[k:], offA+mid, offB+k, pairs)
+}
+
+// prefixLengths returns the lengths of the longest common subsequences of a
+// and b[:j], indexed by j.
+func prefixLengths(a, b []int) []int {
+	prev, cur := make([]int, len(b)+1), make([]int, len(b)+1)
+	for _, v := range a {
+		for j := 1; j <= len(b); j++ {
+			switch {
+			case v == b[j-1]:
+				cur[j] = prev[j-1] + 1
+			case prev[j] >= cur[j-1]:
+				cur[j] = prev[j]
+			default:
+				cur[j] = cur[j-1]
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return prev
+}
+
+// suffixLengths returns the lengths of the longest common subsequences of a
+// and b[j:], indexed by j.
+func suffixLengths(a, b []int) []int {
+	n := len(b)
+	prev, cur := make([]int, n+1), make([]int, n+1)
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := n - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				cur[j] = prev[j+1] + 1
+			case prev[j] >= cur[j+1]:
+				cur[j] = prev[j]
+			default:
+				cur[j] = cur[j+1]
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return prev
+}
+
+// payload returns the decoded payload of the given call used for comparison,
+// without the values selected by the given paths.
+func payload(c Call, ignore []jsonpath.Path) interface{} {
+	doc := map[string]interface{}{
+		"params":   c.Params,
+		"response": c.Response,
+		"error":    c.Error,
+	}
+	if len(ignore) == 0 {
+		return doc
+	}
+	// Copy the document, so that the call is not modified.
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return doc
+	}
+	var cp interface{}
+	json.Unmarshal(data, &cp)
+	for _, p := range ignore {
+		p.Delete(cp)
+	}
+	return cp
+}
+
+// differences returns the differences between the given decoded JSON values
+// found at the given path.
+func differences(path string, a, b interface{}) []string {
+	switch av := a.(type) {
+	case map[string]interface{}:
+		bv, ok := b.(map[string]interface{})
+		if !ok {
+			break
+		}
+		keys := make([]string, 0, len(av)+len(bv))
+		for key := range av {
+			keys = append(keys, key)
+		}
+		for key := range bv {
+			if _, ok := av[key]; !ok {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		var diffs []string
+		for _, key := range keys {
+			aval, aok := av[key]
+			bval, bok := bv[key]
+			switch {
+			case !aok:
+				diffs = append(diffs, fmt.Sprintf("%s: added %s", join(path, key), format(bval)))
+			case !bok:
+				diffs = append(diffs, fmt.Sprintf("%s: removed %s", join(path, key), format(aval)))
+			default:
+				diffs = append(diffs, differences(join(path, key), aval, bval)...)
+			}
+		}
+		return diffs
+	case []interface{}:
+		bv, ok := b.([]interface{})
+		if !ok {
+			break
+		}
+		var diffs []string
+		for i := 0; i < len(av) || i < len(bv); i++ {
+			key := fmt.Sprint(i)
+			switch {
+			case i >= len(av):
+				diffs = append(diffs, fmt.Sprintf("%s: added %s", join(path, key), format(bv[i])))
+			case i >= len(bv):
+				diffs = append(diffs, fmt.Sprintf("%s: removed %s", join(path, key), format(av[i])))
+			default:
+				diffs = append(diffs, differences(join(path, key), av[i], bv[i])...)
+			}
+		}
+		return diffs
+	}
+	if reflect.DeepEqual(a, b) {
+		return nil
+	}
+	return []string{fmt.Sprintf("%s: %s -> %s", path, format(a), format(b))}
+}
+
+// join joins the given path and key.
+func join(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// maxValueLength holds the maximum length of values in reported differences.
+const maxValueLength = 60
+
+// format returns the JSON representation of the given value, truncated if
+// too long.
+func format(v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	s := string(data)
+	if len(s) > maxValueLength {
+		s = s[:maxValueLength-3] + "..."
+	}
+	return s
+}
+
+// maxDifferences holds the maximum number of reported differences per call.
+const maxDifferences = 10
+
+// Report writes a human readable report of the given changes to w, including
+// a line for each call prefixed by "+" (added), "-" (removed), "~" (changed)
+// or a space (same), followed by a summary of call counts by method.
+func Report(w io.Writer, changes []Change) {
+	countsA, countsB := make(map[string]int), make(map[string]int)
+	var totalA, totalB, added, removed, changed int
+	for _, c := range changes {
+		prefix := " "
+		switch c.Kind {
+		case Added:
+			prefix = "+"
+			added++
+		case Removed:
+			prefix = "-"
+			removed++
+		case Changed:
+			prefix = "~"
+			changed++
+		}
+		if c.Kind != Added {
+			countsA[c.Method]++
+			totalA++
+		}
+		if c.Kind != Removed {
+			countsB[c.Method]++
+			totalB++
+		}
+		fmt.Fprintf(w, "%s %s\n", prefix, c.Method)
+		for i, d := range c.Differences {
+			if i == maxDifferences {
+				fmt.Fprintf(w, "    ... and %d more differences\n", len(c.Differences)-i)
+				break
+			}
+			fmt.Fprintf(w, "    %s\n", d)
+		}
+	}
+	fmt.Fprintf(w, "\ncalls: %d -> %d (%d added, %d removed, %d changed)\n", totalA, totalB, added, removed, changed)
+	methods := make([]string, 0, len(countsA)+len(countsB))
+	for method := range countsA {
+		methods = append(methods, method)
+	}
+	for method := range countsB {
+		if _, ok := countsA[method]; !ok {
+			methods = append(methods, method)
+		}
+	}
+	sort.Strings(methods)
+	for _, method := range methods {
+		if countsA[method] != countsB[method] {
+			fmt.Fprintf(w, "%s: %d -> %d\n", method, countsA[method], countsB[method])
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package capture_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/juju/guiproxy/internal/capture"
+	"github.com/juju/guiproxy/internal/jsonpath"
+)
+
+func TestCalls(t *testing.T) {
+	c := qt.New(t)
+	calls := capture.Calls([]capture.Entry{
+		entry(true, "Admin.Login", 1, `{"request-id": 1, "params": {"user": "who"}}`),
+		entry(true, "Client.FullStatus", 2, `{"request-id": 2}`),
+		entry(false, "Client.FullStatus", 2, `{"request-id": 2, "error": "boom"}`),
+		entry(false, "Admin.Login", 1, `{"request-id": 1, "response": {"ok": true}}`),
+		entry(false, "", 42, `{"request-id": 42, "response": {}}`),
+		entry(true, "Pinger.Ping", 3, `{"RequestId": 3, "Params": {}}`),
+	})
+	c.Assert(calls, qt.DeepEquals, []capture.Call{{
+		Method:   "Admin.Login",
+		Params:   map[string]interface{}{"user": "who"},
+		Response: map[string]interface{}{"ok": true},
+	}, {
+		Method: "Client.FullStatus",
+		Error:  "boom",
+	}, {
+		Method: "Pinger.Ping",
+		Params: map[string]interface{}{},
+	}})
+}
+
+func TestDiff(t *testing.T) {
+	c := qt.New(t)
+	a := []capture.Call{
+		call("Admin.Login", nil, map[string]interface{}{"server-version": "2.3.1"}),
+		call("Client.FullStatus", nil, map[string]interface{}{"since": "yesterday"}),
+		call("Application.Get", map[string]interface{}{"application": "django"}, nil),
+		call("Client.FullStatus", nil, map[string]interface{}{"since": "yesterday"}),
+	}
+	b := []capture.Call{
+		call("Admin.Login", nil, map[string]interface{}{"server-version": "2.4.0", "new": []interface{}{1.0}}),
+		call("Client.FullStatus", nil, map[string]interface{}{"since": "today"}),
+		call("Client.FullStatus", nil, map[string]interface{}{"since": "today"}),
+		call("Client.FullStatus", nil, map[string]interface{}{"since": "today"}),
+	}
+	ignore, err := jsonpath.Parse("response.since")
+	c.Assert(err, qt.Equals, nil)
+	changes := capture.Diff(a, b, []jsonpath.Path{ignore})
+	c.Assert(changes, qt.DeepEquals, []capture.Change{{
+		Kind:   capture.Changed,
+		Method: "Admin.Login",
+		Differences: []string{
+			`response.new: added [1]`,
+			`response.server-version: "2.3.1" -> "2.4.0"`,
+		},
+	}, {
+		Kind:   capture.Same,
+		Method: "Client.FullStatus",
+	}, {
+		Kind:   capture.Removed,
+		Method: "Application.Get",
+	}, {
+		Kind:   capture.Same,
+		Method: "Client.FullStatus",
+	}, {
+		Kind:   capture.Added,
+		Method: "Client.FullStatus",
+	}})
+
+	// Ignored paths do not modify calls.
+	c.Assert(a[1].Response, qt.DeepEquals, map[string]interface{}{"since": "yesterday"})
+
+	var buf bytes.Buffer
+	capture.Report(&buf, changes)
+	c.Assert(buf.String(), qt.Equals, `~ Admin.Login
+    response.new: added [1]
+    response.server-version: "2.3.1" -> "2.4.0"
+  Client.FullStatus
+- Application.Get
+  Client.FullStatus
++ Client.FullStatus
+
+calls: 4 -> 4 (1 added, 1 removed, 1 changed)
+Application.Get: 1 -> 0
+Client.FullStatus: 2 -> 3
+`)
+}
+
+func TestDiffDifferences(t *testing.T) {
+	c := qt.New(t)
+	long := "a very long string value used to check that values in reported differences are truncated"
+	a := []capture.Call{call("Client.FullStatus", []interface{}{1.0, 2.0, 3.0}, map[string]interface{}{
+		"value": long,
+		"gone":  true,
+	})}
+	b := []capture.Call{call("Client.FullStatus", []interface{}{1.0, 4.0}, map[string]interface{}{
+		"value": 42.0,
+	})}
+	changes := capture.Diff(a, b, nil)
+	c.Assert(changes, qt.HasLen, 1)
+	c.Assert(changes[0].Differences, qt.DeepEquals, []string{
+		`params.1: 2 -> 4`,
+		`params.2: removed 3`,
+		`response.gone: removed true`,
+		`response.value: "a very long string value used to check that values in re... -> 42`,
+	})
+}
+
+func TestDiffAlignment(t *testing.T) {
+	c := qt.New(t)
+	r := rand.New(rand.NewSource(42))
+	methods := []string{"Client.FullStatus", "Application.Get", "Pinger.Ping"}
+	calls := func(n int) []capture.Call {
+		calls := make([]capture.Call, n)
+		for i := range calls {
+			calls[i].Method = methods[r.Intn(len(methods))]
+		}
+		return calls
+	}
+	for i := 0; i < 200; i++ {
+		a, b := calls(r.Intn(30)), calls(r.Intn(30))
+		var gotA, gotB []string
+		common := 0
+		for _, change := range capture.Diff(a, b, nil) {
+			if change.Kind != capture.Added {
+				gotA = append(gotA, change.Method)
+			}
+			if change.Kind != capture.Removed {
+				gotB = append(gotB, change.Method)
+			}
+			if change.Kind == capture.Same {
+				common++
+			}
+		}
+		// All calls are reported in order, and as many calls as possible
+		// are aligned.
+		c.Assert(gotA, qt.DeepEquals, callMethods(a))
+		c.Assert(gotB, qt.DeepEquals, callMethods(b))
+		c.Assert(common, qt.Equals, lcsLength(callMethods(a), callMethods(b)))
+	}
+}
+
+func TestDiffLargeCaptures(t *testing.T) {
+	c := qt.New(t)
+	// A quadratic table would require gigabytes of memory.
+	a := make([]capture.Call, 30000)
+	for i := range a {
+		a[i].Method = fmt.Sprintf("Facade.Method%d", i%7)
+	}
+	b := append(append([]capture.Call(nil), a[:29000]...), a[29100:]...)
+	changes := capture.Diff(a, b, nil)
+	c.Assert(changes, qt.HasLen, 30000)
+	removed := 0
+	for _, change := range changes {
+		if change.Kind == capture.Removed {
+			removed++
+		}
+	}
+	c.Assert(removed, qt.Equals, 100)
+}
+
+// callMethods returns the methods of the given calls.
+func callMethods(calls []capture.Call) []string {
+	var methods []string
+	for _, c := range calls {
+		methods = append(methods, c.Method)
+	}
+	return methods
+}
+
+// lcsLength returns the length of the longest common subsequence of the given
+// sequences.
+func lcsLength(a, b []string) int {
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				lcs[i][j] = lcs[i+1][j]
+			default:
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	return lcs[0][0]
+}
+
+// entry returns a capture entry with the given values.
+func entry(request bool, method string, id uint64, content string) capture.Entry {
+	return capture.Entry{
+		Request:   request,
+		Method:    method,
+		RequestID: id,
+		Content:   json.RawMessage(content),
+	}
+}
+
+// call returns a call with the given method, parameters and response.
+func call(method string, params, response interface{}) capture.Call {
+	return capture.Call{
+		Method:   method,
+		Params:   params,
+		Response: response,
+	}
+}
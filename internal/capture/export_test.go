@@ -0,0 +1,3 @@
+package capture
+
+var TimeNow = &timeNow
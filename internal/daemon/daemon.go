@@ -0,0 +1,324 @@
+// Package daemon manages a guiproxy server running in the background, keeping
+// track of it with a pidfile, a log file and a state file in a directory. The
+// pidfile is locked for as long as the server runs, so that a stale pidfile is
+// never mistaken for a running server, even if its process id has been reused.
+package daemon
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StateEnv holds the name of the environment variable used to provide the
+// server started in the background with the path of its state file.
+const StateEnv = "GUIPROXY_DAEMON_STATE"
+
+// pidFileEnv holds the name of the environment variable used to provide the
+// server started in the background with the descriptor of the inherited
+// pidfile.
+const pidFileEnv = "GUIPROXY_DAEMON_PIDFILE_FD"
+
+// ErrNotRunning is returned when no server is running in the background.
+var ErrNotRunning = errors.New("not running")
+
+// State holds information about a server running in the background, as
+// reported by the server itself.
+type State struct {
+	// PID holds the process id of the server.
+	PID int `json:"pid"`
+
+	// Started holds the time at which the server was started.
+	Started time.Time `json:"started"`
+
+	// URLs holds the URLs from which the GUI can be reached.
+	URLs []string `json:"urls"`
+
+	// Controller holds the address of the controller the GUI connects to.
+	Controller string `json:"controller"`
+
+	// JujuVersion optionally holds the version of the controller.
+	JujuVersion string `json:"juju-version,omitempty"`
+
+	// Environment optionally holds the name of the selected environment.
+	Environment string `json:"environment,omitempty"`
+
+	// Profile optionally holds the name of the selected profile.
+	Profile string `json:"profile,omitempty"`
+
+	// Log holds the path of the log file of the server.
+	Log string `json:"log,omitempty"`
+}
+
+// DefaultDir returns the directory in the user's state directory used to
+// store the files of the server running in the background, for instance
+// "~/.local/state/guiproxy".
+func DefaultDir() string {
+	dir := os.Getenv("XDG_STATE_HOME")
+	if dir == "" {
+		dir = filepath.Join(os.Getenv("HOME"), ".local", "state")
+	}
+	return filepath.Join(dir, "guiproxy")
+}
+
+// New returns a daemon storing its files in the given directory.
+func New(dir string) *Daemon {
+	return &Daemon{
+		Dir: dir,
+	}
+}
+
+// Daemon manages a server running in the background.
+type Daemon struct {
+	// Dir holds the directory in which the pidfile, the log file and the
+	// state file are stored.
+	Dir string
+}
+
+// PIDFile returns the path of the file holding the server process id.
+func (d *Daemon) PIDFile() string {
+	return filepath.Join(d.Dir, "guiproxy.pid")
+}
+
+// LogFile returns the path of the file the server output is written to.
+func (d *Daemon) LogFile() string {
+	return filepath.Join(d.Dir, "guiproxy.log")
+}
+
+// StateFile returns the path of the file the server writes its state to.
+func (d *Daemon) StateFile() string {
+	return filepath.Join(d.Dir, "state.json")
+}
+
+// Start runs the given server command in the background, with its output
+// appended to the log file, and waits for the server to report its state
+// using WriteState. The command is provided with the path of the state file
+// in the StateEnv environment variable, and inherits the locked pidfile as an
+// extra file, which it must keep open while running. The command must call
+// Inherit before running any subprocess. An error is returned if a server is
+// already running, or if the server exits or does not report its state
+// before the given timeout expires, in which case it is left running.
+func (d *Daemon) Start(cmd *exec.Cmd, timeout time.Duration) (*State, error) {
+	if pid, err := d.pid(); err == nil {
+		return nil, fmt.Errorf("already running with pid %d", pid)
+	}
+	if err := os.MkdirAll(d.Dir, 0700); err != nil {
+		return nil, fmt.Errorf("cannot create state directory: %s", err)
+	}
+	if err := removeFiles(d.PIDFile(), d.StateFile()); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(d.LogFile(), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open log file: %s", err)
+	}
+	defer f.Close()
+	cmd.Stdout, cmd.Stderr = f, f
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+	cmd.Env = append(cmd.Env, StateEnv+"="+d.StateFile())
+	if err := detach(cmd); err != nil {
+		return nil, err
+	}
+	pf, err := os.OpenFile(d.PIDFile(), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if os.IsExist(err) {
+		return nil, errors.New("already starting")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot create pidfile: %s", err)
+	}
+	defer pf.Close()
+	if err := lock(pf); err != nil {
+		return nil, fmt.Errorf("cannot lock pidfile: %s", err)
+	}
+	// Extra files are provided to the command starting from descriptor 3.
+	cmd.Env = append(cmd.Env, pidFileEnv+"="+strconv.Itoa(3+len(cmd.ExtraFiles)))
+	cmd.ExtraFiles = append(cmd.ExtraFiles, pf)
+	if err := cmd.Start(); err != nil {
+		removeFiles(d.PIDFile())
+		return nil, fmt.Errorf("cannot start server: %s", err)
+	}
+	pid := cmd.Process.Pid
+	if _, err := fmt.Fprintf(pf, "%d\n", pid); err != nil {
+		cmd.Process.Kill()
+		return nil, fmt.Errorf("cannot write pidfile: %s", err)
+	}
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+	deadline := time.After(timeout)
+	tick := time.NewTicker(pollInterval)
+	defer tick.Stop()
+	for {
+		select {
+		case err := <-exited:
+			removeFiles(d.PIDFile(), d.StateFile())
+			if err == nil {
+				err = errors.New("exit status 0")
+			}
+			return nil, fmt.Errorf("server exited (%s), see %s", err, d.LogFile())
+		case <-deadline:
+			return nil, fmt.Errorf("server with pid %d did not start in %s, see %s", pid, timeout, d.LogFile())
+		case <-tick.C:
+			if s, err := readState(d.StateFile()); err == nil {
+				return s, nil
+			}
+		}
+	}
+}
+
+// Inherit must be called by servers started using Start before running any
+// subprocess. It marks the inherited pidfile as close-on-exec, so that
+// subprocesses, for instance juju commands, do not keep the pidfile locked
+// after the server exits. Inherit does nothing if the server has not been
+// started in the background.
+func Inherit() error {
+	s := os.Getenv(pidFileEnv)
+	if s == "" {
+		return nil
+	}
+	fd, err := strconv.Atoi(s)
+	if err != nil {
+		return fmt.Errorf("invalid pidfile descriptor %q: %s", s, err)
+	}
+	closeOnExec(fd)
+	return nil
+}
+
+// Status returns the state of the server running in the background, or
+// ErrNotRunning if no server is running. If the server has not reported its
+// state yet, only the process id and log file are included.
+func (d *Daemon) Status() (*State, error) {
+	pid, err := d.pid()
+	if err != nil {
+		return nil, err
+	}
+	s, err := readState(d.StateFile())
+	if err != nil || s.PID != pid {
+		s = &State{
+			PID: pid,
+		}
+	}
+	s.Log = d.LogFile()
+	return s, nil
+}
+
+// Stop stops the server running in the background, waiting for it to exit
+// until the given timeout expires, and returns its process id.
+func (d *Daemon) Stop(timeout time.Duration) (int, error) {
+	pid, err := d.pid()
+	if err != nil {
+		return 0, err
+	}
+	if err := terminate(pid); err != nil {
+		return 0, fmt.Errorf("cannot stop server with pid %d: %s", pid, err)
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		running, err := d.running()
+		if err != nil {
+			return 0, err
+		}
+		if !running {
+			break
+		}
+		if time.Now().After(deadline) {
+			return 0, fmt.Errorf("server with pid %d did not exit in %s", pid, timeout)
+		}
+		time.Sleep(pollInterval)
+	}
+	return pid, removeFiles(d.PIDFile(), d.StateFile())
+}
+
+// pid returns the process id of the running server, or ErrNotRunning. Stale
+// files left by a server which is no longer running are removed.
+func (d *Daemon) pid() (int, error) {
+	running, err := d.running()
+	if err != nil {
+		return 0, err
+	}
+	if !running {
+		if err := removeFiles(d.PIDFile(), d.StateFile()); err != nil {
+			return 0, err
+		}
+		return 0, ErrNotRunning
+	}
+	data, err := ioutil.ReadFile(d.PIDFile())
+	if err != nil {
+		return 0, fmt.Errorf("cannot read pidfile: %s", err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0, fmt.Errorf("invalid pidfile %q: %s", d.PIDFile(), err)
+	}
+	return pid, nil
+}
+
+// running reports whether the pidfile exists and is locked by a server.
+func (d *Daemon) running() (bool, error) {
+	f, err := os.Open(d.PIDFile())
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("cannot open pidfile: %s", err)
+	}
+	defer f.Close()
+	ok, err := locked(f)
+	if err != nil {
+		return false, fmt.Errorf("cannot check pidfile lock: %s", err)
+	}
+	return ok, nil
+}
+
+// WriteState writes the given state to the file at the given path, so that
+// it is never observed partially written.
+func WriteState(path string, s State) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("cannot marshal state: %s", err)
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("cannot write state: %s", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("cannot write state: %s", err)
+	}
+	return nil
+}
+
+// readState reads the state from the file at the given path.
+func readState(path string) (*State, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var s State
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// removeFiles removes the files at the given paths, if they exist.
+func removeFiles(paths ...string) error {
+	for _, path := range paths {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("cannot remove file: %s", err)
+		}
+	}
+	return nil
+}
+
+// pollInterval holds the interval at which the server state is checked.
+const pollInterval = 100 * time.Millisecond
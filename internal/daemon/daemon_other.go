@@ -0,0 +1,38 @@
+//go:build !unix || solaris || aix
+
+package daemon
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+)
+
+// errUnsupported is returned when trying to manage servers in the background
+// on platforms without file locking support, like Windows.
+var errUnsupported = errors.New("running in the background is not supported on this platform")
+
+// detach is not supported on this platform.
+func detach(cmd *exec.Cmd) error {
+	return errUnsupported
+}
+
+// lock is not supported on this platform.
+func lock(f *os.File) error {
+	return errUnsupported
+}
+
+// locked always reports that files are not locked on this platform, so that
+// servers are never reported as running in the background.
+func locked(f *os.File) (bool, error) {
+	return false, nil
+}
+
+// closeOnExec does nothing on this platform, where servers are never started
+// in the background.
+func closeOnExec(fd int) {}
+
+// terminate is not supported on this platform.
+func terminate(pid int) error {
+	return errUnsupported
+}
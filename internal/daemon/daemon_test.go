@@ -0,0 +1,78 @@
+package daemon_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/juju/guiproxy/internal/daemon"
+)
+
+func TestDefaultDir(t *testing.T) {
+	c := qt.New(t)
+	defer c.Cleanup()
+	c.Setenv("HOME", "/home/who")
+	c.Setenv("XDG_STATE_HOME", "")
+	c.Assert(daemon.DefaultDir(), qt.Equals, "/home/who/.local/state/guiproxy")
+	c.Setenv("XDG_STATE_HOME", "/var/state")
+	c.Assert(daemon.DefaultDir(), qt.Equals, "/var/state/guiproxy")
+}
+
+func TestWriteState(t *testing.T) {
+	c := qt.New(t)
+	path := filepath.Join(c.Mkdir(), "state.json")
+	s := daemon.State{
+		PID:         42,
+		Started:     time.Date(2018, 1, 18, 10, 0, 0, 0, time.UTC),
+		URLs:        []string{"http://localhost:8042/gui/"},
+		Controller:  "1.2.3.4:17070",
+		JujuVersion: "2.3.1",
+		Environment: "production",
+	}
+	err := daemon.WriteState(path, s)
+	c.Assert(err, qt.Equals, nil)
+	got, err := daemon.ReadState(path)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(*got, qt.DeepEquals, s)
+}
+
+func TestNotRunning(t *testing.T) {
+	c := qt.New(t)
+	d := daemon.New(c.Mkdir())
+	s, err := d.Status()
+	c.Assert(err, qt.Equals, daemon.ErrNotRunning)
+	c.Assert(s, qt.IsNil)
+	pid, err := d.Stop(time.Second)
+	c.Assert(err, qt.Equals, daemon.ErrNotRunning)
+	c.Assert(pid, qt.Equals, 0)
+}
+
+func TestStalePIDFile(t *testing.T) {
+	c := qt.New(t)
+	d := daemon.New(c.Mkdir())
+	// The process id has been reused by a running process, the test itself,
+	// but the pidfile is not locked.
+	err := ioutil.WriteFile(d.PIDFile(), []byte(strconv.Itoa(os.Getpid())+"\n"), 0600)
+	c.Assert(err, qt.Equals, nil)
+	s, err := d.Status()
+	c.Assert(err, qt.Equals, daemon.ErrNotRunning)
+	c.Assert(s, qt.IsNil)
+	_, err = ioutil.ReadFile(d.PIDFile())
+	c.Assert(err, qt.ErrorMatches, ".*no such file or directory")
+}
+
+func TestStopStalePIDFile(t *testing.T) {
+	c := qt.New(t)
+	d := daemon.New(c.Mkdir())
+	err := ioutil.WriteFile(d.PIDFile(), []byte(strconv.Itoa(os.Getpid())+"\n"), 0600)
+	c.Assert(err, qt.Equals, nil)
+	// The unrelated process is not stopped.
+	pid, err := d.Stop(time.Second)
+	c.Assert(err, qt.Equals, daemon.ErrNotRunning)
+	c.Assert(pid, qt.Equals, 0)
+}
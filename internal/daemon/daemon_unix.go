@@ -0,0 +1,50 @@
+//go:build unix && !solaris && !aix
+
+package daemon
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// detach configures the given command so that it runs in its own session,
+// and is therefore not terminated with the terminal it was started from.
+func detach(cmd *exec.Cmd) error {
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Setsid: true,
+	}
+	return nil
+}
+
+// lock acquires an exclusive lock on the given file. The lock is shared with
+// child processes inheriting the file, and is only released once all of them
+// have closed it, for instance by exiting.
+func lock(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+}
+
+// locked reports whether an exclusive lock is held on the given file. A
+// shared lock is used for checking, so that concurrent checks do not
+// interfere with each other.
+func locked(f *os.File) (bool, error) {
+	fd := int(f.Fd())
+	err := syscall.Flock(fd, syscall.LOCK_SH|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return false, syscall.Flock(fd, syscall.LOCK_UN)
+}
+
+// closeOnExec marks the given file descriptor as close-on-exec.
+func closeOnExec(fd int) {
+	syscall.CloseOnExec(fd)
+}
+
+// terminate asks the process with the given id to exit.
+func terminate(pid int) error {
+	return syscall.Kill(pid, syscall.SIGTERM)
+}
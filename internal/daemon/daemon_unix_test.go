@@ -0,0 +1,151 @@
+//go:build unix && !solaris && !aix
+
+package daemon_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/juju/guiproxy/internal/daemon"
+)
+
+// helperEnv holds the name of the environment variable used to run the test
+// binary as a fake server.
+const helperEnv = "GUIPROXY_DAEMON_TEST_HELPER"
+
+// childEnv holds the name of the environment variable used to provide the
+// fake server with the path of the file where the process id of its
+// subprocess is written.
+const childEnv = "GUIPROXY_DAEMON_TEST_CHILD"
+
+func TestMain(m *testing.M) {
+	switch os.Getenv(helperEnv) {
+	case "":
+		os.Exit(m.Run())
+	case "serve":
+		err := daemon.WriteState(os.Getenv(daemon.StateEnv), daemon.State{
+			PID:        os.Getpid(),
+			Controller: "1.2.3.4:17070",
+		})
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		// Wait to be stopped.
+		time.Sleep(time.Hour)
+	case "spawn":
+		// Run a subprocess outliving the server.
+		if err := daemon.Inherit(); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		cmd := helperCommand("sleep")
+		if err := cmd.Start(); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		pid := strconv.Itoa(cmd.Process.Pid)
+		if err := ioutil.WriteFile(os.Getenv(childEnv), []byte(pid), 0600); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		err := daemon.WriteState(os.Getenv(daemon.StateEnv), daemon.State{
+			PID: os.Getpid(),
+		})
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		time.Sleep(time.Hour)
+	case "sleep":
+		time.Sleep(time.Hour)
+	case "fail":
+		fmt.Println("bad wolf")
+		os.Exit(2)
+	}
+}
+
+// helperCommand returns a command running the test binary in the given mode.
+func helperCommand(mode string) *exec.Cmd {
+	cmd := exec.Command(os.Args[0])
+	cmd.Env = append(os.Environ(), helperEnv+"="+mode)
+	return cmd
+}
+
+func TestStartStatusStop(t *testing.T) {
+	c := qt.New(t)
+	d := daemon.New(c.Mkdir())
+
+	// Start the server.
+	s, err := d.Start(helperCommand("serve"), 10*time.Second)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(s.Controller, qt.Equals, "1.2.3.4:17070")
+	pid := s.PID
+
+	// Only one server can be running.
+	_, err = d.Start(helperCommand("serve"), 10*time.Second)
+	c.Assert(err, qt.ErrorMatches, fmt.Sprintf("already running with pid %d", pid))
+
+	// The state of the server is reported.
+	s, err = d.Status()
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(s, qt.DeepEquals, &daemon.State{
+		PID:        pid,
+		Controller: "1.2.3.4:17070",
+		Log:        d.LogFile(),
+	})
+
+	// Stop the server.
+	stopped, err := d.Stop(10 * time.Second)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(stopped, qt.Equals, pid)
+	_, err = d.Status()
+	c.Assert(err, qt.Equals, daemon.ErrNotRunning)
+}
+
+func TestStopWithSubprocess(t *testing.T) {
+	c := qt.New(t)
+	dir := c.Mkdir()
+	d := daemon.New(dir)
+	childFile := filepath.Join(dir, "child")
+	cmd := helperCommand("spawn")
+	cmd.Env = append(cmd.Env, childEnv+"="+childFile)
+	s, err := d.Start(cmd, 10*time.Second)
+	c.Assert(err, qt.Equals, nil)
+	data, err := ioutil.ReadFile(childFile)
+	c.Assert(err, qt.Equals, nil)
+	child, err := strconv.Atoi(string(data))
+	c.Assert(err, qt.Equals, nil)
+	defer syscall.Kill(child, syscall.SIGKILL)
+
+	// The subprocess does not keep the pidfile locked.
+	stopped, err := d.Stop(10 * time.Second)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(stopped, qt.Equals, s.PID)
+	_, err = d.Status()
+	c.Assert(err, qt.Equals, daemon.ErrNotRunning)
+	c.Assert(syscall.Kill(child, 0), qt.Equals, nil)
+}
+
+func TestStartExited(t *testing.T) {
+	c := qt.New(t)
+	d := daemon.New(c.Mkdir())
+	s, err := d.Start(helperCommand("fail"), 10*time.Second)
+	c.Assert(err, qt.ErrorMatches, `server exited \(exit status 2\), see .*guiproxy.log`)
+	c.Assert(s, qt.IsNil)
+	// The server output is logged.
+	data, err := ioutil.ReadFile(d.LogFile())
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(string(data), qt.Equals, "bad wolf\n")
+	_, err = d.Status()
+	c.Assert(err, qt.Equals, daemon.ErrNotRunning)
+}
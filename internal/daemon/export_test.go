@@ -0,0 +1,3 @@
+package daemon
+
+var ReadState = readState
@@ -23,6 +23,8 @@ func New(ctx Context, overrides map[string]interface{}) string {
 		"apiAddress":               ctx.Address,
 		"controllerSocketTemplate": ctx.ControllerTemplate,
 		"socketTemplate":           ctx.ModelTemplate,
+		"logSocketTemplate":        ctx.LogTemplate,
+		"commandsSocketTemplate":   ctx.CommandsTemplate,
 		baseURLKey:                 defaultBaseURL,
 		"jujuEnvUUID":              "",
 		"gisf":                     false,
@@ -63,6 +65,12 @@ type Context struct {
 
 	// ModelTemplate holds the model WebSocket template.
 	ModelTemplate string
+
+	// LogTemplate holds the model debug-log WebSocket template.
+	LogTemplate string
+
+	// CommandsTemplate holds the model CLI commands WebSocket template.
+	CommandsTemplate string
 }
 
 // Overrides generates and returns overrides from the given GUI environment
@@ -24,6 +24,8 @@ var newTests = []struct {
 		JujuVersion:        "42.47.0",
 		ControllerTemplate: "wss://$server:$port/api",
 		ModelTemplate:      "wss://$server:$port/model/$uuid/api",
+		LogTemplate:        "wss://$server:$port/model/$uuid/log",
+		CommandsTemplate:   "wss://$server:$port/model/$uuid/commands",
 	},
 	expectedFragments: []string{
 		`"apiAddress": "1.2.3.4"`,
@@ -31,6 +33,8 @@ var newTests = []struct {
 		`"jujuEnvUUID": ""`,
 		`"controllerSocketTemplate": "wss://$server:$port/api"`,
 		`"socketTemplate": "wss://$server:$port/model/$uuid/api"`,
+		`"logSocketTemplate": "wss://$server:$port/model/$uuid/log"`,
+		`"commandsSocketTemplate": "wss://$server:$port/model/$uuid/commands"`,
 		fmt.Sprintf(`"baseUrl": "%s"`, guiconfig.DefaultBaseURL),
 		`"gisf": false`,
 		`"socket_protocol": "ws"`,
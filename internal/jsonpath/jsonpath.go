@@ -0,0 +1,222 @@
+// Package jsonpath implements simple paths used to select values in decoded
+// JSON documents.
+package jsonpath
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Parse parses the given dot separated path, for instance
+// "params.credentials.*.password". The "*" wildcard matches any object key
+// or array index. Object keys are matched case insensitively, so that both
+// Juju 2 and Juju 1 message formats can be selected with the same path.
+func Parse(s string) (Path, error) {
+	if s == "" {
+		return nil, fmt.Errorf("empty path")
+	}
+	p := Path(strings.Split(s, "."))
+	for _, part := range p {
+		if part == "" {
+			return nil, fmt.Errorf("invalid path %q: empty path element", s)
+		}
+	}
+	return p, nil
+}
+
+// Path holds a parsed JSON path.
+type Path []string
+
+// String implements fmt.Stringer by returning the dot separated path.
+func (p Path) String() string {
+	return strings.Join(p, ".")
+}
+
+// Get returns all the values matching the path in the given decoded JSON
+// document.
+func (p Path) Get(doc interface{}) []interface{} {
+	var values []interface{}
+	p.walk(doc, func(parent interface{}, key string, index int) {
+		switch v := parent.(type) {
+		case map[string]interface{}:
+			values = append(values, v[key])
+		case []interface{}:
+			values = append(values, v[index])
+		}
+	})
+	return values
+}
+
+// Replace replaces all values matching the path in the given decoded JSON
+// document with the result of calling f with the old value. It reports
+// whether any value has been replaced.
+func (p Path) Replace(doc interface{}, f func(old interface{}) interface{}) bool {
+	return p.walk(doc, func(parent interface{}, key string, index int) {
+		switch v := parent.(type) {
+		case map[string]interface{}:
+			v[key] = f(v[key])
+		case []interface{}:
+			v[index] = f(v[index])
+		}
+	})
+}
+
+// Delete removes all object keys matching the path in the given decoded JSON
+// document. Array elements are replaced with nil values. It reports whether
+// any value has been removed.
+func (p Path) Delete(doc interface{}) bool {
+	return p.walk(doc, func(parent interface{}, key string, index int) {
+		switch v := parent.(type) {
+		case map[string]interface{}:
+			delete(v, key)
+		case []interface{}:
+			v[index] = nil
+		}
+	})
+}
+
+// Set sets the given value at the path in the given decoded JSON document,
+// creating missing intermediate objects. Wildcards only match existing keys
+// or indexes. It reports whether any value has been set.
+func (p Path) Set(doc interface{}, value interface{}) bool {
+	if len(p) == 0 {
+		return false
+	}
+	parents := []interface{}{doc}
+	for _, part := range p[:len(p)-1] {
+		var next []interface{}
+		for _, parent := range parents {
+			if m, ok := parent.(map[string]interface{}); ok && part != "*" && lookup(m, part) == "" {
+				child := make(map[string]interface{})
+				m[part] = child
+				next = append(next, child)
+				continue
+			}
+			next = append(next, children(parent, part)...)
+		}
+		parents = next
+	}
+	last, set := p[len(p)-1], false
+	for _, parent := range parents {
+		switch v := parent.(type) {
+		case map[string]interface{}:
+			if last == "*" {
+				for k := range v {
+					v[k] = value
+				}
+				set = set || len(v) != 0
+				continue
+			}
+			if k := lookup(v, last); k != "" {
+				v[k] = value
+			} else {
+				v[last] = value
+			}
+			set = true
+		case []interface{}:
+			for _, i := range indexes(v, last) {
+				v[i] = value
+				set = true
+			}
+		}
+	}
+	return set
+}
+
+// walk calls f for all the values matching the path, passing their parent
+// container and the key or index used to access them. It reports whether f
+// has been called at least once.
+func (p Path) walk(doc interface{}, f func(parent interface{}, key string, index int)) bool {
+	if len(p) == 0 {
+		return false
+	}
+	parents := []interface{}{doc}
+	for _, part := range p[:len(p)-1] {
+		var next []interface{}
+		for _, parent := range parents {
+			next = append(next, children(parent, part)...)
+		}
+		parents = next
+	}
+	last, found := p[len(p)-1], false
+	for _, parent := range parents {
+		switch v := parent.(type) {
+		case map[string]interface{}:
+			for _, k := range keys(v, last) {
+				f(v, k, 0)
+				found = true
+			}
+		case []interface{}:
+			for _, i := range indexes(v, last) {
+				f(v, "", i)
+				found = true
+			}
+		}
+	}
+	return found
+}
+
+// children returns the values in the given container matching the given path
+// element.
+func children(parent interface{}, part string) []interface{} {
+	var values []interface{}
+	switch v := parent.(type) {
+	case map[string]interface{}:
+		for _, k := range keys(v, part) {
+			values = append(values, v[k])
+		}
+	case []interface{}:
+		for _, i := range indexes(v, part) {
+			values = append(values, v[i])
+		}
+	}
+	return values
+}
+
+// keys returns the keys in the given object matching the given path element.
+func keys(m map[string]interface{}, part string) []string {
+	if part != "*" {
+		if k := lookup(m, part); k != "" {
+			return []string{k}
+		}
+		return nil
+	}
+	ks := make([]string, 0, len(m))
+	for k := range m {
+		ks = append(ks, k)
+	}
+	return ks
+}
+
+// lookup returns the key in the given object matching the given name case
+// insensitively, or an empty string if the key is not found. Exact matches
+// are preferred.
+func lookup(m map[string]interface{}, name string) string {
+	if _, ok := m[name]; ok {
+		return name
+	}
+	for k := range m {
+		if strings.EqualFold(k, name) {
+			return k
+		}
+	}
+	return ""
+}
+
+// indexes returns the indexes in the given array matching the given path
+// element.
+func indexes(a []interface{}, part string) []int {
+	if part != "*" {
+		i, err := strconv.Atoi(part)
+		if err != nil || i < 0 || i >= len(a) {
+			return nil
+		}
+		return []int{i}
+	}
+	is := make([]int, len(a))
+	for i := range a {
+		is[i] = i
+	}
+	return is
+}
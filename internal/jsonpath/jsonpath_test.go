@@ -0,0 +1,155 @@
+package jsonpath_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/juju/guiproxy/internal/jsonpath"
+)
+
+func TestParse(t *testing.T) {
+	c := qt.New(t)
+	p, err := jsonpath.Parse("params.changes.*.password")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(p, qt.DeepEquals, jsonpath.Path{"params", "changes", "*", "password"})
+	c.Assert(p.String(), qt.Equals, "params.changes.*.password")
+
+	_, err = jsonpath.Parse("")
+	c.Assert(err, qt.ErrorMatches, "empty path")
+	_, err = jsonpath.Parse("params..password")
+	c.Assert(err, qt.ErrorMatches, `invalid path "params..password": empty path element`)
+}
+
+const doc = `{
+	"params": {
+		"changes": [
+			{"user": "who", "password": "secret1"},
+			{"user": "dalek", "Password": "secret2"}
+		],
+		"tags": ["a", "b"]
+	}
+}`
+
+var getTests = []struct {
+	path     string
+	expected []interface{}
+}{{
+	path:     "params.changes.*.password",
+	expected: []interface{}{"secret1", "secret2"},
+}, {
+	path:     "params.changes.1.user",
+	expected: []interface{}{"dalek"},
+}, {
+	path:     "PARAMS.tags.0",
+	expected: []interface{}{"a"},
+}, {
+	path: "params.tags.42",
+}, {
+	path: "params.tags.bad-index",
+}, {
+	path: "params.no-such.key",
+}, {
+	path: "params.tags.0.nested",
+}}
+
+func TestGet(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range getTests {
+		c.Run(test.path, func(c *qt.C) {
+			p, err := jsonpath.Parse(test.path)
+			c.Assert(err, qt.Equals, nil)
+			c.Assert(p.Get(decode(c, doc)), qt.DeepEquals, test.expected)
+		})
+	}
+}
+
+func TestReplace(t *testing.T) {
+	c := qt.New(t)
+	v := decode(c, doc)
+	p, err := jsonpath.Parse("params.changes.*.password")
+	c.Assert(err, qt.Equals, nil)
+	ok := p.Replace(v, func(old interface{}) interface{} {
+		return "[" + old.(string) + "]"
+	})
+	c.Assert(ok, qt.Equals, true)
+	c.Assert(encode(c, v), qt.Equals, `{"params":{"changes":[{"password":"[secret1]","user":"who"},{"Password":"[secret2]","user":"dalek"}],"tags":["a","b"]}}`)
+
+	p, err = jsonpath.Parse("params.no-such")
+	c.Assert(err, qt.Equals, nil)
+	ok = p.Replace(v, func(old interface{}) interface{} {
+		return "bad wolf"
+	})
+	c.Assert(ok, qt.Equals, false)
+}
+
+func TestDelete(t *testing.T) {
+	c := qt.New(t)
+	v := decode(c, doc)
+	p, err := jsonpath.Parse("params.changes.*.user")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(p.Delete(v), qt.Equals, true)
+	p, err = jsonpath.Parse("params.tags.1")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(p.Delete(v), qt.Equals, true)
+	c.Assert(encode(c, v), qt.Equals, `{"params":{"changes":[{"password":"secret1"},{"Password":"secret2"}],"tags":["a",null]}}`)
+	c.Assert(p.Delete("not a container"), qt.Equals, false)
+}
+
+var setTests = []struct {
+	path        string
+	value       interface{}
+	expectedSet bool
+	expected    string
+}{{
+	path:        "params.changes.*.user",
+	value:       "exterminate",
+	expectedSet: true,
+	expected:    `{"params":{"changes":[{"password":"secret1","user":"exterminate"},{"Password":"secret2","user":"exterminate"}],"tags":["a","b"]}}`,
+}, {
+	path:        "params.new.nested.key",
+	value:       42,
+	expectedSet: true,
+	expected:    `{"params":{"changes":[{"password":"secret1","user":"who"},{"Password":"secret2","user":"dalek"}],"new":{"nested":{"key":42}},"tags":["a","b"]}}`,
+}, {
+	path:        "params.tags.*",
+	value:       true,
+	expectedSet: true,
+	expected:    `{"params":{"changes":[{"password":"secret1","user":"who"},{"Password":"secret2","user":"dalek"}],"tags":[true,true]}}`,
+}, {
+	path:        "params.changes.1.password",
+	value:       nil,
+	expectedSet: true,
+	expected:    `{"params":{"changes":[{"password":"secret1","user":"who"},{"Password":null,"user":"dalek"}],"tags":["a","b"]}}`,
+}, {
+	path:     "params.tags.42",
+	value:    "bad wolf",
+	expected: `{"params":{"changes":[{"password":"secret1","user":"who"},{"Password":"secret2","user":"dalek"}],"tags":["a","b"]}}`,
+}}
+
+func TestSet(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range setTests {
+		c.Run(test.path, func(c *qt.C) {
+			v := decode(c, doc)
+			p, err := jsonpath.Parse(test.path)
+			c.Assert(err, qt.Equals, nil)
+			c.Assert(p.Set(v, test.value), qt.Equals, test.expectedSet)
+			c.Assert(encode(c, v), qt.Equals, test.expected)
+		})
+	}
+}
+
+func decode(c *qt.C, s string) interface{} {
+	var v interface{}
+	err := json.Unmarshal([]byte(s), &v)
+	c.Assert(err, qt.Equals, nil)
+	return v
+}
+
+func encode(c *qt.C, v interface{}) string {
+	b, err := json.Marshal(v)
+	c.Assert(err, qt.Equals, nil)
+	return string(b)
+}
@@ -0,0 +1,111 @@
+package juju
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// CookieJarPath returns the path to the cookie jar used by the Juju CLI for
+// the controller with the given name.
+func CookieJarPath(controllerName string) string {
+	dir := os.Getenv("JUJU_DATA")
+	if dir == "" {
+		dir = filepath.Join(os.Getenv("HOME"), ".local", "share", "juju")
+	}
+	return filepath.Join(dir, "cookies", controllerName+".json")
+}
+
+// ReadCookieJar reads the Juju cookie jar at the given path. Juju stores
+// cookies, including the macaroons discharged when logging in, as a JSON list
+// of cookie entries.
+func ReadCookieJar(path string) (*CookieJar, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read cookie jar: %s", err)
+	}
+	var cookies []cookie
+	if err := json.Unmarshal(b, &cookies); err != nil {
+		return nil, fmt.Errorf("cannot decode cookie jar %q: %s", path, err)
+	}
+	return &CookieJar{
+		cookies: cookies,
+	}, nil
+}
+
+// CookieJar holds the cookies stored by the Juju CLI.
+type CookieJar struct {
+	cookies []cookie
+}
+
+// cookie holds a cookie entry in a Juju cookie jar.
+type cookie struct {
+	Name     string
+	Value    string
+	Domain   string
+	Path     string
+	HostOnly bool
+	Expires  time.Time
+}
+
+// macaroonCookiePrefix holds the prefix of cookies storing macaroons.
+const macaroonCookiePrefix = "macaroon-"
+
+// Macaroons returns the unexpired macaroon slices stored in the jar for the
+// given host. Each slice is returned as a decoded JSON list of macaroons,
+// suitable to be included in Juju login requests.
+func (j *CookieJar) Macaroons(host string) ([]interface{}, error) {
+	host = strings.ToLower(host)
+	var ms []interface{}
+	for _, c := range j.cookies {
+		if !strings.HasPrefix(c.Name, macaroonCookiePrefix) || !c.matchHost(host) {
+			continue
+		}
+		if !c.Expires.IsZero() && c.Expires.Before(timeNow()) {
+			continue
+		}
+		m, err := decodeMacaroons(c.Value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cookie %q: %s", c.Name, err)
+		}
+		ms = append(ms, m)
+	}
+	return ms, nil
+}
+
+// matchHost reports whether the cookie must be sent to the given host.
+func (c cookie) matchHost(host string) bool {
+	domain := strings.ToLower(strings.TrimPrefix(c.Domain, "."))
+	if host == domain {
+		return true
+	}
+	return !c.HostOnly && strings.HasSuffix(host, "."+domain)
+}
+
+// decodeMacaroons decodes the given cookie value, holding a base64 encoded
+// JSON list of macaroons.
+func decodeMacaroons(value string) (interface{}, error) {
+	var b []byte
+	var err error
+	for _, enc := range []*base64.Encoding{base64.StdEncoding, base64.URLEncoding, base64.RawStdEncoding, base64.RawURLEncoding} {
+		if b, err = enc.DecodeString(value); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode base64 value: %s", err)
+	}
+	var m []interface{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("cannot decode macaroons: %s", err)
+	}
+	return m, nil
+}
+
+// timeNow is defined as a variable for testing purposes.
+var timeNow = time.Now
@@ -0,0 +1,118 @@
+package juju_test
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/juju/guiproxy/internal/juju"
+)
+
+func TestCookieJarPath(t *testing.T) {
+	c := qt.New(t)
+	defer c.Cleanup()
+	c.Setenv("JUJU_DATA", "")
+	c.Setenv("HOME", "/home/who")
+	c.Assert(juju.CookieJarPath("jaas"), qt.Equals, "/home/who/.local/share/juju/cookies/jaas.json")
+	c.Setenv("JUJU_DATA", "/tardis/juju")
+	c.Assert(juju.CookieJarPath("jaas"), qt.Equals, "/tardis/juju/cookies/jaas.json")
+}
+
+func TestCookieJarMacaroons(t *testing.T) {
+	c := qt.New(t)
+	defer c.Cleanup()
+	c.Patch(juju.TimeNow, func() time.Time {
+		return time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	})
+	m1 := base64.StdEncoding.EncodeToString([]byte(`[{"c": "m1"}, {"c": "d1"}]`))
+	m2 := base64.RawURLEncoding.EncodeToString([]byte(`[{"c": "m2"}]`))
+	m3 := base64.StdEncoding.EncodeToString([]byte(`[{"c": "m3"}]`))
+	path := writeCookieJar(c, `[{
+		"Name": "macaroon-1",
+		"Value": "`+m1+`",
+		"Domain": "api.jujucharms.com",
+		"Path": "/",
+		"HostOnly": true,
+		"Expires": "2018-02-01T00:00:00Z"
+	}, {
+		"Name": "macaroon-2",
+		"Value": "`+m2+`",
+		"Domain": "jujucharms.com",
+		"Path": "/",
+		"HostOnly": false,
+		"Expires": "2018-02-01T00:00:00Z"
+	}, {
+		"Name": "macaroon-expired",
+		"Value": "`+m3+`",
+		"Domain": "api.jujucharms.com",
+		"Path": "/",
+		"HostOnly": true,
+		"Expires": "2017-12-01T00:00:00Z"
+	}, {
+		"Name": "other",
+		"Value": "value",
+		"Domain": "api.jujucharms.com",
+		"Path": "/",
+		"HostOnly": true
+	}]`)
+	jar, err := juju.ReadCookieJar(path)
+	c.Assert(err, qt.Equals, nil)
+
+	// Macaroons for the exact host and its parent domains are returned.
+	ms, err := jar.Macaroons("API.jujucharms.com")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(ms, qt.DeepEquals, []interface{}{
+		[]interface{}{
+			map[string]interface{}{"c": "m1"},
+			map[string]interface{}{"c": "d1"},
+		},
+		[]interface{}{
+			map[string]interface{}{"c": "m2"},
+		},
+	})
+
+	// Host only cookies are not returned for sub-domains.
+	ms, err = jar.Macaroons("jimm.jujucharms.com")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(ms, qt.DeepEquals, []interface{}{
+		[]interface{}{
+			map[string]interface{}{"c": "m2"},
+		},
+	})
+
+	// No macaroons are returned for other hosts.
+	ms, err = jar.Macaroons("1.2.3.4")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(ms, qt.HasLen, 0)
+}
+
+func TestCookieJarErrors(t *testing.T) {
+	c := qt.New(t)
+	defer c.Cleanup()
+	jar, err := juju.ReadCookieJar(filepath.Join(c.Mkdir(), "no-such-file.json"))
+	c.Assert(err, qt.ErrorMatches, "cannot read cookie jar: .*")
+	c.Assert(jar, qt.IsNil)
+
+	jar, err = juju.ReadCookieJar(writeCookieJar(c, "bad wolf"))
+	c.Assert(err, qt.ErrorMatches, `cannot decode cookie jar ".*": .*`)
+	c.Assert(jar, qt.IsNil)
+
+	jar, err = juju.ReadCookieJar(writeCookieJar(c, `[{"Name": "macaroon-1", "Value": "!!!", "Domain": "1.2.3.4"}]`))
+	c.Assert(err, qt.Equals, nil)
+	ms, err := jar.Macaroons("1.2.3.4")
+	c.Assert(err, qt.ErrorMatches, `invalid cookie "macaroon-1": cannot decode base64 value: .*`)
+	c.Assert(ms, qt.IsNil)
+}
+
+// writeCookieJar writes a cookie jar with the given content to a temporary
+// directory, and returns its path.
+func writeCookieJar(c *qt.C, content string) string {
+	path := filepath.Join(c.Mkdir(), "cookies.json")
+	err := ioutil.WriteFile(path, []byte(content), 0600)
+	c.Assert(err, qt.Equals, nil)
+	return path
+}
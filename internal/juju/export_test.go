@@ -1,3 +1,5 @@
 package juju
 
 var ExecCommand = &execCommand
+
+var TimeNow = &timeNow
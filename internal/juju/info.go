@@ -2,6 +2,7 @@ package juju
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
 	"os/exec"
@@ -9,39 +10,113 @@ import (
 	"time"
 )
 
-// Info returns the controller address to be used for the proxy. If the given
-// controllerAddr is empty, then the current controller address is returned.
-// Otherwise the given controllerAddr is validated to be properly listening.
-func Info(controllerAddr string) (string, error) {
+// Controller holds information about the Juju controller used by the proxy.
+type Controller struct {
+	// Name holds the name of the controller, or an empty string if the name
+	// is not known.
+	Name string
+
+	// Addr holds the controller address.
+	Addr string
+
+	// Endpoints holds all the known API addresses of the controller,
+	// including Addr.
+	Endpoints []string
+
+	// Version holds the Juju version of the controller agents, or an empty
+	// string if the version is not known.
+	Version string
+}
+
+// Legacy reports whether the controller is a Juju 1 environment.
+func (c *Controller) Legacy() bool {
+	return strings.HasPrefix(c.Version, "1.")
+}
+
+// Info returns information about the controller to be used for the proxy. If
+// the given controllerAddr is empty, then the address and version of the
+// current controller (or Juju 1 environment) are returned. Otherwise the given
+// controllerAddr is validated to be properly listening, and the version is
+// left empty.
+func Info(controllerAddr string) (*Controller, error) {
 	if controllerAddr != "" {
 		controllerAddr, err := chooseAddress([]string{controllerAddr})
 		if err != nil {
-			return "", fmt.Errorf("cannot connect to the Juju controller: %s", err)
+			return nil, fmt.Errorf("cannot connect to the Juju controller: %s", err)
 		}
-		return controllerAddr, nil
+		return &Controller{
+			Addr:      controllerAddr,
+			Endpoints: []string{controllerAddr},
+		}, nil
 	}
 
 	// Retrieve Juju info from the CLI.
 	out, err := execCommand("juju", "show-controller", "--format", "json")
 	if err != nil {
-		return "", fmt.Errorf("cannot retrieve controller info: %s", err)
+		// The show-controller command is not available in Juju 1.
+		if ctl, legacyErr := legacyInfo(); legacyErr == nil {
+			return ctl, nil
+		}
+		return nil, fmt.Errorf("cannot retrieve controller info: %s", err)
 	}
 	var infos map[string]*controllerInfo
 	err = json.Unmarshal(out, &infos)
 	if err != nil || len(infos) != 1 {
-		return "", fmt.Errorf("invalid controller info returned by juju: %q", out)
+		return nil, fmt.Errorf("invalid controller info returned by juju: %q", out)
 	}
-	info := flattenInfo(infos)
+	name, info := flattenInfo(infos)
 
 	// Retrieve the controller address.
 	if info.Details == nil || len(info.Details.Addrs) == 0 {
-		return "", fmt.Errorf("no addresses found in controller info: %q", out)
+		return nil, fmt.Errorf("no addresses found in controller info: %q", out)
 	}
 	controllerAddr, err = chooseAddress(info.Details.Addrs)
 	if err != nil {
-		return "", fmt.Errorf("cannot connect to the Juju controller: %s", err)
+		return nil, fmt.Errorf("cannot connect to the Juju controller: %s", err)
+	}
+	return &Controller{
+		Name:      name,
+		Addr:      controllerAddr,
+		Endpoints: info.Details.Addrs,
+		Version:   info.Details.AgentVersion,
+	}, nil
+}
+
+// legacyInfo returns information about the current Juju 1 environment.
+func legacyInfo() (*Controller, error) {
+	out, err := execCommand("juju", "version")
+	if err != nil {
+		return nil, fmt.Errorf("cannot retrieve Juju version: %s", err)
+	}
+	ctl := &Controller{
+		Version: binaryVersion(string(out)),
+	}
+	if !ctl.Legacy() {
+		return nil, fmt.Errorf("not a Juju 1 client: %q", out)
+	}
+	out, err = execCommand("juju", "api-endpoints", "--format", "json")
+	if err != nil {
+		return nil, fmt.Errorf("cannot retrieve environment endpoints: %s", err)
+	}
+	var addrs []string
+	if err := json.Unmarshal(out, &addrs); err != nil || len(addrs) == 0 {
+		return nil, fmt.Errorf("invalid environment endpoints returned by juju: %q", out)
 	}
-	return controllerAddr, nil
+	ctl.Endpoints = addrs
+	if ctl.Addr, err = chooseAddress(addrs); err != nil {
+		return nil, fmt.Errorf("cannot connect to the Juju environment: %s", err)
+	}
+	return ctl, nil
+}
+
+// binaryVersion returns the version number included in the given Juju binary
+// version, for instance "1.25.7" from "1.25.7-xenial-amd64".
+func binaryVersion(v string) string {
+	parts := strings.Split(strings.TrimSpace(v), "-")
+	if len(parts) >= 3 {
+		parts = parts[:len(parts)-2]
+	}
+	return strings.Join(parts, "-")
 }
 
 // execCommand is defined as a variable for testing purposes.
@@ -52,15 +127,16 @@ var execCommand = func(name string, args ...string) ([]byte, error) {
 // controllerInfo is used to unmarshal the output of "juju show-controller".
 type controllerInfo struct {
 	Details *struct {
-		Addrs []string `json:"api-endpoints"`
+		Addrs        []string `json:"api-endpoints"`
+		AgentVersion string   `json:"agent-version"`
 	} `json:"details"`
 }
 
-// flattenInfo flattens the given controller info. The given map is assumed to
-// include at least one entry.
-func flattenInfo(infos map[string]*controllerInfo) *controllerInfo {
-	for _, info := range infos {
-		return info
+// flattenInfo flattens the given controller info, returning the controller
+// name and info. The given map is assumed to include at least one entry.
+func flattenInfo(infos map[string]*controllerInfo) (string, *controllerInfo) {
+	for name, info := range infos {
+		return name, info
 	}
 	panic("unreachable")
 }
@@ -93,9 +169,8 @@ func chooseAddress(addrs []string) (string, error) {
 		case err := <-errCh:
 			errs = append(errs, err.Error())
 			if len(errs) == numAddrs {
-				return "", fmt.Errorf(strings.Join(errs, "; "))
+				return "", errors.New(strings.Join(errs, "; "))
 			}
 		}
 	}
-	panic("unreachable")
 }
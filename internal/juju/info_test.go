@@ -5,6 +5,7 @@ import (
 	"errors"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	qt "github.com/frankban/quicktest"
@@ -22,12 +23,13 @@ func TestInfo(t *testing.T) {
 
 	// Define the tests.
 	tests := []struct {
-		about                  string
-		commandOut             string
-		commandErr             error
-		controllerAddr         string
-		expectedControllerAddr string
-		expectedError          string
+		about              string
+		commandOut         string
+		commandErr         error
+		legacyOut          map[string]string
+		controllerAddr     string
+		expectedController *juju.Controller
+		expectedError      string
 	}{{
 		about:         "command error",
 		commandErr:    errors.New("bad wolf"),
@@ -49,39 +51,84 @@ func TestInfo(t *testing.T) {
 		commandOut:    makeControllerInfo([]string{":::"}),
 		expectedError: "cannot connect to the Juju controller: dial tcp: .*",
 	}, {
-		about:                  "success from juju",
-		commandOut:             makeControllerInfo([]string{serverURL.Host}),
-		expectedControllerAddr: serverURL.Host,
+		about:      "success from juju",
+		commandOut: makeControllerInfo([]string{serverURL.Host}),
+		expectedController: &juju.Controller{
+			Name:      "controller-name",
+			Addr:      serverURL.Host,
+			Endpoints: []string{serverURL.Host},
+			Version:   "2.3.1",
+		},
+	}, {
+		about:      "success from juju: multiple addresses",
+		commandOut: makeControllerInfo([]string{"::::", serverURL.Host, ":::"}),
+		expectedController: &juju.Controller{
+			Name:      "controller-name",
+			Addr:      serverURL.Host,
+			Endpoints: []string{"::::", serverURL.Host, ":::"},
+			Version:   "2.3.1",
+		},
+	}, {
+		about:      "success from juju: multiple valid addresses",
+		commandOut: makeControllerInfo([]string{serverURL.Host, serverURL.Host, serverURL.Host}),
+		expectedController: &juju.Controller{
+			Name:      "controller-name",
+			Addr:      serverURL.Host,
+			Endpoints: []string{serverURL.Host, serverURL.Host, serverURL.Host},
+			Version:   "2.3.1",
+		},
 	}, {
-		about:                  "success from juju: multiple addresses",
-		commandOut:             makeControllerInfo([]string{"::::", serverURL.Host, ":::"}),
-		expectedControllerAddr: serverURL.Host,
+		about:      "success from juju 1",
+		commandErr: errors.New("unrecognized command"),
+		legacyOut: map[string]string{
+			"version":                     "1.25.7-xenial-amd64\n",
+			"api-endpoints --format json": `["` + serverURL.Host + `"]`,
+		},
+		expectedController: &juju.Controller{
+			Addr:      serverURL.Host,
+			Endpoints: []string{serverURL.Host},
+			Version:   "1.25.7",
+		},
 	}, {
-		about:                  "success from juju: multiple valid addresses",
-		commandOut:             makeControllerInfo([]string{serverURL.Host, serverURL.Host, serverURL.Host}),
-		expectedControllerAddr: serverURL.Host,
+		about:      "juju 1: invalid endpoints",
+		commandErr: errors.New("unrecognized command"),
+		legacyOut: map[string]string{
+			"version":                     "1.25.7-xenial-amd64\n",
+			"api-endpoints --format json": "invalid",
+		},
+		expectedError: "cannot retrieve controller info: unrecognized command",
+	}, {
+		about:      "not juju 1",
+		commandErr: errors.New("bad wolf"),
+		legacyOut: map[string]string{
+			"version": "2.0-beta1-xenial-amd64\n",
+		},
+		expectedError: "cannot retrieve controller info: bad wolf",
 	}, {
 		about:          "invalid address from input",
 		controllerAddr: ":::",
 		expectedError:  "cannot connect to the Juju controller: dial tcp: .*",
 	}, {
-		about:                  "success from input",
-		controllerAddr:         serverURL.Host,
-		expectedControllerAddr: serverURL.Host,
+		about:          "success from input",
+		controllerAddr: serverURL.Host,
+		expectedController: &juju.Controller{
+			Addr:      serverURL.Host,
+			Endpoints: []string{serverURL.Host},
+		},
 	}}
 
 	// Run the tests.
 	for _, test := range tests {
 		c.Run(test.about, func(c *qt.C) {
-			patchCommand(c, []byte(test.commandOut), test.commandErr)
-			controllerAddr, err := juju.Info(test.controllerAddr)
+			patchCommand(c, []byte(test.commandOut), test.commandErr, test.legacyOut)
+			ctl, err := juju.Info(test.controllerAddr)
 			if test.expectedError != "" {
 				c.Assert(err, qt.ErrorMatches, test.expectedError)
-				c.Assert(controllerAddr, qt.Equals, "")
+				c.Assert(ctl, qt.IsNil)
 				return
 			}
 			c.Assert(err, qt.Equals, nil)
-			c.Assert(controllerAddr, qt.Equals, test.expectedControllerAddr)
+			c.Assert(ctl, qt.DeepEquals, test.expectedController)
 		})
 	}
 
@@ -96,12 +143,20 @@ func newJujuServer() http.Handler {
 }
 
 // patchCommand patches the juju.ExecCommand variable so that it is possible
-// to simulate different output and error scenarios.
-func patchCommand(c *qt.C, out []byte, err error) {
+// to simulate different output and error scenarios. The given out and err
+// are returned by "juju show-controller", while legacyOut maps the arguments
+// of Juju 1 commands to their output.
+func patchCommand(c *qt.C, out []byte, err error, legacyOut map[string]string) {
 	c.Patch(juju.ExecCommand, func(name string, args ...string) ([]byte, error) {
 		c.Assert(name, qt.Equals, "juju")
-		c.Assert(args, qt.DeepEquals, []string{"show-controller", "--format", "json"})
-		return out, err
+		cmd := strings.Join(args, " ")
+		if cmd == "show-controller --format json" {
+			return out, err
+		}
+		if legacyOut, ok := legacyOut[cmd]; ok {
+			return []byte(legacyOut), nil
+		}
+		return nil, errors.New("unexpected command: " + cmd)
 	})
 }
 
@@ -115,6 +170,7 @@ func makeControllerInfo(addrs []string) string {
 		"controller-name": map[string]interface{}{
 			"details": map[string]interface{}{
 				"api-endpoints": addrs,
+				"agent-version": "2.3.1",
 			},
 		},
 	}
@@ -124,3 +180,10 @@ func makeControllerInfo(addrs []string) string {
 	}
 	return string(b)
 }
+
+func TestControllerLegacy(t *testing.T) {
+	c := qt.New(t)
+	c.Assert((&juju.Controller{Version: "1.25.7"}).Legacy(), qt.Equals, true)
+	c.Assert((&juju.Controller{Version: "2.3.1"}).Legacy(), qt.Equals, false)
+	c.Assert((&juju.Controller{}).Legacy(), qt.Equals, false)
+}
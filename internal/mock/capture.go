@@ -0,0 +1,85 @@
+package mock
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/juju/guiproxy/internal/capture"
+)
+
+// CaptureScript returns a script replaying the given recorded calls. Calls are
+// expected in any order, and calls to each method are answered with the
+// recorded responses in order. Once those are exhausted, the last recorded
+// response is used. Pings are answered by default, and deltas returned by
+// AllWatcher.Next calls are emitted with the recorded timing by the default
+// AllWatcher implementation. The Juju version is the one reported in the
+// recorded login response, if any.
+func CaptureScript(calls []capture.Call) *Script {
+	s := &Script{
+		Responses: make(map[string]interface{}),
+		Unordered: true,
+	}
+	var last time.Time
+	first := true
+	for _, call := range calls {
+		switch call.Method {
+		case "Pinger.Ping", "Client.WatchAll", "AllWatcher.Stop":
+			continue
+		case "AllWatcher.Next":
+			deltas := watcherDeltas(call.Response)
+			if deltas == nil {
+				continue
+			}
+			if first {
+				s.Watcher.Initial = deltas
+				first = false
+			} else {
+				var after time.Duration
+				if !last.IsZero() && !call.ResponseTime.IsZero() {
+					after = call.ResponseTime.Sub(last)
+				}
+				s.Watcher.Events = append(s.Watcher.Events, Event{
+					After:  Duration(after),
+					Deltas: deltas,
+				})
+			}
+			last = call.ResponseTime
+			continue
+		}
+		e := Expectation{
+			Method: call.Method,
+		}
+		switch {
+		case call.Error != nil && call.Error != "":
+			e.Error = fmt.Sprint(call.Error)
+		case call.Response != nil:
+			e.Response = call.Response
+			s.Responses[call.Method] = call.Response
+		default:
+			// No response has been recorded.
+			continue
+		}
+		if call.Method == "Admin.Login" && s.JujuVersion == "" {
+			if resp, ok := call.Response.(map[string]interface{}); ok {
+				s.JujuVersion, _ = resp["server-version"].(string)
+			}
+		}
+		s.Expect = append(s.Expect, e)
+	}
+	return s
+}
+
+// watcherDeltas returns the deltas in the given AllWatcher.Next response, or
+// nil if the response does not include deltas.
+func watcherDeltas(resp interface{}) []interface{} {
+	m, ok := resp.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	for _, key := range []string{"deltas", "Deltas"} {
+		if deltas, ok := m[key].([]interface{}); ok {
+			return deltas
+		}
+	}
+	return nil
+}
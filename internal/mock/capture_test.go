@@ -0,0 +1,78 @@
+package mock_test
+
+import (
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/juju/guiproxy/internal/capture"
+	"github.com/juju/guiproxy/internal/mock"
+)
+
+func TestCaptureScript(t *testing.T) {
+	c := qt.New(t)
+	start := time.Date(2018, 1, 18, 10, 0, 0, 0, time.UTC)
+	calls := []capture.Call{{
+		Method:   "Admin.Login",
+		Params:   map[string]interface{}{"auth-tag": "user-admin"},
+		Response: map[string]interface{}{"server-version": "2.2.9"},
+	}, {
+		Method:   "Client.WatchAll",
+		Response: map[string]interface{}{"watcher-id": "1"},
+	}, {
+		Method:       "AllWatcher.Next",
+		Response:     map[string]interface{}{"deltas": []interface{}{"initial"}},
+		ResponseTime: start,
+	}, {
+		Method: "Application.Deploy",
+		Error:  "bad wolf",
+	}, {
+		Method:       "AllWatcher.Next",
+		Response:     map[string]interface{}{"deltas": []interface{}{"change"}},
+		ResponseTime: start.Add(1500 * time.Millisecond),
+	}, {
+		Method:   "Client.FullStatus",
+		Response: map[string]interface{}{"model": "default"},
+	}, {
+		// Pings are answered by default.
+		Method:   "Pinger.Ping",
+		Response: map[string]interface{}{},
+	}, {
+		Method:   "Client.FullStatus",
+		Response: map[string]interface{}{"model": "changed"},
+	}, {
+		// Calls without a recorded response are ignored.
+		Method: "Application.Get",
+	}, {
+		Method: "AllWatcher.Stop",
+	}}
+	c.Assert(mock.CaptureScript(calls), qt.DeepEquals, &mock.Script{
+		JujuVersion: "2.2.9",
+		Responses: map[string]interface{}{
+			"Admin.Login":       map[string]interface{}{"server-version": "2.2.9"},
+			"Client.FullStatus": map[string]interface{}{"model": "changed"},
+		},
+		Expect: []mock.Expectation{{
+			Method:   "Admin.Login",
+			Response: map[string]interface{}{"server-version": "2.2.9"},
+		}, {
+			Method: "Application.Deploy",
+			Error:  "bad wolf",
+		}, {
+			Method:   "Client.FullStatus",
+			Response: map[string]interface{}{"model": "default"},
+		}, {
+			Method:   "Client.FullStatus",
+			Response: map[string]interface{}{"model": "changed"},
+		}},
+		Unordered: true,
+		Watcher: mock.Watcher{
+			Initial: []interface{}{"initial"},
+			Events: []mock.Event{{
+				After:  mock.Duration(1500 * time.Millisecond),
+				Deltas: []interface{}{"change"},
+			}},
+		},
+	})
+}
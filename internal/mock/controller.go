@@ -0,0 +1,605 @@
+package mock
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/juju/guiproxy/logger"
+)
+
+// NewController starts and returns a fake Juju controller driven by the given
+// script, serving the Juju WebSocket API over TLS on all paths, for instance
+// "/api" for the controller and "/model/<uuid>/api" for the model. Script
+// progress and unexpected calls are reported using the given logger, which
+// can be nil. The controller listens on a random local port, using a new
+// self-signed certificate, and must be closed when done.
+func NewController(s *Script, log logger.Interface) (*Controller, error) {
+	c := &Controller{
+		script:         s,
+		log:            log,
+		jujuVersion:    s.JujuVersion,
+		modelUUID:      s.ModelUUID,
+		controllerUUID: newUUID(),
+		expected:       s.Expect,
+		handlers:       make(map[string]HandlerFunc),
+		conns:          make(map[*websocket.Conn]bool),
+	}
+	if c.jujuVersion == "" {
+		c.jujuVersion = DefaultJujuVersion
+	}
+	if c.modelUUID == "" {
+		c.modelUUID = newUUID()
+	}
+	config, err := newTLSConfig()
+	if err != nil {
+		return nil, fmt.Errorf("cannot generate controller certificate: %s", err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("cannot listen for controller connections: %s", err)
+	}
+	c.Addr = l.Addr().String()
+	c.server = &http.Server{
+		Handler: http.HandlerFunc(c.serveAPI),
+	}
+	go c.server.Serve(tls.NewListener(l, config))
+	return c, nil
+}
+
+// Controller is a fake Juju controller driven by a script.
+type Controller struct {
+	// Addr holds the "host:port" address of the controller.
+	Addr string
+
+	script         *Script
+	log            logger.Interface
+	server         *http.Server
+	jujuVersion    string
+	modelUUID      string
+	controllerUUID string
+
+	mu       sync.Mutex
+	expected []Expectation
+	received int
+	handlers map[string]HandlerFunc
+	calls    []Call
+	conns    map[*websocket.Conn]bool
+	closed   bool
+}
+
+// Call holds an RPC call received by the fake controller.
+type Call struct {
+	// Path holds the path of the WebSocket connection used for the call, for
+	// instance "/api" or "/model/<uuid>/api".
+	Path string
+
+	// Facade, Version and Method identify the called API method.
+	Facade  string
+	Version int
+	Method  string
+
+	// Params holds the JSON encoded call parameters.
+	Params json.RawMessage
+}
+
+// HandlerFunc is used to respond to RPC calls. The returned response is
+// encoded as JSON. If an error is returned, an RPC error response is sent,
+// unless the error is ErrNotHandled.
+type HandlerFunc func(call Call) (interface{}, error)
+
+// ErrNotHandled is returned by handlers to let the controller respond as if
+// no handler was registered for the call.
+var ErrNotHandled = errors.New("call not handled")
+
+// JujuVersion returns the Juju version reported by the controller.
+func (c *Controller) JujuVersion() string {
+	return c.jujuVersion
+}
+
+// ModelUUID returns the UUID of the fake model.
+func (c *Controller) ModelUUID() string {
+	return c.modelUUID
+}
+
+// Pending returns the "Facade.Method" of expected calls not received yet, in
+// order.
+func (c *Controller) Pending() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	pending := make([]string, len(c.expected))
+	for i, e := range c.expected {
+		pending[i] = e.Method
+	}
+	return pending
+}
+
+// Handle registers the given handler for all calls to the given
+// "Facade.Method", replacing any previously registered handler. Handlers are
+// used for calls not matching the next expectation, and take precedence over
+// canned and default responses.
+func (c *Controller) Handle(method string, h HandlerFunc) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.handlers[method] = h
+}
+
+// Calls returns the RPC calls received so far, in order.
+func (c *Controller) Calls() []Call {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Call(nil), c.calls...)
+}
+
+// Close shuts down the fake controller, closing all its connections.
+func (c *Controller) Close() {
+	c.server.Close()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	for conn := range c.conns {
+		conn.Close()
+	}
+}
+
+// track records the given WebSocket connection so that it can be closed when
+// the controller is closed, and reports whether the controller is still open.
+// Connections must be untracked once done.
+func (c *Controller) track(conn *websocket.Conn) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return false
+	}
+	c.conns[conn] = true
+	return true
+}
+
+// untrack forgets the given WebSocket connection.
+func (c *Controller) untrack(conn *websocket.Conn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.conns, conn)
+}
+
+// request holds a Juju RPC request.
+type request struct {
+	RequestID uint64          `json:"request-id"`
+	Type      string          `json:"type"`
+	Version   int             `json:"version"`
+	ID        string          `json:"id,omitempty"`
+	Request   string          `json:"request"`
+	Params    json.RawMessage `json:"params,omitempty"`
+}
+
+// response holds a Juju RPC response.
+type response struct {
+	RequestID uint64      `json:"request-id"`
+	Response  interface{} `json:"response,omitempty"`
+	Error     string      `json:"error,omitempty"`
+}
+
+// upgrader is used to upgrade connections to the fake controller.
+var upgrader = websocket.Upgrader{}
+
+// serveAPI serves the Juju WebSocket API. Requests are handled in the order
+// they are received, except that AllWatcher.Next calls, which block until
+// deltas are available, are responded to concurrently.
+func (c *Controller) serveAPI(w http.ResponseWriter, req *http.Request) {
+	conn, err := upgrader.Upgrade(w, req, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	if !c.track(conn) {
+		return
+	}
+	defer c.untrack(conn)
+	cn := &connection{
+		controller: c,
+		conn:       conn,
+		path:       req.URL.Path,
+		watchers:   make(map[string]*watcher),
+		done:       make(chan struct{}),
+	}
+	defer close(cn.done)
+	for {
+		var r request
+		if err := conn.ReadJSON(&r); err != nil {
+			return
+		}
+		reply, blocking := cn.respond(r)
+		if blocking {
+			go cn.handle(r, reply)
+		} else {
+			cn.handle(r, reply)
+		}
+	}
+}
+
+// connection holds the state of a WebSocket connection to the controller.
+type connection struct {
+	controller *Controller
+	conn       *websocket.Conn
+	path       string
+	done       chan struct{}
+
+	writeMu sync.Mutex
+
+	mu       sync.Mutex
+	lastID   int
+	watchers map[string]*watcher
+}
+
+// handle responds to the given request using the result of the given reply
+// function.
+func (cn *connection) handle(r request, reply func() (interface{}, error)) {
+	resp := response{
+		RequestID: r.RequestID,
+	}
+	result, err := reply()
+	if err != nil {
+		resp.Error = err.Error()
+	} else {
+		if result == nil {
+			result = struct{}{}
+		}
+		resp.Response = result
+	}
+	cn.writeMu.Lock()
+	defer cn.writeMu.Unlock()
+	cn.conn.WriteJSON(resp)
+}
+
+// respond records the given request and returns a function producing its
+// result. The next expectation is used if it matches, then handlers, canned
+// responses, and finally default responses. Expectations are matched when respond is called, so that
+// they are consumed in order, while the returned function may block, in which
+// case blocking is true.
+func (cn *connection) respond(r request) (reply func() (interface{}, error), blocking bool) {
+	c := cn.controller
+	method := r.Type + "." + r.Request
+	values := templateValues{
+		requestID: r.RequestID,
+		modelUUID: c.modelUUID,
+	}
+	call := Call{
+		Path:    cn.path,
+		Facade:  r.Type,
+		Version: r.Version,
+		Method:  r.Request,
+		Params:  r.Params,
+	}
+	h := c.record(call)
+	if e, ok := c.expectation(method, r.Params); ok {
+		if e.Error != "" {
+			return failure(errors.New(values.expandString(e.Error))), false
+		}
+		return success(values.expand(e.Response)), false
+	}
+	if h != nil {
+		result, err := h(call)
+		if err != ErrNotHandled {
+			return func() (interface{}, error) {
+				return result, err
+			}, false
+		}
+	}
+	if resp, ok := c.script.Responses[method]; ok {
+		return success(values.expand(resp)), false
+	}
+	switch method {
+	case "Admin.Login":
+		return success(c.login()), false
+	case "Pinger.Ping":
+		return success(nil), false
+	case "Client.WatchAll":
+		return success(map[string]string{
+			"watcher-id": cn.newWatcher(),
+		}), false
+	case "AllWatcher.Next":
+		w := cn.watcher(r.ID)
+		if w == nil {
+			return failure(fmt.Errorf("unknown watcher id %q", r.ID)), false
+		}
+		return func() (interface{}, error) {
+			deltas, err := w.next(cn.done)
+			if err != nil {
+				return nil, err
+			}
+			return map[string]interface{}{
+				"deltas": values.expand(deltas),
+			}, nil
+		}, true
+	case "AllWatcher.Stop":
+		w := cn.watcher(r.ID)
+		if w == nil {
+			return failure(fmt.Errorf("unknown watcher id %q", r.ID)), false
+		}
+		w.stop()
+		return success(nil), false
+	}
+	c.logf("unexpected call to %s%s", method, c.expecting())
+	return failure(fmt.Errorf("unexpected call to %s", method)), false
+}
+
+// success returns a reply function returning the given result.
+func success(result interface{}) func() (interface{}, error) {
+	return func() (interface{}, error) {
+		return result, nil
+	}
+}
+
+// failure returns a reply function returning the given error.
+func failure(err error) func() (interface{}, error) {
+	return func() (interface{}, error) {
+		return nil, err
+	}
+}
+
+// record records the given call and returns the handler registered for it,
+// or nil if there is none.
+func (c *Controller) record(call Call) HandlerFunc {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls = append(c.calls, call)
+	return c.handlers[call.Facade+"."+call.Method]
+}
+
+// expectation returns the next expectation if it matches the given call, in
+// which case the expectation is consumed. If the script is unordered, the
+// first matching pending expectation is used instead.
+func (c *Controller) expectation(method string, params json.RawMessage) (Expectation, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	i := -1
+	for j, e := range c.expected {
+		if e.Method == method && matchParams(params, e.Params) {
+			i = j
+			break
+		}
+		if !c.script.Unordered {
+			break
+		}
+	}
+	if i == -1 {
+		return Expectation{}, false
+	}
+	e := c.expected[i]
+	if i == 0 {
+		c.expected = c.expected[1:]
+	} else {
+		// Copy the pending expectations so that the script is not modified.
+		c.expected = append(append([]Expectation(nil), c.expected[:i]...), c.expected[i+1:]...)
+	}
+	c.received++
+	c.logf("expected call %d/%d to %s received", c.received, len(c.script.Expect), method)
+	if len(c.expected) == 0 {
+		c.logf("all expected calls received")
+	}
+	return e, true
+}
+
+// expecting describes the next expected call, if any.
+func (c *Controller) expecting() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.expected) == 0 {
+		return ""
+	}
+	return " (expecting " + c.expected[0].Method + ")"
+}
+
+// logf logs a formatted message if a logger has been provided.
+func (c *Controller) logf(format string, args ...interface{}) {
+	if c.log != nil {
+		c.log.Print(fmt.Sprintf(format, args...))
+	}
+}
+
+// login returns the default response to login requests.
+func (c *Controller) login() interface{} {
+	names := make([]string, 0, len(defaultFacades))
+	for name := range defaultFacades {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	facades := make([]interface{}, len(names))
+	for i, name := range names {
+		facades[i] = map[string]interface{}{
+			"name":     name,
+			"versions": []int{defaultFacades[name]},
+		}
+	}
+	return map[string]interface{}{
+		"server-version": c.jujuVersion,
+		"model-tag":      "model-" + c.modelUUID,
+		"controller-tag": "controller-" + c.controllerUUID,
+		"facades":        facades,
+		"user-info": map[string]interface{}{
+			"identity":          "user-admin",
+			"display-name":      "admin",
+			"controller-access": "superuser",
+			"model-access":      "admin",
+		},
+	}
+}
+
+// defaultFacades holds the facade versions advertised on login.
+var defaultFacades = map[string]int{
+	"Action":          2,
+	"AllModelWatcher": 2,
+	"AllWatcher":      1,
+	"Annotations":     2,
+	"Application":     5,
+	"Bundle":          1,
+	"Charms":          2,
+	"Client":          1,
+	"Cloud":           2,
+	"Controller":      4,
+	"KeyManager":      1,
+	"MachineManager":  4,
+	"ModelManager":    4,
+	"Pinger":          1,
+	"Spaces":          3,
+	"UserManager":     1,
+}
+
+// matchParams reports whether the given JSON encoded request parameters
+// include the expected values.
+func matchParams(params json.RawMessage, expected interface{}) bool {
+	if expected == nil {
+		return true
+	}
+	var actual interface{}
+	if err := json.Unmarshal(params, &actual); err != nil {
+		return false
+	}
+	var exp interface{}
+	data, err := json.Marshal(expected)
+	if err != nil {
+		return false
+	}
+	json.Unmarshal(data, &exp)
+	return includes(actual, exp)
+}
+
+// includes reports whether the actual decoded JSON value includes the
+// expected one: objects must include all the expected keys, lists must have
+// the same length and include the expected items, while other values must be
+// equal.
+func includes(actual, expected interface{}) bool {
+	switch exp := expected.(type) {
+	case map[string]interface{}:
+		act, ok := actual.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		for key, value := range exp {
+			if !includes(act[key], value) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		act, ok := actual.([]interface{})
+		if !ok || len(act) != len(exp) {
+			return false
+		}
+		for i, value := range exp {
+			if !includes(act[i], value) {
+				return false
+			}
+		}
+		return true
+	}
+	return reflect.DeepEqual(actual, expected)
+}
+
+// newWatcher creates a watcher emitting the script deltas and returns its id.
+func (cn *connection) newWatcher() string {
+	cn.mu.Lock()
+	defer cn.mu.Unlock()
+	cn.lastID++
+	id := strconv.Itoa(cn.lastID)
+	cn.watchers[id] = newWatcher(cn.controller.script.Watcher)
+	return id
+}
+
+// watcher returns the watcher with the given id, or nil if not found.
+func (cn *connection) watcher(id string) *watcher {
+	cn.mu.Lock()
+	defer cn.mu.Unlock()
+	return cn.watchers[id]
+}
+
+// newWatcher returns a watcher emitting the given deltas.
+func newWatcher(w Watcher) *watcher {
+	batches := make([]batch, 0, len(w.Events)+1)
+	batches = append(batches, batch{
+		deltas: w.Initial,
+	})
+	var due time.Duration
+	for _, e := range w.Events {
+		due += time.Duration(e.After)
+		batches = append(batches, batch{
+			due:    due,
+			deltas: e.Deltas,
+		})
+	}
+	return &watcher{
+		start:   time.Now(),
+		batches: batches,
+		stopped: make(chan struct{}),
+	}
+}
+
+// watcher holds the state of a fake AllWatcher.
+type watcher struct {
+	start    time.Time
+	stopped  chan struct{}
+	stopOnce sync.Once
+
+	// mu serializes calls to next.
+	mu      sync.Mutex
+	batches []batch
+}
+
+// batch holds deltas to be emitted after the due time since the start of
+// the watcher has elapsed.
+type batch struct {
+	due    time.Duration
+	deltas []interface{}
+}
+
+// errStopped is returned by watchers after they have been stopped.
+var errStopped = errors.New("watcher was stopped")
+
+// next waits for the next batch of deltas and returns it. After all deltas
+// have been emitted, it blocks until the watcher is stopped or the given done
+// channel is closed.
+func (w *watcher) next(done <-chan struct{}) ([]interface{}, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.batches) == 0 {
+		select {
+		case <-done:
+		case <-w.stopped:
+		}
+		return nil, errStopped
+	}
+	b := w.batches[0]
+	if wait := time.Until(w.start.Add(b.due)); wait > 0 {
+		t := time.NewTimer(wait)
+		defer t.Stop()
+		select {
+		case <-t.C:
+		case <-done:
+			return nil, errStopped
+		case <-w.stopped:
+			return nil, errStopped
+		}
+	}
+	w.batches = w.batches[1:]
+	if b.deltas == nil {
+		return []interface{}{}, nil
+	}
+	return b.deltas, nil
+}
+
+// stop stops the watcher, unblocking pending calls to next.
+func (w *watcher) stop() {
+	w.stopOnce.Do(func() {
+		close(w.stopped)
+	})
+}
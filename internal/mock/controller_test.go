@@ -0,0 +1,352 @@
+package mock_test
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/gorilla/websocket"
+
+	"github.com/juju/guiproxy/internal/mock"
+)
+
+func TestController(t *testing.T) {
+	c := qt.New(t)
+	defer c.Cleanup()
+	c.Patch(mock.TimeNow, func() time.Time {
+		return time.Date(2018, 1, 1, 12, 0, 0, 0, time.UTC)
+	})
+	s, err := mock.ParseScript([]byte(`
+juju-version: 2.4.0
+model-uuid: 5ff0d7b6-12b7-4fe5-8a5e-5b3a5d0b9d4f
+responses:
+  Client.ModelInfo: {uuid: "{{model-uuid}}", since: "{{now}}"}
+expect:
+  - method: Application.Deploy
+    params: {applications: [{application: django}]}
+    response: {request: "{{request-id}}", results: [{}]}
+  - method: Application.Destroy
+    error: cannot destroy application
+`))
+	c.Assert(err, qt.Equals, nil)
+	var logged []string
+	ctl, err := mock.NewController(s, logFunc(func(msg string) {
+		logged = append(logged, msg)
+	}))
+	c.Assert(err, qt.Equals, nil)
+	defer ctl.Close()
+	c.Assert(ctl.JujuVersion(), qt.Equals, "2.4.0")
+	c.Assert(ctl.ModelUUID(), qt.Equals, "5ff0d7b6-12b7-4fe5-8a5e-5b3a5d0b9d4f")
+	c.Assert(ctl.Pending(), qt.DeepEquals, []string{"Application.Deploy", "Application.Destroy"})
+
+	conn := dial(c, ctl, "/model/5ff0d7b6-12b7-4fe5-8a5e-5b3a5d0b9d4f/api")
+	defer conn.Close()
+
+	// Login responses are provided by default.
+	resp := call(c, conn, 1, "Admin", "Login", "", nil)
+	c.Assert(resp["error"], qt.IsNil)
+	login := resp["response"].(map[string]interface{})
+	c.Assert(login["server-version"], qt.Equals, "2.4.0")
+	c.Assert(login["model-tag"], qt.Equals, "model-5ff0d7b6-12b7-4fe5-8a5e-5b3a5d0b9d4f")
+
+	// Canned responses are templated.
+	resp = call(c, conn, 2, "Client", "ModelInfo", "", nil)
+	c.Assert(resp["response"], qt.DeepEquals, map[string]interface{}{
+		"uuid":  "5ff0d7b6-12b7-4fe5-8a5e-5b3a5d0b9d4f",
+		"since": "2018-01-01T12:00:00Z",
+	})
+
+	// Calls not matching the next expectation are not accepted.
+	resp = call(c, conn, 3, "Application", "Deploy", "", map[string]interface{}{
+		"applications": []interface{}{map[string]interface{}{"application": "rails"}},
+	})
+	c.Assert(resp["error"], qt.Equals, "unexpected call to Application.Deploy")
+	resp = call(c, conn, 4, "Application", "Destroy", "", nil)
+	c.Assert(resp["error"], qt.Equals, "unexpected call to Application.Destroy")
+
+	// Expected calls are answered in order.
+	resp = call(c, conn, 5, "Application", "Deploy", "", map[string]interface{}{
+		"applications": []interface{}{map[string]interface{}{"application": "django", "num-units": 1}},
+	})
+	c.Assert(resp["response"], qt.DeepEquals, map[string]interface{}{
+		"request": 5.0,
+		"results": []interface{}{map[string]interface{}{}},
+	})
+	c.Assert(ctl.Pending(), qt.DeepEquals, []string{"Application.Destroy"})
+	resp = call(c, conn, 6, "Application", "Destroy", "", nil)
+	c.Assert(resp["error"], qt.Equals, "cannot destroy application")
+	c.Assert(ctl.Pending(), qt.HasLen, 0)
+
+	c.Assert(logged, qt.DeepEquals, []string{
+		"unexpected call to Application.Deploy (expecting Application.Deploy)",
+		"unexpected call to Application.Destroy (expecting Application.Deploy)",
+		"expected call 1/2 to Application.Deploy received",
+		"expected call 2/2 to Application.Destroy received",
+		"all expected calls received",
+	})
+}
+
+func TestControllerWatcher(t *testing.T) {
+	c := qt.New(t)
+	s, err := mock.ParseScript([]byte(`
+watcher:
+  initial:
+    - [application, change, {name: django}]
+  events:
+    - after: 10ms
+      deltas:
+        - [unit, change, {name: django/0, workload-status: {current: error}}]
+    - after: 10ms
+      deltas:
+        - [relation, change, {key: "django:db postgresql:db"}]
+`))
+	c.Assert(err, qt.Equals, nil)
+	ctl, err := mock.NewController(s, nil)
+	c.Assert(err, qt.Equals, nil)
+	defer ctl.Close()
+	conn := dial(c, ctl, "/model/"+ctl.ModelUUID()+"/api")
+	defer conn.Close()
+
+	resp := call(c, conn, 1, "Client", "WatchAll", "", nil)
+	id := resp["response"].(map[string]interface{})["watcher-id"].(string)
+
+	resp = call(c, conn, 2, "AllWatcher", "Next", id, nil)
+	c.Assert(resp["response"], qt.DeepEquals, map[string]interface{}{
+		"deltas": []interface{}{
+			[]interface{}{"application", "change", map[string]interface{}{"name": "django"}},
+		},
+	})
+	resp = call(c, conn, 3, "AllWatcher", "Next", id, nil)
+	c.Assert(resp["response"], qt.DeepEquals, map[string]interface{}{
+		"deltas": []interface{}{
+			[]interface{}{"unit", "change", map[string]interface{}{
+				"name":            "django/0",
+				"workload-status": map[string]interface{}{"current": "error"},
+			}},
+		},
+	})
+	resp = call(c, conn, 4, "AllWatcher", "Next", id, nil)
+	c.Assert(resp["response"], qt.DeepEquals, map[string]interface{}{
+		"deltas": []interface{}{
+			[]interface{}{"relation", "change", map[string]interface{}{"key": "django:db postgresql:db"}},
+		},
+	})
+
+	// Once all deltas are emitted, Next blocks until the watcher is stopped.
+	send(c, conn, 5, "AllWatcher", "Next", id, nil)
+	send(c, conn, 6, "AllWatcher", "Stop", id, nil)
+	responses := map[float64]interface{}{}
+	for i := 0; i < 2; i++ {
+		resp := receive(c, conn)
+		responses[resp["request-id"].(float64)] = resp["error"]
+	}
+	c.Assert(responses, qt.DeepEquals, map[float64]interface{}{
+		5: "watcher was stopped",
+		6: nil,
+	})
+
+	resp = call(c, conn, 7, "AllWatcher", "Next", "42", nil)
+	c.Assert(resp["error"], qt.Equals, `unknown watcher id "42"`)
+}
+
+func TestControllerPipelinedCalls(t *testing.T) {
+	c := qt.New(t)
+	s, err := mock.ParseScript([]byte(`
+expect:
+  - method: Application.Deploy
+    response: {request: "{{request-id}}"}
+  - method: Application.Expose
+    response: {request: "{{request-id}}"}
+  - method: Application.Destroy
+    response: {request: "{{request-id}}"}
+`))
+	c.Assert(err, qt.Equals, nil)
+	ctl, err := mock.NewController(s, nil)
+	c.Assert(err, qt.Equals, nil)
+	defer ctl.Close()
+	conn := dial(c, ctl, "/model/"+ctl.ModelUUID()+"/api")
+	defer conn.Close()
+
+	// Requests sent without waiting for responses are matched in order,
+	// while a blocked AllWatcher.Next call does not hold up other calls.
+	resp := call(c, conn, 1, "Client", "WatchAll", "", nil)
+	id := resp["response"].(map[string]interface{})["watcher-id"].(string)
+	resp = call(c, conn, 2, "AllWatcher", "Next", id, nil)
+	c.Assert(resp["error"], qt.IsNil)
+	send(c, conn, 3, "AllWatcher", "Next", id, nil)
+	send(c, conn, 4, "Application", "Deploy", "", nil)
+	send(c, conn, 5, "Application", "Expose", "", nil)
+	send(c, conn, 6, "Application", "Destroy", "", nil)
+	for i := 4; i <= 6; i++ {
+		resp := receive(c, conn)
+		c.Assert(resp["request-id"], qt.Equals, float64(i))
+		c.Assert(resp["response"], qt.DeepEquals, map[string]interface{}{
+			"request": float64(i),
+		})
+	}
+	c.Assert(ctl.Pending(), qt.HasLen, 0)
+}
+
+func TestControllerUnordered(t *testing.T) {
+	c := qt.New(t)
+	s, err := mock.ParseScript([]byte(`
+unordered: true
+responses:
+  Client.FullStatus: {model: last}
+expect:
+  - method: Client.FullStatus
+    response: {model: first}
+  - method: Application.Get
+    params: {application: rails}
+    response: {application: rails}
+  - method: Application.Get
+    response: {application: any}
+  - method: Client.FullStatus
+    response: {model: second}
+`))
+	c.Assert(err, qt.Equals, nil)
+	ctl, err := mock.NewController(s, nil)
+	c.Assert(err, qt.Equals, nil)
+	defer ctl.Close()
+	conn := dial(c, ctl, "/model/"+ctl.ModelUUID()+"/api")
+	defer conn.Close()
+
+	// Pending expectations are used in order for each method.
+	resp := call(c, conn, 1, "Application", "Get", "", map[string]string{"application": "django"})
+	c.Assert(resp["response"], qt.DeepEquals, map[string]interface{}{"application": "any"})
+	resp = call(c, conn, 2, "Client", "FullStatus", "", nil)
+	c.Assert(resp["response"], qt.DeepEquals, map[string]interface{}{"model": "first"})
+	resp = call(c, conn, 3, "Client", "FullStatus", "", nil)
+	c.Assert(resp["response"], qt.DeepEquals, map[string]interface{}{"model": "second"})
+	c.Assert(ctl.Pending(), qt.DeepEquals, []string{"Application.Get"})
+
+	// Canned responses are used once expectations are exhausted.
+	resp = call(c, conn, 4, "Client", "FullStatus", "", nil)
+	c.Assert(resp["response"], qt.DeepEquals, map[string]interface{}{"model": "last"})
+	resp = call(c, conn, 5, "Application", "Get", "", map[string]string{"application": "rails"})
+	c.Assert(resp["response"], qt.DeepEquals, map[string]interface{}{"application": "rails"})
+	c.Assert(ctl.Pending(), qt.HasLen, 0)
+
+	// The script is not modified.
+	c.Assert(s.Expect, qt.HasLen, 4)
+	c.Assert(s.Expect[2].Method, qt.Equals, "Application.Get")
+}
+
+func TestControllerHandle(t *testing.T) {
+	c := qt.New(t)
+	s, err := mock.ParseScript([]byte(`
+responses:
+  Client.ModelInfo: {name: canned}
+expect:
+  - method: Client.ModelInfo
+    response: {name: expected}
+`))
+	c.Assert(err, qt.Equals, nil)
+	ctl, err := mock.NewController(s, nil)
+	c.Assert(err, qt.Equals, nil)
+	defer ctl.Close()
+	handled := true
+	ctl.Handle("Client.ModelInfo", func(call mock.Call) (interface{}, error) {
+		if !handled {
+			return nil, mock.ErrNotHandled
+		}
+		return map[string]string{"name": "handled"}, nil
+	})
+	ctl.Handle("Application.Get", func(call mock.Call) (interface{}, error) {
+		return nil, errors.New("application not found")
+	})
+	conn := dial(c, ctl, "/model/"+ctl.ModelUUID()+"/api")
+	defer conn.Close()
+
+	// Expectations take precedence over handlers, which take precedence
+	// over canned responses unless they do not handle the call.
+	resp := call(c, conn, 1, "Client", "ModelInfo", "", nil)
+	c.Assert(resp["response"], qt.DeepEquals, map[string]interface{}{"name": "expected"})
+	resp = call(c, conn, 2, "Client", "ModelInfo", "", nil)
+	c.Assert(resp["response"], qt.DeepEquals, map[string]interface{}{"name": "handled"})
+	handled = false
+	resp = call(c, conn, 3, "Client", "ModelInfo", "", nil)
+	c.Assert(resp["response"], qt.DeepEquals, map[string]interface{}{"name": "canned"})
+	resp = call(c, conn, 4, "Application", "Get", "", map[string]string{"application": "django"})
+	c.Assert(resp["error"], qt.Equals, "application not found")
+
+	// Calls have been recorded.
+	calls := ctl.Calls()
+	c.Assert(calls, qt.HasLen, 4)
+	c.Assert(calls[3], qt.DeepEquals, mock.Call{
+		Path:    "/model/" + ctl.ModelUUID() + "/api",
+		Facade:  "Application",
+		Version: 1,
+		Method:  "Get",
+		Params:  json.RawMessage(`{"application":"django"}`),
+	})
+}
+
+func TestControllerClose(t *testing.T) {
+	c := qt.New(t)
+	ctl, err := mock.NewController(&mock.Script{}, nil)
+	c.Assert(err, qt.Equals, nil)
+	conn := dial(c, ctl, "/api")
+	defer conn.Close()
+	resp := call(c, conn, 1, "Pinger", "Ping", "", nil)
+	c.Assert(resp["error"], qt.IsNil)
+
+	// Closing the controller closes open connections.
+	ctl.Close()
+	_, _, err = conn.ReadMessage()
+	c.Assert(err, qt.Not(qt.IsNil))
+}
+
+// logFunc implements logger.Interface by calling the function itself.
+type logFunc func(msg string)
+
+func (f logFunc) Print(msg string) {
+	f(msg)
+}
+
+// dial connects to the given path of the given fake controller.
+func dial(c *qt.C, ctl *mock.Controller, path string) *websocket.Conn {
+	dialer := &websocket.Dialer{
+		TLSClientConfig: &tls.Config{
+			InsecureSkipVerify: true,
+		},
+	}
+	conn, _, err := dialer.Dial("wss://"+ctl.Addr+path, nil)
+	c.Assert(err, qt.Equals, nil)
+	return conn
+}
+
+// call makes an RPC call on the given connection and returns the decoded
+// response.
+func call(c *qt.C, conn *websocket.Conn, requestID int, facade, method, id string, params interface{}) map[string]interface{} {
+	send(c, conn, requestID, facade, method, id, params)
+	resp := receive(c, conn)
+	c.Assert(resp["request-id"], qt.Equals, float64(requestID))
+	return resp
+}
+
+// send sends an RPC request on the given connection.
+func send(c *qt.C, conn *websocket.Conn, requestID int, facade, method, id string, params interface{}) {
+	err := conn.WriteJSON(map[string]interface{}{
+		"request-id": requestID,
+		"type":       facade,
+		"version":    1,
+		"id":         id,
+		"request":    method,
+		"params":     params,
+	})
+	c.Assert(err, qt.Equals, nil)
+}
+
+// receive reads and decodes an RPC response from the given connection.
+func receive(c *qt.C, conn *websocket.Conn) map[string]interface{} {
+	_, data, err := conn.ReadMessage()
+	c.Assert(err, qt.Equals, nil)
+	var resp map[string]interface{}
+	err = json.Unmarshal(data, &resp)
+	c.Assert(err, qt.Equals, nil)
+	return resp
+}
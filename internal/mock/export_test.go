@@ -0,0 +1,3 @@
+package mock
+
+var TimeNow = &timeNow
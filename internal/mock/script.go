@@ -0,0 +1,154 @@
+// Package mock implements a scriptable fake Juju controller, used to run the
+// GUI without a real controller.
+package mock
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/juju/guiproxy/internal/yamljson"
+)
+
+// Script describes the conversation between the GUI and the fake controller.
+// Strings in responses and deltas can include the following placeholders:
+//   - {{request-id}}: the id of the request being answered;
+//   - {{uuid}}: a new random UUID;
+//   - {{model-uuid}}: the UUID of the fake model;
+//   - {{now}}: the current time, in RFC 3339 format.
+type Script struct {
+	// JujuVersion optionally holds the Juju version reported on login.
+	// It defaults to DefaultJujuVersion.
+	JujuVersion string `json:"juju-version,omitempty"`
+
+	// ModelUUID optionally holds the UUID of the fake model. A random UUID
+	// is used if empty.
+	ModelUUID string `json:"model-uuid,omitempty"`
+
+	// Responses holds canned responses, keyed by "Facade.Method", used for
+	// calls not matching the next expectation. Responses for "Admin.Login",
+	// "Pinger.Ping" and the AllWatcher calls are provided by default.
+	Responses map[string]interface{} `json:"responses,omitempty"`
+
+	// Expect holds the calls expected from the GUI, in order.
+	Expect []Expectation `json:"expect,omitempty"`
+
+	// Unordered holds whether expected calls can be received in any order.
+	// If set, a call not matching the next expectation is matched against
+	// the first pending expectation with the same method and matching
+	// params, so that expectations for each method are used in order.
+	Unordered bool `json:"unordered,omitempty"`
+
+	// Watcher holds the deltas returned by AllWatcher.Next calls.
+	Watcher Watcher `json:"watcher,omitempty"`
+}
+
+// DefaultJujuVersion holds the Juju version reported by default on login.
+const DefaultJujuVersion = "2.3.1"
+
+// Expectation holds an expected call and the corresponding response.
+type Expectation struct {
+	// Method holds the expected "Facade.Method".
+	Method string `json:"method"`
+
+	// Params optionally holds values that must be included in the request
+	// parameters for the call to match.
+	Params interface{} `json:"params,omitempty"`
+
+	// Response holds the response to the call.
+	Response interface{} `json:"response,omitempty"`
+
+	// Error optionally holds an error message returned instead of the
+	// response.
+	Error string `json:"error,omitempty"`
+}
+
+// Watcher holds the deltas sent to the GUI through the AllWatcher.
+type Watcher struct {
+	// Initial holds the deltas returned by the first AllWatcher.Next call,
+	// describing the initial state of the model.
+	Initial []interface{} `json:"initial,omitempty"`
+
+	// Events holds deltas returned by subsequent AllWatcher.Next calls,
+	// each one emitted after its delay has elapsed.
+	Events []Event `json:"events,omitempty"`
+}
+
+// Event holds a set of deltas emitted on a timer.
+type Event struct {
+	// After holds the delay from the previous event, or from the start of
+	// the watcher for the first event.
+	After Duration `json:"after"`
+
+	// Deltas holds the deltas to emit, for instance:
+	//   ["unit", "change", {"name": "django/1", ...}]
+	Deltas []interface{} `json:"deltas"`
+}
+
+// Duration holds a time duration decoded from strings like "1.5s".
+type Duration time.Duration
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("invalid duration %s", data)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("invalid duration %q", s)
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// ReadScript reads and returns the YAML or JSON script at the given path.
+func ReadScript(path string) (*Script, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read script: %s", err)
+	}
+	s, err := ParseScript(data)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse script %q: %s", path, err)
+	}
+	return s, nil
+}
+
+// ParseScript parses and returns the given YAML or JSON script.
+func ParseScript(data []byte) (*Script, error) {
+	data, err := yamlToJSON(data)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var s Script
+	if err := dec.Decode(&s); err != nil {
+		return nil, err
+	}
+	for i, e := range s.Expect {
+		if e.Method == "" {
+			return nil, fmt.Errorf("expectation %d: method not specified", i+1)
+		}
+	}
+	return &s, nil
+}
+
+// yamlToJSON converts the given YAML document, which can also be JSON, to
+// JSON, so that the same decoding rules apply to both formats.
+func yamlToJSON(data []byte) ([]byte, error) {
+	var v interface{}
+	if err := yaml.Unmarshal(data, &v); err != nil {
+		return nil, err
+	}
+	v, err := yamljson.Value(v)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
@@ -0,0 +1,143 @@
+package mock_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/juju/guiproxy/internal/mock"
+)
+
+var parseScriptTests = []struct {
+	about          string
+	data           string
+	expectedScript *mock.Script
+	expectedError  string
+}{{
+	about:          "empty script",
+	expectedScript: &mock.Script{},
+}, {
+	about: "YAML script",
+	data: `
+juju-version: 2.4.0
+model-uuid: 5ff0d7b6-12b7-4fe5-8a5e-5b3a5d0b9d4f
+responses:
+  Client.FullStatus:
+    model: {name: default}
+expect:
+  - method: Application.Deploy
+    params: {applications: [{application: django}]}
+    response: {results: [{}]}
+  - method: Application.Destroy
+    error: application {{uuid}} not found
+watcher:
+  initial:
+    - [application, change, {name: django}]
+  events:
+    - after: 1.5s
+      deltas:
+        - [unit, change, {name: django/0, workload-status: {current: error}}]
+`,
+	expectedScript: &mock.Script{
+		JujuVersion: "2.4.0",
+		ModelUUID:   "5ff0d7b6-12b7-4fe5-8a5e-5b3a5d0b9d4f",
+		Responses: map[string]interface{}{
+			"Client.FullStatus": map[string]interface{}{
+				"model": map[string]interface{}{"name": "default"},
+			},
+		},
+		Expect: []mock.Expectation{{
+			Method: "Application.Deploy",
+			Params: map[string]interface{}{
+				"applications": []interface{}{
+					map[string]interface{}{"application": "django"},
+				},
+			},
+			Response: map[string]interface{}{
+				"results": []interface{}{map[string]interface{}{}},
+			},
+		}, {
+			Method: "Application.Destroy",
+			Error:  "application {{uuid}} not found",
+		}},
+		Watcher: mock.Watcher{
+			Initial: []interface{}{
+				[]interface{}{"application", "change", map[string]interface{}{"name": "django"}},
+			},
+			Events: []mock.Event{{
+				After: mock.Duration(1500 * time.Millisecond),
+				Deltas: []interface{}{
+					[]interface{}{"unit", "change", map[string]interface{}{
+						"name":            "django/0",
+						"workload-status": map[string]interface{}{"current": "error"},
+					}},
+				},
+			}},
+		},
+	},
+}, {
+	about: "JSON script",
+	data:  `{"expect": [{"method": "Pinger.Ping"}], "responses": {"Client.ModelInfo": {"life": "alive", "units": 42}}}`,
+	expectedScript: &mock.Script{
+		Responses: map[string]interface{}{
+			"Client.ModelInfo": map[string]interface{}{
+				"life":  "alive",
+				"units": json.Number("42"),
+			},
+		},
+		Expect: []mock.Expectation{{
+			Method: "Pinger.Ping",
+		}},
+	},
+}, {
+	about:         "invalid YAML",
+	data:          "expect: [",
+	expectedError: "yaml: .*",
+}, {
+	about:         "invalid duration",
+	data:          "watcher: {events: [{after: forever}]}",
+	expectedError: `invalid duration "forever"`,
+}, {
+	about:         "missing method",
+	data:          "expect: [{response: {}}, {method: Pinger.Ping}]",
+	expectedError: "expectation 1: method not specified",
+}, {
+	about:         "invalid key",
+	data:          "responses: {42: {}}",
+	expectedError: "invalid key 42: keys must be strings",
+}}
+
+func TestParseScript(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range parseScriptTests {
+		c.Run(test.about, func(c *qt.C) {
+			s, err := mock.ParseScript([]byte(test.data))
+			if test.expectedError != "" {
+				c.Assert(err, qt.ErrorMatches, test.expectedError)
+				c.Assert(s, qt.IsNil)
+				return
+			}
+			c.Assert(err, qt.Equals, nil)
+			c.Assert(s, qt.DeepEquals, test.expectedScript)
+		})
+	}
+}
+
+func TestReadScript(t *testing.T) {
+	c := qt.New(t)
+	defer c.Cleanup()
+	path := filepath.Join(c.Mkdir(), "script.yaml")
+	err := ioutil.WriteFile(path, []byte("juju-version: 2.4.0"), 0600)
+	c.Assert(err, qt.Equals, nil)
+	s, err := mock.ReadScript(path)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(s.JujuVersion, qt.Equals, "2.4.0")
+
+	s, err = mock.ReadScript(path + ".missing")
+	c.Assert(err, qt.ErrorMatches, "cannot read script: .*")
+	c.Assert(s, qt.IsNil)
+}
@@ -0,0 +1,676 @@
+package mock
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ReadStatus reads the model status at the given path and adds it to the
+// script, as described in AddStatus.
+func (s *Script) ReadStatus(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("cannot read status: %s", err)
+	}
+	if err := s.AddStatus(data); err != nil {
+		return fmt.Errorf("cannot use status %q: %s", path, err)
+	}
+	return nil
+}
+
+// AddStatus adds the given model status to the script, so that the status is
+// returned by Client.FullStatus calls, unless a response is already present
+// in the script, and the corresponding applications, units, machines and
+// relations are included in the initial AllWatcher deltas. The status can be
+// either the output of "juju status --format json", a Client.FullStatus
+// response or the whole response frame as recorded by guiproxy.
+func (s *Script) AddStatus(data []byte) error {
+	var frame struct {
+		Response json.RawMessage `json:"response"`
+	}
+	if err := json.Unmarshal(data, &frame); err != nil {
+		return fmt.Errorf("cannot decode status: %s", err)
+	}
+	if len(frame.Response) != 0 {
+		data = frame.Response
+	}
+	var st fullStatus
+	var resp interface{}
+	if isCLIStatus(data) {
+		var cst cliStatus
+		if err := json.Unmarshal(data, &cst); err != nil {
+			return fmt.Errorf("cannot decode status: %s", err)
+		}
+		st = cst.fullStatus()
+		resp = st
+	} else {
+		if err := json.Unmarshal(data, &st); err != nil {
+			return fmt.Errorf("cannot decode status: %s", err)
+		}
+		if err := json.Unmarshal(data, &resp); err != nil {
+			return fmt.Errorf("cannot decode status: %s", err)
+		}
+	}
+	if s.Responses == nil {
+		s.Responses = make(map[string]interface{})
+	}
+	if _, ok := s.Responses["Client.FullStatus"]; !ok {
+		s.Responses["Client.FullStatus"] = resp
+	}
+	s.Watcher.Initial = append(st.deltas(), s.Watcher.Initial...)
+	return nil
+}
+
+// isCLIStatus reports whether the given JSON encoded status has been
+// generated by "juju status", rather than being a FullStatus response.
+func isCLIStatus(data []byte) bool {
+	var st struct {
+		Machines     map[string]map[string]json.RawMessage `json:"machines"`
+		Applications map[string]map[string]json.RawMessage `json:"applications"`
+	}
+	if err := json.Unmarshal(data, &st); err != nil {
+		return false
+	}
+	for _, m := range st.Machines {
+		if _, ok := m["juju-status"]; ok {
+			return true
+		}
+	}
+	for _, app := range st.Applications {
+		if _, ok := app["application-status"]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// fullStatus holds the parts of a Client.FullStatus response used to
+// generate AllWatcher deltas.
+type fullStatus struct {
+	Model        modelStatus                  `json:"model"`
+	Machines     map[string]machineStatus     `json:"machines"`
+	Applications map[string]applicationStatus `json:"applications"`
+	Relations    []relationStatus             `json:"relations"`
+}
+
+type modelStatus struct {
+	Name        string         `json:"name"`
+	CloudTag    string         `json:"cloud-tag,omitempty"`
+	CloudRegion string         `json:"region,omitempty"`
+	Version     string         `json:"version,omitempty"`
+	ModelStatus detailedStatus `json:"model-status"`
+}
+
+type detailedStatus struct {
+	Status  string `json:"status"`
+	Info    string `json:"info"`
+	Since   string `json:"since,omitempty"`
+	Version string `json:"version"`
+	Life    string `json:"life"`
+}
+
+type machineStatus struct {
+	ID             string                   `json:"id"`
+	AgentStatus    detailedStatus           `json:"agent-status"`
+	InstanceStatus detailedStatus           `json:"instance-status"`
+	DNSName        string                   `json:"dns-name"`
+	IPAddresses    []string                 `json:"ip-addresses"`
+	InstanceID     string                   `json:"instance-id"`
+	Series         string                   `json:"series"`
+	Containers     map[string]machineStatus `json:"containers"`
+	Hardware       string                   `json:"hardware"`
+	Jobs           []string                 `json:"jobs"`
+	HasVote        bool                     `json:"has-vote"`
+	WantsVote      bool                     `json:"wants-vote"`
+}
+
+type applicationStatus struct {
+	Charm           string                `json:"charm"`
+	Series          string                `json:"series"`
+	Exposed         bool                  `json:"exposed"`
+	Life            string                `json:"life"`
+	Relations       map[string][]string   `json:"relations"`
+	SubordinateTo   []string              `json:"subordinate-to"`
+	Units           map[string]unitStatus `json:"units"`
+	Status          detailedStatus        `json:"status"`
+	WorkloadVersion string                `json:"workload-version"`
+}
+
+type unitStatus struct {
+	AgentStatus     detailedStatus        `json:"agent-status"`
+	WorkloadStatus  detailedStatus        `json:"workload-status"`
+	WorkloadVersion string                `json:"workload-version"`
+	Machine         string                `json:"machine"`
+	OpenedPorts     []string              `json:"opened-ports"`
+	PublicAddress   string                `json:"public-address"`
+	Charm           string                `json:"charm"`
+	Subordinates    map[string]unitStatus `json:"subordinates"`
+	Leader          bool                  `json:"leader,omitempty"`
+}
+
+type relationStatus struct {
+	ID        int              `json:"id"`
+	Key       string           `json:"key"`
+	Interface string           `json:"interface"`
+	Scope     string           `json:"scope"`
+	Endpoints []endpointStatus `json:"endpoints"`
+}
+
+type endpointStatus struct {
+	ApplicationName string `json:"application"`
+	Name            string `json:"name"`
+	Role            string `json:"role"`
+	Subordinate     bool   `json:"subordinate"`
+}
+
+// deltas returns the AllWatcher deltas describing the status. Deltas refer to
+// the model using the {{model-uuid}} placeholder.
+func (st fullStatus) deltas() []interface{} {
+	var deltas []interface{}
+	for _, id := range sortedKeys(st.Machines) {
+		deltas = append(deltas, machineDeltas(id, st.Machines[id])...)
+	}
+	for _, name := range sortedKeys(st.Applications) {
+		app := st.Applications[name]
+		deltas = append(deltas, delta("application", map[string]interface{}{
+			"name":             name,
+			"exposed":          app.Exposed,
+			"charm-url":        app.Charm,
+			"life":             life(app.Life),
+			"min-units":        0,
+			"constraints":      map[string]interface{}{},
+			"config":           map[string]interface{}{},
+			"subordinate":      len(app.SubordinateTo) != 0,
+			"status":           statusInfo(app.Status),
+			"workload-version": app.WorkloadVersion,
+		}))
+	}
+	for _, rel := range st.Relations {
+		endpoints := make([]interface{}, len(rel.Endpoints))
+		for i, ep := range rel.Endpoints {
+			endpoints[i] = map[string]interface{}{
+				"application-name": ep.ApplicationName,
+				"relation": map[string]interface{}{
+					"name":      ep.Name,
+					"role":      ep.Role,
+					"interface": rel.Interface,
+					"optional":  false,
+					"limit":     0,
+					"scope":     rel.Scope,
+				},
+			}
+		}
+		deltas = append(deltas, delta("relation", map[string]interface{}{
+			"key":       rel.Key,
+			"id":        rel.ID,
+			"endpoints": endpoints,
+		}))
+	}
+	for _, name := range sortedKeys(st.Applications) {
+		app := st.Applications[name]
+		for _, unitName := range sortedKeys(app.Units) {
+			deltas = append(deltas, st.unitDeltas(unitName, app.Units[unitName], false)...)
+		}
+	}
+	return deltas
+}
+
+// machineDeltas returns the deltas for the given machine and its containers.
+func machineDeltas(id string, m machineStatus) []interface{} {
+	addresses := make([]interface{}, 0, len(m.IPAddresses))
+	for _, addr := range m.IPAddresses {
+		typ := "ipv4"
+		if strings.Contains(addr, ":") {
+			typ = "ipv6"
+		}
+		addresses = append(addresses, map[string]interface{}{
+			"value": addr,
+			"type":  typ,
+			"scope": "",
+		})
+	}
+	jobs := m.Jobs
+	if len(jobs) == 0 {
+		jobs = []string{"JobHostUnits"}
+	}
+	deltas := []interface{}{
+		delta("machine", map[string]interface{}{
+			"id":                         id,
+			"instance-id":                m.InstanceID,
+			"agent-status":               statusInfo(m.AgentStatus),
+			"instance-status":            statusInfo(m.InstanceStatus),
+			"life":                       life(m.AgentStatus.Life),
+			"series":                     m.Series,
+			"supported-containers":       []string{},
+			"supported-containers-known": false,
+			"hardware-characteristics":   hardware(m.Hardware),
+			"jobs":                       jobs,
+			"addresses":                  addresses,
+			"has-vote":                   m.HasVote,
+			"wants-vote":                 m.WantsVote,
+		}),
+	}
+	for _, cid := range sortedKeys(m.Containers) {
+		deltas = append(deltas, machineDeltas(cid, m.Containers[cid])...)
+	}
+	return deltas
+}
+
+// unitDeltas returns the deltas for the given unit and for its subordinate
+// units.
+func (st fullStatus) unitDeltas(name string, u unitStatus, subordinate bool) []interface{} {
+	appName := strings.SplitN(name, "/", 2)[0]
+	app := st.Applications[appName]
+	charm := u.Charm
+	if charm == "" {
+		charm = app.Charm
+	}
+	ports := make([]interface{}, 0, len(u.OpenedPorts))
+	portRanges := make([]interface{}, 0, len(u.OpenedPorts))
+	for _, p := range u.OpenedPorts {
+		from, to, protocol, ok := parsePortRange(p)
+		if !ok {
+			continue
+		}
+		if from == to {
+			ports = append(ports, map[string]interface{}{
+				"protocol": protocol,
+				"number":   from,
+			})
+		}
+		portRanges = append(portRanges, map[string]interface{}{
+			"from-port": from,
+			"to-port":   to,
+			"protocol":  protocol,
+		})
+	}
+	deltas := []interface{}{
+		delta("unit", map[string]interface{}{
+			"name":            name,
+			"application":     appName,
+			"series":          app.Series,
+			"charm-url":       charm,
+			"public-address":  u.PublicAddress,
+			"private-address": "",
+			"machine-id":      u.Machine,
+			"ports":           ports,
+			"port-ranges":     portRanges,
+			"subordinate":     subordinate,
+			"workload-status": statusInfo(u.WorkloadStatus),
+			"agent-status":    statusInfo(u.AgentStatus),
+		}),
+	}
+	for _, subName := range sortedKeys(u.Subordinates) {
+		sub := u.Subordinates[subName]
+		sub.Machine = u.Machine
+		deltas = append(deltas, st.unitDeltas(subName, sub, true)...)
+	}
+	return deltas
+}
+
+// delta returns a change delta for the given entity kind and information.
+func delta(kind string, info map[string]interface{}) interface{} {
+	info["model-uuid"] = "{{model-uuid}}"
+	return []interface{}{kind, "change", info}
+}
+
+// statusInfo returns the AllWatcher representation of the given status.
+func statusInfo(st detailedStatus) map[string]interface{} {
+	info := map[string]interface{}{
+		"current": st.Status,
+		"message": st.Info,
+		"version": st.Version,
+	}
+	if st.Since != "" {
+		info["since"] = st.Since
+	}
+	return info
+}
+
+// life returns the given life, defaulting to "alive".
+func life(l string) string {
+	if l == "" {
+		return "alive"
+	}
+	return l
+}
+
+// hardware returns the hardware characteristics described by the given
+// string, for instance "arch=amd64 cores=1 mem=1740M root-disk=8192M".
+func hardware(s string) map[string]interface{} {
+	hw := make(map[string]interface{})
+	for _, field := range strings.Fields(s) {
+		parts := strings.SplitN(field, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		key, value := parts[0], parts[1]
+		switch key {
+		case "cores", "cpu-power":
+			if n, err := strconv.ParseUint(value, 10, 64); err == nil {
+				hw[key] = n
+			}
+		case "mem", "root-disk":
+			if n, ok := megabytes(value); ok {
+				hw[key] = n
+			}
+		default:
+			hw[key] = value
+		}
+	}
+	return hw
+}
+
+// megabytes returns the number of megabytes in the given size, for instance
+// "1740M" or "8G".
+func megabytes(s string) (uint64, bool) {
+	mult := uint64(1)
+	switch {
+	case strings.HasSuffix(s, "M"):
+		s = strings.TrimSuffix(s, "M")
+	case strings.HasSuffix(s, "G"):
+		s, mult = strings.TrimSuffix(s, "G"), 1024
+	case strings.HasSuffix(s, "T"):
+		s, mult = strings.TrimSuffix(s, "T"), 1024*1024
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, false
+	}
+	return uint64(f * float64(mult)), true
+}
+
+// parsePortRange parses port ranges like "80/tcp" or "8000-8010/udp".
+func parsePortRange(s string) (from, to int, protocol string, ok bool) {
+	parts := strings.SplitN(s, "/", 2)
+	if len(parts) != 2 {
+		return 0, 0, "", false
+	}
+	ports := strings.SplitN(parts[0], "-", 2)
+	from, err := strconv.Atoi(ports[0])
+	if err != nil {
+		return 0, 0, "", false
+	}
+	to = from
+	if len(ports) == 2 {
+		if to, err = strconv.Atoi(ports[1]); err != nil {
+			return 0, 0, "", false
+		}
+	}
+	return from, to, parts[1], true
+}
+
+// sortedKeys returns the sorted keys of the given map, which must have string
+// keys.
+func sortedKeys(m interface{}) []string {
+	var keys []string
+	switch m := m.(type) {
+	case map[string]machineStatus:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	case map[string]applicationStatus:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	case map[string]unitStatus:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	case map[string][]string:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	case map[string]cliApplication:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// cliStatus holds the output of "juju status --format json".
+type cliStatus struct {
+	Model struct {
+		Name        string        `json:"name"`
+		Cloud       string        `json:"cloud"`
+		Region      string        `json:"region"`
+		Version     string        `json:"version"`
+		ModelStatus cliStatusInfo `json:"model-status"`
+	} `json:"model"`
+	Machines     map[string]cliMachine     `json:"machines"`
+	Applications map[string]cliApplication `json:"applications"`
+}
+
+type cliStatusInfo struct {
+	Current string `json:"current"`
+	Message string `json:"message"`
+	Since   string `json:"since"`
+	Version string `json:"version"`
+	Life    string `json:"life"`
+}
+
+type cliMachine struct {
+	JujuStatus             cliStatusInfo         `json:"juju-status"`
+	MachineStatus          cliStatusInfo         `json:"machine-status"`
+	DNSName                string                `json:"dns-name"`
+	IPAddresses            []string              `json:"ip-addresses"`
+	InstanceID             string                `json:"instance-id"`
+	Series                 string                `json:"series"`
+	Containers             map[string]cliMachine `json:"containers"`
+	Hardware               string                `json:"hardware"`
+	ControllerMemberStatus string                `json:"controller-member-status"`
+}
+
+type cliApplication struct {
+	Charm             string              `json:"charm"`
+	CharmRev          int                 `json:"charm-rev"`
+	Series            string              `json:"series"`
+	Exposed           bool                `json:"exposed"`
+	Life              string              `json:"life"`
+	ApplicationStatus cliStatusInfo       `json:"application-status"`
+	Relations         map[string][]string `json:"relations"`
+	SubordinateTo     []string            `json:"subordinate-to"`
+	Units             map[string]cliUnit  `json:"units"`
+	Version           string              `json:"version"`
+}
+
+type cliUnit struct {
+	WorkloadStatus cliStatusInfo      `json:"workload-status"`
+	JujuStatus     cliStatusInfo      `json:"juju-status"`
+	Leader         bool               `json:"leader"`
+	Machine        string             `json:"machine"`
+	OpenPorts      []string           `json:"open-ports"`
+	PublicAddress  string             `json:"public-address"`
+	Subordinates   map[string]cliUnit `json:"subordinates"`
+}
+
+// fullStatus returns the FullStatus response corresponding to the CLI status.
+// Relation interfaces and roles are not included in the CLI status: in the
+// resulting relations, interfaces are left empty, and endpoints of non-peer
+// relations are assumed to be requirer and provider, in alphabetical order.
+func (cst cliStatus) fullStatus() fullStatus {
+	st := fullStatus{
+		Model: modelStatus{
+			Name:        cst.Model.Name,
+			CloudTag:    "cloud-" + cst.Model.Cloud,
+			CloudRegion: cst.Model.Region,
+			Version:     cst.Model.Version,
+			ModelStatus: cst.Model.ModelStatus.detailedStatus(),
+		},
+		Machines:     make(map[string]machineStatus, len(cst.Machines)),
+		Applications: make(map[string]applicationStatus, len(cst.Applications)),
+		Relations:    []relationStatus{},
+	}
+	for id, m := range cst.Machines {
+		st.Machines[id] = m.machineStatus(id)
+	}
+	for name, app := range cst.Applications {
+		charm := app.Charm
+		if charm != "" && !strings.Contains(charm, ":") {
+			charm = "cs:" + charm
+			if app.CharmRev != 0 {
+				charm += "-" + strconv.Itoa(app.CharmRev)
+			}
+		}
+		units := make(map[string]unitStatus, len(app.Units))
+		for unitName, u := range app.Units {
+			units[unitName] = u.unitStatus(charm)
+		}
+		status := app.ApplicationStatus.detailedStatus()
+		status.Life = app.Life
+		st.Applications[name] = applicationStatus{
+			Charm:           charm,
+			Series:          app.Series,
+			Exposed:         app.Exposed,
+			Life:            app.Life,
+			Relations:       app.Relations,
+			SubordinateTo:   app.SubordinateTo,
+			Units:           units,
+			Status:          status,
+			WorkloadVersion: app.Version,
+		}
+	}
+	st.Relations = cst.relations()
+	return st
+}
+
+// relations returns the relations between applications in the CLI status.
+func (cst cliStatus) relations() []relationStatus {
+	seen := make(map[string]bool)
+	rels := []relationStatus{}
+	for _, name := range sortedKeys(cst.Applications) {
+		app := cst.Applications[name]
+		for _, endpoint := range sortedKeys(app.Relations) {
+			for _, other := range app.Relations[endpoint] {
+				var rel relationStatus
+				if other == name {
+					rel = relationStatus{
+						Key:   name + ":" + endpoint,
+						Scope: "global",
+						Endpoints: []endpointStatus{{
+							ApplicationName: name,
+							Name:            endpoint,
+							Role:            "peer",
+						}},
+					}
+				} else {
+					otherEndpoint := cst.endpoint(other, name)
+					if otherEndpoint == "" {
+						continue
+					}
+					eps := []endpointStatus{{
+						ApplicationName: name,
+						Name:            endpoint,
+						Subordinate:     len(app.SubordinateTo) != 0,
+					}, {
+						ApplicationName: other,
+						Name:            otherEndpoint,
+						Subordinate:     len(cst.Applications[other].SubordinateTo) != 0,
+					}}
+					sort.Slice(eps, func(i, j int) bool {
+						return eps[i].ApplicationName < eps[j].ApplicationName
+					})
+					eps[0].Role, eps[1].Role = "requirer", "provider"
+					scope := "global"
+					if eps[0].Subordinate || eps[1].Subordinate {
+						scope = "container"
+					}
+					rel = relationStatus{
+						Key:       eps[0].ApplicationName + ":" + eps[0].Name + " " + eps[1].ApplicationName + ":" + eps[1].Name,
+						Scope:     scope,
+						Endpoints: eps,
+					}
+				}
+				if seen[rel.Key] {
+					continue
+				}
+				seen[rel.Key] = true
+				rel.ID = len(rels)
+				rels = append(rels, rel)
+			}
+		}
+	}
+	return rels
+}
+
+// endpoint returns the endpoint of the given application related to the other
+// application, or an empty string if not found.
+func (cst cliStatus) endpoint(name, other string) string {
+	app := cst.Applications[name]
+	for _, endpoint := range sortedKeys(app.Relations) {
+		for _, related := range app.Relations[endpoint] {
+			if related == other {
+				return endpoint
+			}
+		}
+	}
+	return ""
+}
+
+func (m cliMachine) machineStatus(id string) machineStatus {
+	containers := make(map[string]machineStatus, len(m.Containers))
+	for cid, c := range m.Containers {
+		containers[cid] = c.machineStatus(cid)
+	}
+	jobs := []string{"JobHostUnits"}
+	if m.ControllerMemberStatus != "" {
+		jobs = append(jobs, "JobManageModel")
+	}
+	return machineStatus{
+		ID:             id,
+		AgentStatus:    m.JujuStatus.detailedStatus(),
+		InstanceStatus: m.MachineStatus.detailedStatus(),
+		DNSName:        m.DNSName,
+		IPAddresses:    m.IPAddresses,
+		InstanceID:     m.InstanceID,
+		Series:         m.Series,
+		Containers:     containers,
+		Hardware:       m.Hardware,
+		Jobs:           jobs,
+		HasVote:        m.ControllerMemberStatus == "has-vote",
+		WantsVote:      m.ControllerMemberStatus != "",
+	}
+}
+
+func (u cliUnit) unitStatus(charm string) unitStatus {
+	subordinates := make(map[string]unitStatus, len(u.Subordinates))
+	for name, sub := range u.Subordinates {
+		subordinates[name] = sub.unitStatus("")
+	}
+	return unitStatus{
+		AgentStatus:    u.JujuStatus.detailedStatus(),
+		WorkloadStatus: u.WorkloadStatus.detailedStatus(),
+		Machine:        u.Machine,
+		OpenedPorts:    u.OpenPorts,
+		PublicAddress:  u.PublicAddress,
+		Charm:          charm,
+		Subordinates:   subordinates,
+		Leader:         u.Leader,
+	}
+}
+
+// cliTimeLayout holds the layout of times in the CLI status.
+const cliTimeLayout = "02 Jan 2006 15:04:05Z07:00"
+
+func (s cliStatusInfo) detailedStatus() detailedStatus {
+	since := ""
+	if t, err := time.Parse(cliTimeLayout, s.Since); err == nil {
+		since = t.Format(time.RFC3339)
+	} else if _, err := time.Parse(time.RFC3339, s.Since); err == nil {
+		since = s.Since
+	}
+	return detailedStatus{
+		Status:  s.Current,
+		Info:    s.Message,
+		Since:   since,
+		Version: s.Version,
+		Life:    s.Life,
+	}
+}
@@ -0,0 +1,260 @@
+package mock_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/juju/guiproxy/internal/mock"
+)
+
+const cliStatus = `{
+	"model": {"name": "default", "cloud": "aws", "region": "us-east-1", "version": "2.3.1", "model-status": {"current": "available"}},
+	"machines": {
+		"0": {
+			"juju-status": {"current": "started", "since": "18 Jan 2018 10:00:00Z", "version": "2.3.1"},
+			"machine-status": {"current": "running", "message": "running"},
+			"dns-name": "10.0.0.1",
+			"ip-addresses": ["10.0.0.1"],
+			"instance-id": "i-0",
+			"series": "xenial",
+			"hardware": "arch=amd64 cores=2 mem=4G root-disk=8192M"
+		}
+	},
+	"applications": {
+		"mysql": {
+			"charm": "cs:mysql-58",
+			"series": "xenial",
+			"application-status": {"current": "active"},
+			"relations": {"cluster": ["mysql"], "db": ["wordpress"], "juju-info": ["telegraf"]},
+			"units": {
+				"mysql/0": {
+					"workload-status": {"current": "error", "message": "hook failed: \"install\""},
+					"juju-status": {"current": "idle"},
+					"machine": "0",
+					"open-ports": ["3306/tcp"],
+					"public-address": "10.0.0.1",
+					"subordinates": {
+						"telegraf/0": {
+							"workload-status": {"current": "active"},
+							"juju-status": {"current": "idle"}
+						}
+					}
+				}
+			}
+		},
+		"telegraf": {
+			"charm": "telegraf",
+			"charm-rev": 7,
+			"series": "xenial",
+			"application-status": {"current": "active"},
+			"relations": {"juju-info": ["mysql"]},
+			"subordinate-to": ["mysql"]
+		},
+		"wordpress": {
+			"charm": "cs:wordpress-5",
+			"series": "trusty",
+			"exposed": true,
+			"application-status": {"current": "waiting"},
+			"relations": {"db": ["mysql"]}
+		}
+	}
+}`
+
+func TestAddStatusFromCLI(t *testing.T) {
+	c := qt.New(t)
+	s := &mock.Script{}
+	err := s.AddStatus([]byte(cliStatus))
+	c.Assert(err, qt.Equals, nil)
+
+	var status struct {
+		Model struct {
+			CloudTag string `json:"cloud-tag"`
+		} `json:"model"`
+		Applications map[string]struct {
+			Charm string `json:"charm"`
+		} `json:"applications"`
+		Relations []struct {
+			Key string `json:"key"`
+		} `json:"relations"`
+	}
+	decode(c, s.Responses["Client.FullStatus"], &status)
+	c.Assert(status.Model.CloudTag, qt.Equals, "cloud-aws")
+	c.Assert(status.Applications["telegraf"].Charm, qt.Equals, "cs:telegraf-7")
+	c.Assert(status.Relations, qt.HasLen, 3)
+	c.Assert(status.Relations[0].Key, qt.Equals, "mysql:cluster")
+	c.Assert(status.Relations[1].Key, qt.Equals, "mysql:db wordpress:db")
+	c.Assert(status.Relations[2].Key, qt.Equals, "mysql:juju-info telegraf:juju-info")
+
+	var deltas [][]interface{}
+	decode(c, s.Watcher.Initial, &deltas)
+	kinds := make([]string, len(deltas))
+	for i, d := range deltas {
+		kinds[i] = d[0].(string) + " " + d[1].(string)
+	}
+	c.Assert(kinds, qt.DeepEquals, []string{
+		"machine change",
+		"application change",
+		"application change",
+		"application change",
+		"relation change",
+		"relation change",
+		"relation change",
+		"unit change",
+		"unit change",
+	})
+	c.Assert(deltas[0][2], qt.DeepEquals, map[string]interface{}{
+		"model-uuid":  "{{model-uuid}}",
+		"id":          "0",
+		"instance-id": "i-0",
+		"agent-status": map[string]interface{}{
+			"current": "started",
+			"message": "",
+			"since":   "2018-01-18T10:00:00Z",
+			"version": "2.3.1",
+		},
+		"instance-status": map[string]interface{}{
+			"current": "running",
+			"message": "running",
+			"version": "",
+		},
+		"life":                       "alive",
+		"series":                     "xenial",
+		"supported-containers":       []interface{}{},
+		"supported-containers-known": false,
+		"hardware-characteristics": map[string]interface{}{
+			"arch":      "amd64",
+			"cores":     2.0,
+			"mem":       4096.0,
+			"root-disk": 8192.0,
+		},
+		"jobs": []interface{}{"JobHostUnits"},
+		"addresses": []interface{}{
+			map[string]interface{}{"value": "10.0.0.1", "type": "ipv4", "scope": ""},
+		},
+		"has-vote":   false,
+		"wants-vote": false,
+	})
+	c.Assert(deltas[7][2], qt.DeepEquals, map[string]interface{}{
+		"model-uuid":      "{{model-uuid}}",
+		"name":            "mysql/0",
+		"application":     "mysql",
+		"series":          "xenial",
+		"charm-url":       "cs:mysql-58",
+		"public-address":  "10.0.0.1",
+		"private-address": "",
+		"machine-id":      "0",
+		"ports": []interface{}{
+			map[string]interface{}{"protocol": "tcp", "number": 3306.0},
+		},
+		"port-ranges": []interface{}{
+			map[string]interface{}{"from-port": 3306.0, "to-port": 3306.0, "protocol": "tcp"},
+		},
+		"subordinate": false,
+		"workload-status": map[string]interface{}{
+			"current": "error",
+			"message": `hook failed: "install"`,
+			"version": "",
+		},
+		"agent-status": map[string]interface{}{
+			"current": "idle",
+			"message": "",
+			"version": "",
+		},
+	})
+	sub := deltas[8][2].(map[string]interface{})
+	c.Assert(sub["name"], qt.Equals, "telegraf/0")
+	c.Assert(sub["charm-url"], qt.Equals, "cs:telegraf-7")
+	c.Assert(sub["machine-id"], qt.Equals, "0")
+	c.Assert(sub["subordinate"], qt.Equals, true)
+}
+
+func TestAddStatusFromFullStatus(t *testing.T) {
+	c := qt.New(t)
+	s := &mock.Script{
+		Watcher: mock.Watcher{
+			Initial: []interface{}{"existing"},
+		},
+	}
+	// Recorded response frames are accepted.
+	err := s.AddStatus([]byte(`{"request-id": 42, "response": {
+		"model": {"name": "default"},
+		"machines": {},
+		"applications": {
+			"django": {
+				"charm": "cs:django-1",
+				"status": {"status": "active", "info": "ready"},
+				"units": {"django/0": {"workload-status": {"status": "active"}}},
+				"can-upgrade-to": "cs:django-2"
+			}
+		},
+		"relations": []
+	}}`))
+	c.Assert(err, qt.Equals, nil)
+
+	// The response is served verbatim.
+	var status map[string]interface{}
+	decode(c, s.Responses["Client.FullStatus"], &status)
+	c.Assert(status["applications"].(map[string]interface{})["django"].(map[string]interface{})["can-upgrade-to"], qt.Equals, "cs:django-2")
+
+	var deltas []interface{}
+	decode(c, s.Watcher.Initial, &deltas)
+	c.Assert(deltas, qt.HasLen, 3)
+	app := deltas[0].([]interface{})[2].(map[string]interface{})
+	c.Assert(app["name"], qt.Equals, "django")
+	c.Assert(app["status"], qt.DeepEquals, map[string]interface{}{
+		"current": "active",
+		"message": "ready",
+		"version": "",
+	})
+	unit := deltas[1].([]interface{})[2].(map[string]interface{})
+	c.Assert(unit["name"], qt.Equals, "django/0")
+	c.Assert(unit["charm-url"], qt.Equals, "cs:django-1")
+	c.Assert(deltas[2], qt.Equals, "existing")
+}
+
+func TestAddStatusPreservesResponse(t *testing.T) {
+	c := qt.New(t)
+	s := &mock.Script{
+		Responses: map[string]interface{}{
+			"Client.FullStatus": "scripted",
+		},
+	}
+	err := s.AddStatus([]byte(cliStatus))
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(s.Responses["Client.FullStatus"], qt.Equals, "scripted")
+	c.Assert(s.Watcher.Initial, qt.HasLen, 9)
+}
+
+func TestReadStatus(t *testing.T) {
+	c := qt.New(t)
+	defer c.Cleanup()
+	dir := c.Mkdir()
+	path := filepath.Join(dir, "status.json")
+	err := ioutil.WriteFile(path, []byte(cliStatus), 0600)
+	c.Assert(err, qt.Equals, nil)
+	s := &mock.Script{}
+	err = s.ReadStatus(path)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(s.Watcher.Initial, qt.HasLen, 9)
+
+	err = s.ReadStatus(filepath.Join(dir, "missing.json"))
+	c.Assert(err, qt.ErrorMatches, "cannot read status: .*")
+
+	path = filepath.Join(dir, "invalid.json")
+	err = ioutil.WriteFile(path, []byte("bad wolf"), 0600)
+	c.Assert(err, qt.Equals, nil)
+	err = s.ReadStatus(path)
+	c.Assert(err, qt.ErrorMatches, `cannot use status ".*": cannot decode status: .*`)
+}
+
+// decode decodes the JSON representation of the given value into v.
+func decode(c *qt.C, value, v interface{}) {
+	data, err := json.Marshal(value)
+	c.Assert(err, qt.Equals, nil)
+	err = json.Unmarshal(data, v)
+	c.Assert(err, qt.Equals, nil)
+}
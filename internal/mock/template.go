@@ -0,0 +1,69 @@
+package mock
+
+import (
+	"crypto/rand"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// templateValues holds the values used to expand placeholders in responses.
+type templateValues struct {
+	requestID uint64
+	modelUUID string
+}
+
+// expand returns a copy of the given decoded JSON value with placeholders in
+// strings replaced. A string only including the {{request-id}} placeholder
+// is replaced with the numeric request id.
+func (t templateValues) expand(v interface{}) interface{} {
+	switch v := v.(type) {
+	case string:
+		if v == "{{request-id}}" {
+			return t.requestID
+		}
+		return t.expandString(v)
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			m[key] = t.expand(value)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(v))
+		for i, value := range v {
+			l[i] = t.expand(value)
+		}
+		return l
+	}
+	return v
+}
+
+// expandString replaces placeholders in the given string.
+func (t templateValues) expandString(s string) string {
+	if !strings.Contains(s, "{{") {
+		return s
+	}
+	s = strings.Replace(s, "{{request-id}}", fmt.Sprint(t.requestID), -1)
+	s = strings.Replace(s, "{{model-uuid}}", t.modelUUID, -1)
+	s = strings.Replace(s, "{{now}}", timeNow().UTC().Format(time.RFC3339), -1)
+	for strings.Contains(s, "{{uuid}}") {
+		s = strings.Replace(s, "{{uuid}}", newUUID(), 1)
+	}
+	return s
+}
+
+// timeNow is defined as a variable for testing purposes.
+var timeNow = time.Now
+
+// newUUID returns a new random (version 4) UUID.
+func newUUID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		// This should never happen.
+		panic(err)
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
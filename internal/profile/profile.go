@@ -0,0 +1,192 @@
+// Package profile implements named sets of guiproxy options, stored in a YAML
+// configuration file so that they can be shared and versioned.
+package profile
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/frankban/flagutils"
+	"gopkg.in/yaml.v2"
+
+	"github.com/juju/guiproxy/internal/yamljson"
+)
+
+// Profile holds option values keyed by flag name, in the form they would be
+// provided on the command line.
+type Profile map[string]string
+
+// Apply sets the flags in the given flag set with the values in the profile,
+// skipping flags which have already been set, for instance on the command
+// line. Empty values for list flags are skipped as well, so that an empty
+// list in the profile leaves the flag unset.
+func (p Profile) Apply(fs *flag.FlagSet) error {
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	for _, name := range p.Names() {
+		f := fs.Lookup(name)
+		if f == nil {
+			return fmt.Errorf("unknown option %q", name)
+		}
+		if set[name] {
+			continue
+		}
+		if _, ok := f.Value.(*flagutils.StringSlice); ok && p[name] == "" {
+			continue
+		}
+		if err := fs.Set(name, p[name]); err != nil {
+			return fmt.Errorf("invalid value %q for option %q: %s", p[name], name, err)
+		}
+	}
+	return nil
+}
+
+// Names returns the names of the options in the profile, sorted.
+func (p Profile) Names() []string {
+	names := make([]string, 0, len(p))
+	for name := range p {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Environ returns a profile holding the values of the environment variables
+// corresponding to the flags in the given flag set, as returned by EnvName.
+// Empty variables are ignored.
+func Environ(fs *flag.FlagSet, prefix string) Profile {
+	p := make(Profile)
+	fs.VisitAll(func(f *flag.Flag) {
+		if v := os.Getenv(EnvName(prefix, f.Name)); v != "" {
+			p[f.Name] = v
+		}
+	})
+	return p
+}
+
+// EnvName returns the name of the environment variable corresponding to the
+// flag with the given name, for instance "GUIPROXY_WS_BUFFER_SIZE" for the
+// "ws-buffer-size" flag when the prefix is "GUIPROXY_".
+func EnvName(prefix, name string) string {
+	return prefix + strings.ToUpper(strings.Replace(name, "-", "_", -1))
+}
+
+// DefaultPath returns the path to the configuration file in the user's
+// configuration directory, for instance "~/.config/guiproxy/config.yaml".
+func DefaultPath() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		dir = filepath.Join(os.Getenv("HOME"), ".config")
+	}
+	return filepath.Join(dir, "guiproxy", "config.yaml")
+}
+
+// Read reads and returns the profiles defined in the configuration file at
+// the given path.
+func Read(path string) (map[string]Profile, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read profiles: %s", err)
+	}
+	profiles, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse profiles in %q: %s", path, err)
+	}
+	return profiles, nil
+}
+
+// Get returns the profile with the given name, as defined in the configuration
+// file at the given path.
+func Get(path, name string) (Profile, error) {
+	profiles, err := Read(path)
+	if err != nil {
+		return nil, err
+	}
+	p, ok := profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("profile %q not found in %q", name, path)
+	}
+	return p, nil
+}
+
+// Parse parses and returns the profiles defined in the given YAML document,
+// for instance:
+//
+//	profiles:
+//	  jaas:
+//	    env: prod
+//	    flags: [profile, status]
+//	    config:
+//	      gisf: true
+//
+// Lists are converted to comma separated values, and objects to JSON.
+func Parse(data []byte) (map[string]Profile, error) {
+	var doc struct {
+		Profiles map[string]map[string]interface{} `yaml:"profiles"`
+	}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	profiles := make(map[string]Profile, len(doc.Profiles))
+	for name, options := range doc.Profiles {
+		p := make(Profile, len(options))
+		for key, value := range options {
+			v, err := optionValue(value)
+			if err != nil {
+				return nil, fmt.Errorf("profile %q: invalid value for option %q: %s", name, key, err)
+			}
+			p[key] = v
+		}
+		profiles[name] = p
+	}
+	return profiles, nil
+}
+
+// optionValue returns the command line representation of the given decoded
+// YAML value.
+func optionValue(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case nil:
+		return "", nil
+	case []interface{}:
+		items := make([]string, len(v))
+		for i, item := range v {
+			switch item.(type) {
+			case []interface{}, map[interface{}]interface{}:
+				return "", fmt.Errorf("list items must be scalars")
+			}
+			items[i] = scalarValue(item)
+		}
+		return strings.Join(items, ","), nil
+	case map[interface{}]interface{}:
+		value, err := yamljson.Value(v)
+		if err != nil {
+			return "", err
+		}
+		data, err := json.Marshal(value)
+		if err != nil {
+			return "", err
+		}
+		return string(data), nil
+	}
+	return scalarValue(v), nil
+}
+
+// scalarValue returns the command line representation of the given decoded
+// YAML scalar. Numbers are never formatted using exponents, so that, for
+// instance, 1e6 is provided as "1000000".
+func scalarValue(v interface{}) string {
+	if f, ok := v.(float64); ok {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,205 @@
+package profile_test
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/frankban/flagutils"
+	qt "github.com/frankban/quicktest"
+
+	"github.com/juju/guiproxy/internal/profile"
+)
+
+const profiles = `
+profiles:
+  jaas:
+    env: prod
+    port: 8080
+    compress: true
+    flags: [profile, status]
+    ws-read-limit: 1e6
+    config:
+      gisf: true
+      flags:
+        exterminate: true
+  local:
+    gui: localhost:6543
+    ping-interval: 10s
+    shell:
+    allow: []
+    ratio: [0.5, 1.5e3]
+`
+
+func TestParse(t *testing.T) {
+	c := qt.New(t)
+	p, err := profile.Parse([]byte(profiles))
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(p, qt.DeepEquals, map[string]profile.Profile{
+		"jaas": {
+			"env":           "prod",
+			"port":          "8080",
+			"compress":      "true",
+			"flags":         "profile,status",
+			"ws-read-limit": "1000000",
+			"config":        `{"flags":{"exterminate":true},"gisf":true}`,
+		},
+		"local": {
+			"gui":           "localhost:6543",
+			"ping-interval": "10s",
+			"shell":         "",
+			"allow":         "",
+			"ratio":         "0.5,1500",
+		},
+	})
+}
+
+var parseErrorTests = []struct {
+	about         string
+	data          string
+	expectedError string
+}{{
+	about:         "invalid YAML",
+	data:          "profiles: [",
+	expectedError: "yaml: .*",
+}, {
+	about:         "invalid profiles",
+	data:          "profiles: [a, b]",
+	expectedError: "(?s)yaml: unmarshal errors:.*",
+}, {
+	about: "nested lists",
+	data: `
+profiles:
+  jaas:
+    flags: [[a, b]]`,
+	expectedError: `profile "jaas": invalid value for option "flags": list items must be scalars`,
+}, {
+	about: "invalid key",
+	data: `
+profiles:
+  jaas:
+    config: {1: true}`,
+	expectedError: `profile "jaas": invalid value for option "config": invalid key 1: keys must be strings`,
+}}
+
+func TestParseError(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range parseErrorTests {
+		c.Run(test.about, func(c *qt.C) {
+			p, err := profile.Parse([]byte(test.data))
+			c.Assert(err, qt.ErrorMatches, test.expectedError)
+			c.Assert(p, qt.IsNil)
+		})
+	}
+}
+
+func TestGet(t *testing.T) {
+	c := qt.New(t)
+	path := filepath.Join(c.Mkdir(), "config.yaml")
+	err := ioutil.WriteFile(path, []byte(profiles), 0600)
+	c.Assert(err, qt.Equals, nil)
+
+	p, err := profile.Get(path, "local")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(p.Names(), qt.DeepEquals, []string{"allow", "gui", "ping-interval", "ratio", "shell"})
+
+	p, err = profile.Get(path, "no-such")
+	c.Assert(err, qt.ErrorMatches, `profile "no-such" not found in ".*config.yaml"`)
+	c.Assert(p, qt.IsNil)
+
+	p, err = profile.Get(path+".missing", "local")
+	c.Assert(err, qt.ErrorMatches, "cannot read profiles: .*")
+	c.Assert(p, qt.IsNil)
+}
+
+func TestDefaultPath(t *testing.T) {
+	c := qt.New(t)
+	defer c.Cleanup()
+	c.Setenv("HOME", "/home/who")
+	c.Setenv("XDG_CONFIG_HOME", "")
+	c.Assert(profile.DefaultPath(), qt.Equals, "/home/who/.config/guiproxy/config.yaml")
+	c.Setenv("XDG_CONFIG_HOME", "/etc/xdg")
+	c.Assert(profile.DefaultPath(), qt.Equals, "/etc/xdg/guiproxy/config.yaml")
+}
+
+func TestApply(t *testing.T) {
+	c := qt.New(t)
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	port := fs.Int("port", 8042, "")
+	env := fs.String("env", "", "")
+	interval := fs.Duration("ping-interval", 0, "")
+	var allow, flags flagutils.StringSlice
+	fs.Var(&allow, "allow", "")
+	fs.Var(&flags, "flags", "")
+	err := fs.Parse([]string{"-env", "qa"})
+	c.Assert(err, qt.Equals, nil)
+
+	err = profile.Profile{
+		"port":          "8080",
+		"env":           "prod",
+		"ping-interval": "10s",
+		"allow":         "",
+		"flags":         "profile,status",
+	}.Apply(fs)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(*port, qt.Equals, 8080)
+	// Options provided on the command line take precedence.
+	c.Assert(*env, qt.Equals, "qa")
+	c.Assert(*interval, qt.Equals, 10*time.Second)
+	// Empty lists leave list options unset.
+	c.Assert(allow, qt.HasLen, 0)
+	c.Assert(flags, qt.DeepEquals, flagutils.StringSlice{"profile", "status"})
+}
+
+func TestApplyError(t *testing.T) {
+	c := qt.New(t)
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.Int("port", 8042, "")
+
+	err := profile.Profile{"no-such": "42"}.Apply(fs)
+	c.Assert(err, qt.ErrorMatches, `unknown option "no-such"`)
+
+	err = profile.Profile{"port": "bad wolf"}.Apply(fs)
+	c.Assert(err, qt.ErrorMatches, `invalid value "bad wolf" for option "port": .*`)
+}
+
+func TestEnvName(t *testing.T) {
+	c := qt.New(t)
+	c.Assert(profile.EnvName("GUIPROXY_", "port"), qt.Equals, "GUIPROXY_PORT")
+	c.Assert(profile.EnvName("GUIPROXY_", "ws-buffer-size"), qt.Equals, "GUIPROXY_WS_BUFFER_SIZE")
+	c.Assert(profile.EnvName("GUIPROXY_", "juju1"), qt.Equals, "GUIPROXY_JUJU1")
+}
+
+func TestEnviron(t *testing.T) {
+	c := qt.New(t)
+	defer c.Cleanup()
+	c.Setenv("TEST_PORT", "8080")
+	c.Setenv("TEST_CONFIG", `"gisf": true`)
+	c.Setenv("TEST_FLAGS", "profile,status")
+	c.Setenv("TEST_PING_INTERVAL", "")
+	c.Setenv("TEST_NO_SUCH", "42")
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	port := fs.Int("port", 8042, "")
+	var config flagutils.StringMap
+	fs.Var(&config, "config", "")
+	var flags flagutils.StringSlice
+	fs.Var(&flags, "flags", "")
+	fs.Duration("ping-interval", 0, "")
+	err := fs.Parse([]string{"-port", "9000"})
+	c.Assert(err, qt.Equals, nil)
+
+	p := profile.Environ(fs, "TEST_")
+	c.Assert(p, qt.DeepEquals, profile.Profile{
+		"port":   "8080",
+		"config": `"gisf": true`,
+		"flags":  "profile,status",
+	})
+	err = p.Apply(fs)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(*port, qt.Equals, 9000)
+	c.Assert(config, qt.DeepEquals, flagutils.StringMap{"gisf": true})
+	c.Assert(flags, qt.DeepEquals, flagutils.StringSlice{"profile", "status"})
+}
@@ -0,0 +1,39 @@
+// Package yamljson converts decoded YAML documents to values that can be
+// encoded as JSON.
+package yamljson
+
+import "fmt"
+
+// Value returns the given decoded YAML value with maps converted to JSON
+// objects, so that the result can be encoded using encoding/json. An error is
+// returned if a map includes keys that are not strings. The given value is not
+// modified.
+func Value(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			k, ok := key.(string)
+			if !ok {
+				return nil, fmt.Errorf("invalid key %v: keys must be strings", key)
+			}
+			value, err := Value(value)
+			if err != nil {
+				return nil, err
+			}
+			m[k] = value
+		}
+		return m, nil
+	case []interface{}:
+		l := make([]interface{}, len(v))
+		for i, value := range v {
+			value, err := Value(value)
+			if err != nil {
+				return nil, err
+			}
+			l[i] = value
+		}
+		return l, nil
+	}
+	return v, nil
+}
@@ -0,0 +1,41 @@
+package yamljson_test
+
+import (
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"gopkg.in/yaml.v2"
+
+	"github.com/juju/guiproxy/internal/yamljson"
+)
+
+func TestValue(t *testing.T) {
+	c := qt.New(t)
+	var v interface{}
+	err := yaml.Unmarshal([]byte(`
+name: guiproxy
+options:
+  port: 8042
+  allow: [a, {b: c}]
+`), &v)
+	c.Assert(err, qt.Equals, nil)
+	value, err := yamljson.Value(v)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(value, qt.DeepEquals, map[string]interface{}{
+		"name": "guiproxy",
+		"options": map[string]interface{}{
+			"port":  8042,
+			"allow": []interface{}{"a", map[string]interface{}{"b": "c"}},
+		},
+	})
+}
+
+func TestValueInvalidKey(t *testing.T) {
+	c := qt.New(t)
+	var v interface{}
+	err := yaml.Unmarshal([]byte("list: [{42: answer}]"), &v)
+	c.Assert(err, qt.Equals, nil)
+	value, err := yamljson.Value(v)
+	c.Assert(err, qt.ErrorMatches, "invalid key 42: keys must be strings")
+	c.Assert(value, qt.IsNil)
+}
@@ -0,0 +1,230 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// JSONStyle holds the functions used to colorize the different parts of JSON
+// messages. Nil functions leave the corresponding parts unchanged.
+type JSONStyle struct {
+	// Key is used to colorize object keys.
+	Key func(string) string
+	// String is used to colorize string values.
+	String func(string) string
+	// Number is used to colorize number values.
+	Number func(string) string
+	// Literal is used to colorize booleans and null values.
+	Literal func(string) string
+	// Error is used to colorize values stored in "error" keys.
+	Error func(string) string
+}
+
+// PrettyJSON returns an apiLogger message modifier that indents JSON messages
+// and colorizes them using the given style. Strings and arrays longer than the
+// given limit are truncated: a zero limit disables truncation. Messages that
+// are not valid JSON are returned unchanged.
+func PrettyJSON(style JSONStyle, limit int) func(string) string {
+	return func(msg string) string {
+		dec := json.NewDecoder(strings.NewReader(msg))
+		dec.UseNumber()
+		p := &jsonPrinter{
+			dec:   dec,
+			style: style,
+			limit: limit,
+		}
+		if err := p.value(0, false); err != nil {
+			return msg
+		}
+		// Ensure the message only includes a single JSON value.
+		if _, err := dec.Token(); err == nil {
+			return msg
+		}
+		return p.buf.String()
+	}
+}
+
+// jsonIndent holds the string used to indent JSON messages.
+const jsonIndent = "  "
+
+// jsonPrinter pretty prints JSON values read from a decoder.
+type jsonPrinter struct {
+	dec   *json.Decoder
+	style JSONStyle
+	limit int
+	buf   bytes.Buffer
+}
+
+// value prints the next JSON value at the given indentation depth. The isErr
+// argument reports whether the value is stored in an error key.
+func (p *jsonPrinter) value(depth int, isErr bool) error {
+	tok, err := p.dec.Token()
+	if err != nil {
+		return err
+	}
+	switch v := tok.(type) {
+	case json.Delim:
+		if v == '{' {
+			return p.object(depth, isErr)
+		}
+		if v == '[' {
+			return p.array(depth, isErr)
+		}
+		return fmt.Errorf("unexpected delimiter %q", v)
+	case string:
+		f := p.style.String
+		if isErr {
+			f = p.style.Error
+		}
+		p.write(f, quote(p.truncate(v)))
+	case json.Number:
+		f := p.style.Number
+		if isErr {
+			f = p.style.Error
+		}
+		p.write(f, v.String())
+	default:
+		p.write(p.style.Literal, fmt.Sprint(jsonLiteral(v)))
+	}
+	return nil
+}
+
+// object prints a JSON object whose opening delimiter has been already read.
+func (p *jsonPrinter) object(depth int, isErr bool) error {
+	p.buf.WriteByte('{')
+	n := 0
+	for p.dec.More() {
+		tok, err := p.dec.Token()
+		if err != nil {
+			return err
+		}
+		key, ok := tok.(string)
+		if !ok {
+			return fmt.Errorf("unexpected object key %v", tok)
+		}
+		if n > 0 {
+			p.buf.WriteByte(',')
+		}
+		p.newline(depth + 1)
+		p.write(p.style.Key, quote(key))
+		p.buf.WriteString(": ")
+		if err := p.value(depth+1, isErr || key == "error"); err != nil {
+			return err
+		}
+		n++
+	}
+	return p.close('}', depth, n)
+}
+
+// array prints a JSON array whose opening delimiter has been already read.
+func (p *jsonPrinter) array(depth int, isErr bool) error {
+	p.buf.WriteByte('[')
+	n, skipped := 0, 0
+	for p.dec.More() {
+		if p.limit > 0 && n == p.limit {
+			if err := p.skip(); err != nil {
+				return err
+			}
+			skipped++
+			continue
+		}
+		if n > 0 {
+			p.buf.WriteByte(',')
+		}
+		p.newline(depth + 1)
+		if err := p.value(depth+1, isErr); err != nil {
+			return err
+		}
+		n++
+	}
+	if skipped > 0 {
+		p.buf.WriteByte(',')
+		p.newline(depth + 1)
+		p.buf.WriteString(fmt.Sprintf("... %d more", skipped))
+	}
+	return p.close(']', depth, n)
+}
+
+// close reads the closing delimiter of an object or array with n items, and
+// prints it at the given depth.
+func (p *jsonPrinter) close(delim json.Delim, depth, n int) error {
+	if _, err := p.dec.Token(); err != nil {
+		return err
+	}
+	if n > 0 {
+		p.newline(depth)
+	}
+	p.buf.WriteString(delim.String())
+	return nil
+}
+
+// skip reads and discards the next JSON value.
+func (p *jsonPrinter) skip() error {
+	depth := 0
+	for {
+		tok, err := p.dec.Token()
+		if err != nil {
+			return err
+		}
+		if d, ok := tok.(json.Delim); ok {
+			if d == '{' || d == '[' {
+				depth++
+			} else {
+				depth--
+			}
+		}
+		if depth == 0 {
+			return nil
+		}
+	}
+}
+
+// truncate truncates the given string if it is longer than the limit.
+func (p *jsonPrinter) truncate(s string) string {
+	if p.limit <= 0 {
+		return s
+	}
+	n := utf8.RuneCountInString(s)
+	if n <= p.limit {
+		return s
+	}
+	runes := []rune(s)
+	return fmt.Sprintf("%s... (%d more)", string(runes[:p.limit]), n-p.limit)
+}
+
+// newline starts a new line at the given indentation depth.
+func (p *jsonPrinter) newline(depth int) {
+	p.buf.WriteByte('\n')
+	p.buf.WriteString(strings.Repeat(jsonIndent, depth))
+}
+
+// write writes the given string colorized with the given function.
+func (p *jsonPrinter) write(colorize func(string) string, s string) {
+	if colorize != nil {
+		s = colorize(s)
+	}
+	p.buf.WriteString(s)
+}
+
+// jsonLiteral returns the JSON representation of booleans and nil values.
+func jsonLiteral(v interface{}) interface{} {
+	if v == nil {
+		return "null"
+	}
+	return v
+}
+
+// quote returns the given string as a JSON string.
+func quote(s string) string {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(s); err != nil {
+		// This should never happen.
+		panic(err)
+	}
+	return strings.TrimSuffix(buf.String(), "\n")
+}
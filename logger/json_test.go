@@ -0,0 +1,104 @@
+package logger_test
+
+import (
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/juju/guiproxy/logger"
+)
+
+var prettyJSONTests = []struct {
+	about    string
+	style    logger.JSONStyle
+	limit    int
+	msg      string
+	expected string
+}{{
+	about:    "not a JSON message",
+	msg:      "these are the voyages",
+	expected: "these are the voyages",
+}, {
+	about:    "multiple JSON values",
+	msg:      `{"a": 1} {"b": 2}`,
+	expected: `{"a": 1} {"b": 2}`,
+}, {
+	about:    "invalid JSON message",
+	msg:      `{"a": 1`,
+	expected: `{"a": 1`,
+}, {
+	about: "object",
+	msg:   `{"request-id":1,"type":"Client","params":{"tags":["a","b"],"ok":true,"none":null},"empty":{},"list":[]}`,
+	expected: `{
+  "request-id": 1,
+  "type": "Client",
+  "params": {
+    "tags": [
+      "a",
+      "b"
+    ],
+    "ok": true,
+    "none": null
+  },
+  "empty": {},
+  "list": []
+}`,
+}, {
+	about: "colors",
+	style: logger.JSONStyle{
+		Key:     wrap("k"),
+		String:  wrap("s"),
+		Number:  wrap("n"),
+		Literal: wrap("l"),
+		Error:   wrap("e"),
+	},
+	msg: `{"id":42,"name":"<django>","error":"bad wolf","ok":false,"results":[{"error":{"code":"not found","retry":3}}]}`,
+	expected: `{
+  k<"id">: n<42>,
+  k<"name">: s<"<django>">,
+  k<"error">: e<"bad wolf">,
+  k<"ok">: l<false>,
+  k<"results">: [
+    {
+      k<"error">: {
+        k<"code">: e<"not found">,
+        k<"retry">: e<3>
+      }
+    }
+  ]
+}`,
+}, {
+	about: "truncation",
+	limit: 2,
+	msg:   `{"s":"exterminate","short":"ok","list":[1,[2,3,4],{"a":[5]},6]}`,
+	expected: `{
+  "s": "ex... (9 more)",
+  "short": "ok",
+  "list": [
+    1,
+    [
+      2,
+      3,
+      ... 1 more
+    ],
+    ... 2 more
+  ]
+}`,
+}}
+
+func TestPrettyJSON(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range prettyJSONTests {
+		c.Run(test.about, func(c *qt.C) {
+			f := logger.PrettyJSON(test.style, test.limit)
+			c.Assert(f(test.msg), qt.Equals, test.expected)
+		})
+	}
+}
+
+// wrap returns a function wrapping strings with the given name.
+func wrap(name string) func(string) string {
+	return func(s string) string {
+		return name + "<" + s + ">"
+	}
+}
@@ -1,6 +1,9 @@
 package logger
 
-import "log"
+import (
+	"io"
+	"log"
+)
 
 // Interface holds the logger interface used to log string messages.
 type Interface interface {
@@ -17,9 +20,21 @@ func New(modifiers ...func(string) string) Interface {
 	}
 }
 
+// NewWriter creates and returns a new API logger implementing Interface that
+// writes messages to the given writer rather than to the standard logger.
+// As with New, messages are processed using the given modifier functions.
+func NewWriter(w io.Writer, modifiers ...func(string) string) Interface {
+	l := log.New(w, "", log.LstdFlags)
+	return &apiLogger{
+		modifiers: modifiers,
+		println:   l.Println,
+	}
+}
+
 // apiLogger implements Interface by logging API messages.
 type apiLogger struct {
 	modifiers []func(msg string) string
+	println   func(v ...interface{})
 }
 
 // Print implements Interface and logs string messages.
@@ -29,6 +44,10 @@ func (l *apiLogger) Print(msg string) {
 			msg = modifier(msg)
 		}
 	}
+	if l.println != nil {
+		l.println(msg)
+		return
+	}
 	logPrintln(msg)
 }
 
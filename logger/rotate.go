@@ -0,0 +1,127 @@
+package logger
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// OpenRotatingFile opens the file at the given path for appending, and returns
+// a writer that rotates the file when its size would exceed maxSize bytes.
+// Rotated files are renamed by adding a numeric suffix (like "path.1") and at
+// most maxBackups of them are kept. A zero maxSize disables rotation.
+func OpenRotatingFile(path string, maxSize int64, maxBackups int) (*RotatingFile, error) {
+	f := &RotatingFile{
+		path:       path,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// RotatingFile is an io.WriteCloser writing to a file that is rotated based on
+// its size.
+type RotatingFile struct {
+	mu         sync.Mutex
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+// Write implements io.Writer by writing to the current file, after rotating
+// it if required. If rotation fails, the error is logged and the current file
+// keeps being written to, so that no content is lost.
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		// A previous rotation failed to reopen the file.
+		if err := f.open(); err != nil {
+			return 0, err
+		}
+	}
+	if f.maxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			logPrintln(err)
+			if f.file == nil {
+				return 0, err
+			}
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// Close implements io.Closer by closing the current file.
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return nil
+	}
+	return f.file.Close()
+}
+
+// open opens the file at the current path.
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("cannot open log file: %s", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("cannot stat log file: %s", err)
+	}
+	f.file, f.size = file, info.Size()
+	return nil
+}
+
+// rotate closes the current file, shifts the backups and opens a new file.
+// If the backups cannot be shifted, the current file is reopened.
+func (f *RotatingFile) rotate() error {
+	err := f.file.Close()
+	f.file = nil
+	if err != nil {
+		err = fmt.Errorf("cannot close log file: %s", err)
+	} else {
+		err = f.shift()
+	}
+	if openErr := f.open(); openErr != nil {
+		if err != nil {
+			return fmt.Errorf("%s; %s", err, openErr)
+		}
+		return openErr
+	}
+	return err
+}
+
+// shift removes the oldest file and renames the others, including the current
+// one, by incrementing their numeric suffix.
+func (f *RotatingFile) shift() error {
+	// Remove the oldest file, which is the current one if no backups are kept.
+	if err := os.Remove(f.backupPath(f.maxBackups)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("cannot remove old log file: %s", err)
+	}
+	for i := f.maxBackups; i > 0; i-- {
+		if err := os.Rename(f.backupPath(i-1), f.backupPath(i)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("cannot rotate log file: %s", err)
+		}
+	}
+	return nil
+}
+
+// backupPath returns the path of the backup file with the given index. The
+// zero index refers to the current file.
+func (f *RotatingFile) backupPath(i int) string {
+	if i == 0 {
+		return f.path
+	}
+	return fmt.Sprintf("%s.%d", f.path, i)
+}
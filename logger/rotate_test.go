@@ -0,0 +1,130 @@
+package logger_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/juju/guiproxy/logger"
+)
+
+func TestRotatingFile(t *testing.T) {
+	c := qt.New(t)
+	defer c.Cleanup()
+	dir := c.Mkdir()
+	path := filepath.Join(dir, "conn.log")
+
+	f, err := logger.OpenRotatingFile(path, 10, 2)
+	c.Assert(err, qt.Equals, nil)
+	write := func(s string) {
+		_, err := f.Write([]byte(s))
+		c.Assert(err, qt.Equals, nil)
+	}
+	write("12345")
+	write("67890")
+	assertContent(c, dir, map[string]string{
+		"conn.log": "1234567890",
+	})
+
+	// The file is rotated when exceeding the maximum size.
+	write("these")
+	write("are")
+	assertContent(c, dir, map[string]string{
+		"conn.log":   "theseare",
+		"conn.log.1": "1234567890",
+	})
+	write("the voyages")
+	write("of the")
+	assertContent(c, dir, map[string]string{
+		"conn.log":   "of the",
+		"conn.log.1": "the voyages",
+		"conn.log.2": "theseare",
+	})
+
+	// Only the given number of backups is kept.
+	write("starship")
+	c.Assert(f.Close(), qt.Equals, nil)
+	assertContent(c, dir, map[string]string{
+		"conn.log":   "starship",
+		"conn.log.1": "of the",
+		"conn.log.2": "the voyages",
+	})
+
+	// Existing files are appended to.
+	f, err = logger.OpenRotatingFile(path, 0, 0)
+	c.Assert(err, qt.Equals, nil)
+	write(" enterprise")
+	c.Assert(f.Close(), qt.Equals, nil)
+	assertContent(c, dir, map[string]string{
+		"conn.log":   "starship enterprise",
+		"conn.log.1": "of the",
+		"conn.log.2": "the voyages",
+	})
+}
+
+func TestRotatingFileRotationError(t *testing.T) {
+	c := qt.New(t)
+	defer c.Cleanup()
+	var logged []string
+	c.Patch(logger.LogPrintln, func(v ...interface{}) {
+		logged = append(logged, fmt.Sprint(v...))
+	})
+	dir := c.Mkdir()
+	path := filepath.Join(dir, "conn.log")
+	// Make the oldest backup impossible to remove.
+	err := os.MkdirAll(filepath.Join(dir, "conn.log.1", "sub"), 0700)
+	c.Assert(err, qt.Equals, nil)
+
+	f, err := logger.OpenRotatingFile(path, 5, 1)
+	c.Assert(err, qt.Equals, nil)
+	defer f.Close()
+	for _, s := range []string{"these", "are", "the voyages"} {
+		_, err := f.Write([]byte(s))
+		c.Assert(err, qt.Equals, nil)
+	}
+	// The current file keeps being written to, and the errors are logged.
+	b, err := ioutil.ReadFile(path)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(string(b), qt.Equals, "thesearethe voyages")
+	c.Assert(logged, qt.HasLen, 2)
+	c.Assert(logged[0], qt.Matches, "cannot remove old log file: .*")
+}
+
+func TestOpenRotatingFileError(t *testing.T) {
+	c := qt.New(t)
+	defer c.Cleanup()
+	_, err := logger.OpenRotatingFile(filepath.Join(c.Mkdir(), "no-such-dir", "conn.log"), 0, 0)
+	c.Assert(err, qt.ErrorMatches, "cannot open log file: .*")
+}
+
+func TestNewWriter(t *testing.T) {
+	c := qt.New(t)
+	defer c.Cleanup()
+	path := filepath.Join(c.Mkdir(), "conn.log")
+	f, err := logger.OpenRotatingFile(path, 0, 0)
+	c.Assert(err, qt.Equals, nil)
+	l := logger.NewWriter(f, logger.AddPrefix("prefix"))
+	l.Print("exterminate")
+	c.Assert(f.Close(), qt.Equals, nil)
+	b, err := ioutil.ReadFile(path)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(string(b), qt.Matches, `\d{4}/\d\d/\d\d \d\d:\d\d:\d\d prefix: exterminate\n`)
+}
+
+// assertContent checks that the given directory only includes the given files
+// with the given content.
+func assertContent(c *qt.C, dir string, expected map[string]string) {
+	infos, err := ioutil.ReadDir(dir)
+	c.Assert(err, qt.Equals, nil)
+	files := make(map[string]string, len(infos))
+	for _, info := range infos {
+		b, err := ioutil.ReadFile(filepath.Join(dir, info.Name()))
+		c.Assert(err, qt.Equals, nil)
+		files[info.Name()] = string(b)
+	}
+	c.Assert(files, qt.DeepEquals, expected)
+}
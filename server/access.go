@@ -0,0 +1,183 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Access holds the access control settings enforced in front of the proxy.
+// Access is unrestricted if no settings are provided.
+type Access struct {
+	// Username and Password optionally hold the credentials clients must
+	// provide using HTTP basic authentication, either in the
+	// Proxy-Authorization header or in the Authorization one. Since the
+	// latter is forwarded to Juju when opening WebSocket connections, for
+	// instance to stream debug logs, clients also authenticating to Juju must
+	// use the Proxy-Authorization header.
+	Username, Password string
+
+	// Token optionally holds a secret token clients must provide, either in
+	// the TokenParam query parameter, usually only once, or in a cookie,
+	// which is set when a valid token is provided in the query. If
+	// both basic authentication and a token are configured, any of them
+	// grants access.
+	Token string
+
+	// AllowedNetworks optionally holds the networks clients are allowed to
+	// connect from.
+	AllowedNetworks []*net.IPNet
+}
+
+// TokenParam holds the name of the query parameter used to provide the
+// access token.
+const TokenParam = "guiproxy-token"
+
+const (
+	// tokenCookie holds the name of the cookie storing the access token.
+	tokenCookie = "guiproxy-token"
+
+	// authRealm holds the realm used for HTTP basic authentication.
+	authRealm = "guiproxy"
+)
+
+// ParseNetworks parses the given networks in CIDR notation, for instance
+// "10.0.0.0/8". Single IP addresses are also accepted.
+func ParseNetworks(cidrs []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address %q", cidr)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// newAccessHandler returns an HTTP handler enforcing the given access control
+// settings before calling the given handler.
+func newAccessHandler(h http.Handler, a Access) http.Handler {
+	if a.Username == "" && a.Token == "" && len(a.AllowedNetworks) == 0 {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !a.allowsNetwork(req.RemoteAddr) {
+			log.Printf("rejected request from %s: network not allowed", req.RemoteAddr)
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		if a.Username == "" && a.Token == "" {
+			h.ServeHTTP(w, req)
+			return
+		}
+		if a.checkBasicAuth(req) || a.checkToken(w, req) {
+			h.ServeHTTP(w, req)
+			return
+		}
+		if a.Username != "" {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf("Basic realm=%q", authRealm))
+		}
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+	})
+}
+
+// allowsNetwork reports whether clients at the given remote address are
+// allowed to connect.
+func (a Access) allowsNetwork(remoteAddr string) bool {
+	if len(a.AllowedNetworks) == 0 {
+		return true
+	}
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, n := range a.AllowedNetworks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkBasicAuth reports whether the given request includes valid basic
+// authentication credentials, in the Proxy-Authorization or Authorization
+// header. If so, the header including the credentials is removed from the
+// request, so that they are never forwarded to Juju.
+func (a Access) checkBasicAuth(req *http.Request) bool {
+	if a.Username == "" {
+		return false
+	}
+	for _, name := range []string{"Proxy-Authorization", "Authorization"} {
+		username, password, ok := parseBasicAuth(req.Header.Get(name))
+		if ok && secureEqual(username, a.Username) && secureEqual(password, a.Password) {
+			req.Header.Del(name)
+			return true
+		}
+	}
+	return false
+}
+
+// parseBasicAuth parses the given HTTP basic authentication header value.
+func parseBasicAuth(value string) (username, password string, ok bool) {
+	const prefix = "Basic "
+	if len(value) < len(prefix) || !strings.EqualFold(value[:len(prefix)], prefix) {
+		return "", "", false
+	}
+	b, err := base64.StdEncoding.DecodeString(value[len(prefix):])
+	if err != nil {
+		return "", "", false
+	}
+	parts := strings.SplitN(string(b), ":", 2)
+	if len(parts) != 2 {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
+
+// checkToken reports whether the given request includes a valid token. When
+// the token is provided in the query, a cookie is set so that subsequent
+// requests, including WebSocket connections, are authorized.
+func (a Access) checkToken(w http.ResponseWriter, req *http.Request) bool {
+	if a.Token == "" {
+		return false
+	}
+	if c, err := req.Cookie(tokenCookie); err == nil && secureEqual(c.Value, a.Token) {
+		return true
+	}
+	if !secureEqual(req.URL.Query().Get(TokenParam), a.Token) {
+		return false
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     tokenCookie,
+		Value:    a.Token,
+		Path:     "/",
+		HttpOnly: true,
+	})
+	return true
+}
+
+// secureEqual reports whether the given strings are equal, in constant time.
+func secureEqual(s1, s2 string) bool {
+	return subtle.ConstantTimeCompare([]byte(s1), []byte(s2)) == 1
+}
@@ -0,0 +1,282 @@
+package server_test
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/gorilla/websocket"
+
+	it "github.com/juju/guiproxy/internal/testing"
+	"github.com/juju/guiproxy/server"
+)
+
+func TestAccess(t *testing.T) {
+	c := qt.New(t)
+	localhost := mustParseNetworks(c, "127.0.0.0/8", "::1")
+	tests := []struct {
+		about          string
+		access         server.Access
+		setup          func(req *http.Request)
+		expectedStatus int
+		expectedHeader http.Header
+	}{{
+		about:          "no restrictions",
+		expectedStatus: http.StatusOK,
+	}, {
+		about: "network allowed",
+		access: server.Access{
+			AllowedNetworks: localhost,
+		},
+		expectedStatus: http.StatusOK,
+	}, {
+		about: "network not allowed",
+		access: server.Access{
+			AllowedNetworks: mustParseNetworks(c, "10.0.0.0/8"),
+		},
+		expectedStatus: http.StatusForbidden,
+	}, {
+		about: "missing credentials",
+		access: server.Access{
+			Username: "who",
+			Password: "tardis",
+		},
+		expectedStatus: http.StatusUnauthorized,
+		expectedHeader: http.Header{
+			"Www-Authenticate": {`Basic realm="guiproxy"`},
+		},
+	}, {
+		about: "invalid credentials",
+		access: server.Access{
+			Username: "who",
+			Password: "tardis",
+		},
+		setup: func(req *http.Request) {
+			req.SetBasicAuth("who", "bad-wolf")
+		},
+		expectedStatus: http.StatusUnauthorized,
+	}, {
+		about: "valid credentials",
+		access: server.Access{
+			Username:        "who",
+			Password:        "tardis",
+			AllowedNetworks: localhost,
+		},
+		setup: func(req *http.Request) {
+			req.SetBasicAuth("who", "tardis")
+		},
+		expectedStatus: http.StatusOK,
+	}, {
+		about: "valid credentials in the proxy authorization header",
+		access: server.Access{
+			Username: "who",
+			Password: "tardis",
+		},
+		setup: func(req *http.Request) {
+			req.Header.Set("Proxy-Authorization", basicAuth("who", "tardis"))
+			req.SetBasicAuth("juju", "bad-wolf")
+		},
+		expectedStatus: http.StatusOK,
+	}, {
+		about: "invalid credentials in the proxy authorization header",
+		access: server.Access{
+			Username: "who",
+			Password: "tardis",
+		},
+		setup: func(req *http.Request) {
+			req.Header.Set("Proxy-Authorization", basicAuth("who", "bad-wolf"))
+		},
+		expectedStatus: http.StatusUnauthorized,
+	}, {
+		about: "valid credentials from a network not allowed",
+		access: server.Access{
+			Username:        "who",
+			Password:        "tardis",
+			AllowedNetworks: mustParseNetworks(c, "10.0.0.1"),
+		},
+		setup: func(req *http.Request) {
+			req.SetBasicAuth("who", "tardis")
+		},
+		expectedStatus: http.StatusForbidden,
+	}, {
+		about: "missing token",
+		access: server.Access{
+			Token: "secret",
+		},
+		expectedStatus: http.StatusUnauthorized,
+	}, {
+		about: "invalid token",
+		access: server.Access{
+			Token: "secret",
+		},
+		setup: func(req *http.Request) {
+			req.URL.RawQuery = "guiproxy-token=bad-wolf"
+		},
+		expectedStatus: http.StatusUnauthorized,
+	}, {
+		about: "valid token in query",
+		access: server.Access{
+			Token: "secret",
+		},
+		setup: func(req *http.Request) {
+			req.URL.RawQuery = "guiproxy-token=secret"
+		},
+		expectedStatus: http.StatusOK,
+		expectedHeader: http.Header{
+			"Set-Cookie": {"guiproxy-token=secret; Path=/; HttpOnly"},
+		},
+	}, {
+		about: "valid token in cookie",
+		access: server.Access{
+			Token: "secret",
+		},
+		setup: func(req *http.Request) {
+			req.AddCookie(&http.Cookie{Name: "guiproxy-token", Value: "secret"})
+		},
+		expectedStatus: http.StatusOK,
+	}, {
+		about: "basic auth or token",
+		access: server.Access{
+			Username: "who",
+			Password: "tardis",
+			Token:    "secret",
+		},
+		setup: func(req *http.Request) {
+			req.AddCookie(&http.Cookie{Name: "guiproxy-token", Value: "secret"})
+		},
+		expectedStatus: http.StatusOK,
+	}}
+	for _, test := range tests {
+		c.Run(test.about, func(c *qt.C) {
+			proxy := httptest.NewServer(server.New(server.Params{
+				ControllerAddr: "1.2.3.4:17070",
+				GUIURL:         it.MustParseURL(t, "http://1.2.3.4/"),
+				Access:         test.access,
+			}))
+			defer proxy.Close()
+			req, err := http.NewRequest("GET", proxy.URL+"/config.js", nil)
+			c.Assert(err, qt.Equals, nil)
+			if test.setup != nil {
+				test.setup(req)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			c.Assert(err, qt.Equals, nil)
+			defer resp.Body.Close()
+			c.Assert(resp.StatusCode, qt.Equals, test.expectedStatus)
+			for k, v := range test.expectedHeader {
+				c.Assert(resp.Header[k], qt.DeepEquals, v)
+			}
+		})
+	}
+}
+
+func TestAccessLogAuthorization(t *testing.T) {
+	c := qt.New(t)
+	juju := httptest.NewTLSServer(http.HandlerFunc(authorizationHandler))
+	defer juju.Close()
+	jujuURL := it.MustParseURL(t, juju.URL)
+	proxy := httptest.NewServer(server.New(server.Params{
+		ControllerAddr: jujuURL.Host,
+		GUIURL:         it.MustParseURL(t, "http://1.2.3.4/"),
+		Access: server.Access{
+			Username: "who",
+			Password: "tardis",
+		},
+	}))
+	defer proxy.Close()
+	u := it.MustParseURL(t, proxy.URL)
+	u.Scheme = "ws"
+	logURL := fmt.Sprintf("%s/model/log/?model=%s&uuid=uuid", u, jujuURL.Host)
+
+	tests := []struct {
+		about                 string
+		header                http.Header
+		expectedStatus        int
+		expectedAuthorization string
+	}{{
+		about: "proxy credentials in the authorization header",
+		header: http.Header{
+			"Authorization": {basicAuth("who", "tardis")},
+		},
+		expectedStatus: http.StatusSwitchingProtocols,
+	}, {
+		about: "proxy and juju credentials",
+		header: http.Header{
+			"Proxy-Authorization": {basicAuth("who", "tardis")},
+			"Authorization":       {basicAuth("user-admin", "secret")},
+		},
+		expectedStatus:        http.StatusSwitchingProtocols,
+		expectedAuthorization: basicAuth("user-admin", "secret"),
+	}, {
+		about: "juju credentials only",
+		header: http.Header{
+			"Authorization": {basicAuth("user-admin", "secret")},
+		},
+		expectedStatus: http.StatusUnauthorized,
+	}}
+	for _, test := range tests {
+		c.Run(test.about, func(c *qt.C) {
+			conn, resp, err := websocket.DefaultDialer.Dial(logURL, test.header)
+			c.Assert(resp, qt.Not(qt.IsNil))
+			c.Assert(resp.StatusCode, qt.Equals, test.expectedStatus)
+			if test.expectedStatus != http.StatusSwitchingProtocols {
+				c.Assert(err, qt.Not(qt.IsNil))
+				return
+			}
+			c.Assert(err, qt.Equals, nil)
+			defer conn.Close()
+			_, msg, err := conn.ReadMessage()
+			c.Assert(err, qt.Equals, nil)
+			c.Assert(string(msg), qt.Equals, test.expectedAuthorization)
+		})
+	}
+}
+
+// authorizationHandler is a WebSocket handler sending the Authorization
+// header included in the request.
+func authorizationHandler(w http.ResponseWriter, req *http.Request) {
+	upgrader := websocket.Upgrader{}
+	conn, err := upgrader.Upgrade(w, req, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	conn.WriteMessage(websocket.TextMessage, []byte(req.Header.Get("Authorization")))
+	conn.ReadMessage()
+}
+
+// basicAuth returns an HTTP basic authentication header value for the given
+// credentials.
+func basicAuth(username, password string) string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+password))
+}
+
+func TestParseNetworks(t *testing.T) {
+	c := qt.New(t)
+	nets, err := server.ParseNetworks([]string{"10.0.0.0/8", "192.168.1.42", "fe80::/10", "::1"})
+	c.Assert(err, qt.Equals, nil)
+	strs := make([]string, len(nets))
+	for i, n := range nets {
+		strs[i] = n.String()
+	}
+	c.Assert(strs, qt.DeepEquals, []string{"10.0.0.0/8", "192.168.1.42/32", "fe80::/10", "::1/128"})
+
+	nets, err = server.ParseNetworks([]string{"10.0.0.0/8", "bad-wolf"})
+	c.Assert(err, qt.ErrorMatches, `invalid IP address "bad-wolf"`)
+	c.Assert(nets, qt.IsNil)
+
+	nets, err = server.ParseNetworks([]string{"10.0.0.0/42"})
+	c.Assert(err, qt.ErrorMatches, `invalid CIDR address: 10.0.0.0/42`)
+	c.Assert(nets, qt.IsNil)
+}
+
+// mustParseNetworks parses the given networks, failing the test on errors.
+func mustParseNetworks(c *qt.C, cidrs ...string) []*net.IPNet {
+	nets, err := server.ParseNetworks(cidrs)
+	c.Assert(err, qt.Equals, nil)
+	return nets
+}
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"net"
+	"path"
+	"strings"
+)
+
+// newHostAllowlist returns an allowlist of the hosts the proxy is allowed to
+// connect to, based on the given addresses. Addresses are in the "host:port"
+// form, like "[fd42::1]:17070" for IPv6, or just "host" to allow any port.
+// Shell style "*" and "?" wildcards can be used, for instance
+// "*.jujucharms.com:443". Empty addresses are ignored.
+func newHostAllowlist(addrs ...string) hostAllowlist {
+	allowed := make(hostAllowlist, 0, len(addrs))
+	for _, addr := range addrs {
+		if addr != "" {
+			allowed = append(allowed, strings.ToLower(addr))
+		}
+	}
+	return allowed
+}
+
+// hostAllowlist holds the hosts the proxy is allowed to connect to.
+type hostAllowlist []string
+
+// allows reports whether connecting to the given "host:port" address is
+// allowed.
+func (a hostAllowlist) allows(addr string) bool {
+	addr = strings.ToLower(addr)
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	for _, pattern := range a {
+		target := addr
+		if _, _, err := net.SplitHostPort(pattern); err != nil {
+			// The pattern does not include the port, and could be a
+			// bracketed IPv6 address.
+			target, pattern = host, strings.Trim(pattern, "[]")
+		}
+		if matchHost(pattern, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchHost reports whether the given address matches the given pattern, in
+// which only wildcards are special: other characters, including the brackets
+// of IPv6 addresses, are matched literally.
+func matchHost(pattern, addr string) bool {
+	if pattern == addr {
+		return true
+	}
+	if !strings.ContainsAny(pattern, "*?") {
+		return false
+	}
+	ok, _ := path.Match(patternEscaper.Replace(pattern), addr)
+	return ok
+}
+
+// patternEscaper escapes the characters with a special meaning in path.Match
+// patterns, except wildcards.
+var patternEscaper = strings.NewReplacer(`\`, `\\`, "[", `\[`, "]", `\]`)
@@ -0,0 +1,87 @@
+package server_test
+
+import (
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/juju/guiproxy/server"
+)
+
+var allowsHostTests = []struct {
+	about    string
+	addrs    []string
+	addr     string
+	expected bool
+}{{
+	about:    "exact match",
+	addrs:    []string{"1.2.3.4:17070"},
+	addr:     "1.2.3.4:17070",
+	expected: true,
+}, {
+	about: "port mismatch",
+	addrs: []string{"1.2.3.4:17070"},
+	addr:  "1.2.3.4:443",
+}, {
+	about:    "any port",
+	addrs:    []string{"1.2.3.4"},
+	addr:     "1.2.3.4:443",
+	expected: true,
+}, {
+	about:    "case insensitive",
+	addrs:    []string{"JIMM.jujucharms.com:443"},
+	addr:     "jimm.JUJUCHARMS.com:443",
+	expected: true,
+}, {
+	about:    "wildcard",
+	addrs:    []string{"*.jujucharms.com:443"},
+	addr:     "jimm.jujucharms.com:443",
+	expected: true,
+}, {
+	about: "wildcard not matching",
+	addrs: []string{"*.jujucharms.com:443"},
+	addr:  "evil.com:443",
+}, {
+	about:    "multiple addresses",
+	addrs:    []string{"", "1.2.3.4:17070", "4.3.2.1"},
+	addr:     "4.3.2.1:17070",
+	expected: true,
+}, {
+	about:    "IPv6 address",
+	addrs:    []string{"[fd42::1]:17070"},
+	addr:     "[fd42::1]:17070",
+	expected: true,
+}, {
+	about: "IPv6 port mismatch",
+	addrs: []string{"[fd42::1]:17070"},
+	addr:  "[fd42::1]:443",
+}, {
+	about:    "IPv6 address without port",
+	addrs:    []string{"fd42::1", "[fd42::2]"},
+	addr:     "[fd42::2]:17070",
+	expected: true,
+}, {
+	about:    "IPv6 wildcard",
+	addrs:    []string{"[fd42::*]:17070"},
+	addr:     "[fd42::1]:17070",
+	expected: true,
+}, {
+	about: "IPv6 wildcard not matching",
+	addrs: []string{"[fd42::*]:17070"},
+	addr:  "[fd43::1]:17070",
+}, {
+	about: "empty allowlist",
+	addr:  "1.2.3.4:17070",
+}, {
+	about: "empty address",
+	addrs: []string{"1.2.3.4"},
+}}
+
+func TestAllowsHost(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range allowsHostTests {
+		c.Run(test.about, func(c *qt.C) {
+			c.Assert(server.AllowsHost(test.addrs, test.addr), qt.Equals, test.expected)
+		})
+	}
+}
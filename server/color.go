@@ -1,6 +1,10 @@
 package server
 
-import "fmt"
+import (
+	"fmt"
+
+	"github.com/juju/guiproxy/logger"
+)
 
 // colorFunc is a function that colorizes the given string.
 type colorFunc func(string) string
@@ -20,6 +24,8 @@ var (
 	pink       = mkColor(13)
 	yellow     = mkColor(11)
 	orange     = mkColor(202)
+	red        = mkColor(196)
+	purple     = mkColor(141)
 )
 
 // logColors returns the color functions to use for incoming and outgoing API
@@ -34,3 +40,18 @@ func logColors(isModel, noColor bool) (inColor, outColor colorFunc) {
 	}
 	return lightBlue, blue
 }
+
+// jsonStyle returns the style used to colorize pretty printed JSON messages.
+// Object keys are colorized using the given color function.
+func jsonStyle(keyColor colorFunc, noColor bool) logger.JSONStyle {
+	if noColor {
+		return logger.JSONStyle{}
+	}
+	return logger.JSONStyle{
+		Key:     keyColor,
+		String:  yellow,
+		Number:  orange,
+		Literal: purple,
+		Error:   red,
+	}
+}
@@ -5,6 +5,7 @@ import (
 
 	qt "github.com/frankban/quicktest"
 
+	"github.com/juju/guiproxy/logger"
 	"github.com/juju/guiproxy/server"
 )
 
@@ -18,3 +19,14 @@ func TestMkColor(t *testing.T) {
 	msg = f("of the starship enterprise")
 	c.Assert(msg, qt.Equals, "\033[38;5;47mof the starship enterprise\033[00m")
 }
+
+func TestJSONStyle(t *testing.T) {
+	c := qt.New(t)
+	defer c.Cleanup()
+	key := server.MkColor(42)
+	style := server.JSONStyle(key, false)
+	c.Assert(style.Key("k"), qt.Equals, key("k"))
+	c.Assert(style.Error("bad wolf"), qt.Equals, "\033[38;5;196mbad wolf\033[00m")
+	style = server.JSONStyle(key, true)
+	c.Assert(style, qt.DeepEquals, logger.JSONStyle{})
+}
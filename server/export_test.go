@@ -1,12 +1,28 @@
 package server
 
 var (
-	MkColor = mkColor
+	MkColor   = mkColor
+	JSONStyle = jsonStyle
+
+	CompressionReport = compressionReport
 
 	ControllerSrcTemplate  = controllerSrcTemplate
 	ModelSrcTemplate       = modelSrcTemplate
 	LegacyModelSrcTemplate = legacyModelSrcTemplate
+	LogSrcTemplate         = logSrcTemplate
+	CommandsSrcTemplate    = commandsSrcTemplate
+	LegacyLogSrcTemplate   = legacyLogSrcTemplate
 
 	JujuVersion       = jujuVersion
 	LegacyJujuVersion = legacyJujuVersion
+
+	OpenLogFile     = openLogFile
+	OpenCaptureFile = openCaptureFile
+	TimeNow         = &timeNow
 )
+
+// AllowsHost reports whether an allowlist created with the given addresses
+// allows connecting to addr.
+func AllowsHost(addrs []string, addr string) bool {
+	return newHostAllowlist(addrs...).allows(addr)
+}
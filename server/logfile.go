@@ -0,0 +1,89 @@
+package server
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/juju/guiproxy/logger"
+)
+
+// logMaxBackups holds the number of rotated files kept for each connection.
+const logMaxBackups = 5
+
+// openLogFile creates and returns the file used to log the traffic of the
+// WebSocket connection with the given id to the given endpoint. The file is
+// created in the given directory and its name includes the current time, the
+// connection id, the endpoint name and, if present in the given request URL,
+// the model UUID. An error is returned if the file already exists, so that
+// the traffic of different connections is never mixed.
+func openLogFile(dir string, id int, endpoint string, u *url.URL, maxSize int64) (*logger.RotatingFile, string, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, "", fmt.Errorf("cannot create log directory: %s", err)
+	}
+	path := filepath.Join(dir, connectionFileName(id, endpoint, u)+".log")
+	// Create the file exclusively before handing it over to the rotating
+	// file, which appends to existing files.
+	created, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, "", err
+	}
+	created.Close()
+	f, err := logger.OpenRotatingFile(path, maxSize, logMaxBackups)
+	if err != nil {
+		return nil, "", err
+	}
+	return f, path, nil
+}
+
+// openCaptureFile creates and returns the file used to capture the traffic
+// of the WebSocket connection with the given id to the given endpoint, named
+// and created as described in openLogFile.
+func openCaptureFile(dir string, id int, endpoint string, u *url.URL) (*os.File, string, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, "", fmt.Errorf("cannot create capture directory: %s", err)
+	}
+	path := filepath.Join(dir, connectionFileName(id, endpoint, u)+".capture")
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, "", err
+	}
+	return f, path, nil
+}
+
+// connectionFileName returns the base name, without extension, of the files
+// storing the traffic of the WebSocket connection with the given id to the
+// given endpoint.
+func connectionFileName(id int, endpoint string, u *url.URL) string {
+	parts := []string{timeNow().Format("20060102-150405.000"), strconv.Itoa(id), endpoint}
+	if uuid := u.Query().Get("uuid"); uuid != "" {
+		parts = append(parts, uuid)
+	}
+	return sanitizeFileName(strings.Join(parts, "-"))
+}
+
+// timeNow is defined as a variable for testing purposes.
+var timeNow = time.Now
+
+// endpointName returns a name for the endpoint described by the given source
+// template, for instance "model" or "controller".
+func endpointName(srcTemplate string) string {
+	path := strings.SplitN(srcTemplate, "?", 2)[0]
+	return strings.Replace(strings.Trim(path, "/"), "/", "-", -1)
+}
+
+// sanitizeFileName replaces characters that are not safe to be used in file
+// names.
+func sanitizeFileName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '.', r == '_':
+			return r
+		}
+		return '_'
+	}, name)
+}
@@ -0,0 +1,43 @@
+package server_test
+
+import (
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/juju/guiproxy/server"
+)
+
+func TestConnectionFiles(t *testing.T) {
+	c := qt.New(t)
+	defer c.Cleanup()
+	c.Patch(server.TimeNow, func() time.Time {
+		return time.Date(2018, 1, 18, 10, 0, 0, 0, time.UTC)
+	})
+	dir := c.Mkdir()
+	u, err := url.Parse("/model/?model=1.2.3.4:17070&uuid=my-uuid")
+	c.Assert(err, qt.Equals, nil)
+
+	// Connections opened at the same time use different files.
+	f1, path1, err := server.OpenCaptureFile(dir, 1, "model", u)
+	c.Assert(err, qt.Equals, nil)
+	defer f1.Close()
+	c.Assert(path1, qt.Equals, filepath.Join(dir, "20180118-100000.000-1-model-my-uuid.capture"))
+	f2, path2, err := server.OpenCaptureFile(dir, 2, "model", u)
+	c.Assert(err, qt.Equals, nil)
+	defer f2.Close()
+	c.Assert(path2, qt.Equals, filepath.Join(dir, "20180118-100000.000-2-model-my-uuid.capture"))
+	l, path, err := server.OpenLogFile(dir, 1, "model", u, 0)
+	c.Assert(err, qt.Equals, nil)
+	defer l.Close()
+	c.Assert(path, qt.Equals, filepath.Join(dir, "20180118-100000.000-1-model-my-uuid.log"))
+
+	// Existing files are never reused.
+	_, _, err = server.OpenCaptureFile(dir, 1, "model", u)
+	c.Assert(err, qt.ErrorMatches, ".*file exists")
+	_, _, err = server.OpenLogFile(dir, 1, "model", u, 0)
+	c.Assert(err, qt.ErrorMatches, ".*file exists")
+}
@@ -3,17 +3,22 @@ package server
 import (
 	"crypto/tls"
 	"fmt"
+	"io"
 	"log"
 	"mime"
 	"net/http"
 	"net/url"
 	"strings"
+	"time"
 
 	"github.com/gorilla/websocket"
 
 	"github.com/juju/guiproxy/httpproxy"
+	"github.com/juju/guiproxy/internal/capture"
 	"github.com/juju/guiproxy/internal/guiconfig"
+	"github.com/juju/guiproxy/internal/juju"
 	"github.com/juju/guiproxy/logger"
+	"github.com/juju/guiproxy/throttle"
 	"github.com/juju/guiproxy/wsproxy"
 )
 
@@ -32,37 +37,69 @@ const (
 	legacyModelSrcTemplate = "/model/?model=$server:$port"
 	legacyModelDstTemplate = "wss://$model/"
 
+	// logSrcTemplate, logDstTemplate, logsinkSrcTemplate, logsinkDstTemplate,
+	// commandsSrcTemplate and commandsDstTemplate hold templates used to
+	// establish WebSocket connections to the Juju model streaming endpoints,
+	// like debug-log and the embedded CLI commands.
+	logSrcTemplate      = "/model/log/?model=$server:$port&uuid=$uuid"
+	logDstTemplate      = "wss://$model/model/$uuid/log"
+	logsinkSrcTemplate  = "/model/logsink/?model=$server:$port&uuid=$uuid"
+	logsinkDstTemplate  = "wss://$model/model/$uuid/logsink"
+	commandsSrcTemplate = "/model/commands/?model=$server:$port&uuid=$uuid"
+	commandsDstTemplate = "wss://$model/model/$uuid/commands"
+
+	// legacyLogSrcTemplate and legacyLogDstTemplate hold templates used to
+	// establish WebSocket connections to the Juju 1 debug-log endpoint.
+	legacyLogSrcTemplate = "/model/log/?model=$server:$port"
+	legacyLogDstTemplate = "wss://$model/log"
+
+	// shellSrcPath holds the path on which the jujushell WebSocket is served.
+	shellSrcPath = "/shell/"
+
 	// jujuVersion and legacyJujuVersion hold the Juju versions declared in the
 	// dynamically generated Juju GUI configuration file.
 	jujuVersion       = "2.2.0"
 	legacyJujuVersion = "1.25.7"
 
-	// webSocketBufferSize holds the frame size for WebSocket messages.
+	// webSocketBufferSize holds the default frame size for WebSocket
+	// messages.
 	webSocketBufferSize = 65536
 )
 
 // New creates and returns a new GUI proxy server.
 func New(p Params) http.Handler {
 	mux := http.NewServeMux()
+	sessions := newSessions()
+	mux.Handle(sessionsPath, sessions)
+	p.allowed = newHostAllowlist(append([]string{p.ControllerAddr, targetHost(p.ShellURL)}, p.AllowedHosts...)...)
+	versions := newVersionTracker(p.JujuVersion, p.LegacyJuju)
+	p.versions = versions
 
 	var serveModel http.Handler
 	if p.LegacyJuju {
-		serveModel = newWebSocketProxy(legacyModelDstTemplate, legacyModelSrcTemplate, p.NoColor)
+		serveModel = newWebSocketProxy(legacyModelDstTemplate, legacyModelSrcTemplate, p, sessions)
+		mux.Handle("/model/log/", newWebSocketProxy(legacyLogDstTemplate, legacyLogSrcTemplate, p, sessions))
 	} else {
-		serveController := newWebSocketProxy(controllerDstTemplate, controllerSrcTemplate, p.NoColor)
+		serveController := newWebSocketProxy(controllerDstTemplate, controllerSrcTemplate, p, sessions)
 		mux.Handle("/controller/", serveController)
-		serveModel = newWebSocketProxy(modelDstTemplate, modelSrcTemplate, p.NoColor)
+		serveModel = newWebSocketProxy(modelDstTemplate, modelSrcTemplate, p, sessions)
+		mux.Handle("/model/log/", newWebSocketProxy(logDstTemplate, logSrcTemplate, p, sessions))
+		mux.Handle("/model/logsink/", newWebSocketProxy(logsinkDstTemplate, logsinkSrcTemplate, p, sessions))
+		mux.Handle("/model/commands/", newWebSocketProxy(commandsDstTemplate, commandsSrcTemplate, p, sessions))
 	}
 	mux.Handle("/model/", serveModel)
+	if p.ShellURL != "" {
+		mux.Handle(shellSrcPath, newWebSocketProxy(p.ShellURL, shellSrcPath, p, sessions))
+	}
 
 	configColor, jujuProxyColor, guiProxyColor := pink, orange, yellow
 	if p.NoColor {
 		configColor, jujuProxyColor, guiProxyColor = nil, nil, nil
 	}
-	mux.HandleFunc("/config.js", serveConfig(p.ControllerAddr, p.GUIConfig, p.LegacyJuju, logger.New(configColor)))
-	mux.Handle("/juju-core/", http.StripPrefix("/juju-core/", httpproxy.NewTLSReverseProxy(p.ControllerAddr, logger.New(jujuProxyColor))))
-	mux.Handle("/", httpproxy.NewRedirectHandler(p.BaseURL, p.GUIURL, logger.New(guiProxyColor)))
-	return mux
+	mux.HandleFunc("/config.js", serveConfig(p.ControllerAddr, p.GUIConfig, p.LegacyJuju, p.ShellURL != "", versions, logger.New(configColor)))
+	mux.Handle("/juju-core/", throttle.Handler(http.StripPrefix("/juju-core/", httpproxy.NewTLSReverseProxy(p.ControllerAddr, logger.New(jujuProxyColor))), p.Throttle))
+	mux.Handle("/", throttle.Handler(httpproxy.NewRedirectHandler(p.BaseURL, p.GUIURL, logger.New(guiProxyColor)), p.Throttle))
+	return newAccessHandler(mux, p.Access)
 }
 
 // Params holds parameters for creating a GUI proxy server.
@@ -83,19 +120,165 @@ type Params struct {
 	// LegacyJuju holds whether the proxy is connected to a Juju 1 model.
 	LegacyJuju bool
 
+	// JujuVersion optionally holds the Juju version declared in the GUI
+	// configuration file. If empty, a default version is used. In both
+	// cases, the version is updated with the one reported by Juju when the
+	// GUI logs in.
+	JujuVersion string
+
 	// NoColor holds whether to use colors in the log output.
 	NoColor bool
+
+	// PrettyLog holds whether to indent and highlight logged JSON frames.
+	PrettyLog bool
+
+	// LogLimit holds the length after which strings and arrays in pretty
+	// printed JSON frames are truncated. Zero means no truncation.
+	LogLimit int
+
+	// ShellURL optionally holds the WebSocket URL of a jujushell server, for
+	// instance "ws://localhost:8047/ws/". If provided, the shell is proxied
+	// and the GUI is configured to connect to it through the proxy.
+	ShellURL string
+
+	// Compress holds whether to negotiate permessage-deflate compression on
+	// WebSocket connections, both with the GUI and with Juju.
+	Compress bool
+
+	// BufferSize optionally holds the WebSocket read and write buffer sizes.
+	// If zero, a default size is used.
+	BufferSize int
+
+	// ReadLimit optionally holds the maximum size in bytes of WebSocket
+	// messages. Connections sending bigger messages are closed. If zero,
+	// no limit is applied.
+	ReadLimit int64
+
+	// Throttle optionally holds the network conditions to emulate on the
+	// proxied WebSocket and HTTP traffic.
+	Throttle *throttle.Profile
+
+	// PingInterval optionally holds the interval at which WebSocket pings are
+	// sent to both the GUI and Juju. Zero means no pings are sent.
+	PingInterval time.Duration
+
+	// ReadTimeout optionally holds the maximum time to wait for any WebSocket
+	// frame from the GUI or Juju before closing the connection.
+	ReadTimeout time.Duration
+
+	// IdleTimeout optionally holds the maximum time without WebSocket data
+	// frames before closing the connection.
+	IdleTimeout time.Duration
+
+	// Transformers optionally holds the transformers used to modify JSON
+	// WebSocket frames exchanged between the GUI and Juju.
+	Transformers []wsproxy.Transformer
+
+	// Observers optionally holds transformers applied after all other
+	// changes to JSON WebSocket frames have been made, including the ones
+	// for FacadeVersions and CookieJar, so that they see the frames actually
+	// sent. Observers are not expected to modify frames.
+	Observers []wsproxy.Transformer
+
+	// FacadeVersions optionally maps facade names to the only version Juju
+	// is reported to support in login responses. A zero version hides the
+	// facade, as if Juju did not support it.
+	FacadeVersions map[string]int
+
+	// RewriteFacadeVersions holds whether the version included in requests
+	// to the facades in FacadeVersions is rewritten as well.
+	RewriteFacadeVersions bool
+
+	// CookieJar optionally holds the Juju cookie jar whose macaroons, already
+	// discharged by "juju login", are reused to log in on behalf of the GUI,
+	// when the GUI does not provide its own. Macaroons are never discharged by
+	// the proxy.
+	CookieJar *juju.CookieJar
+
+	// LogDir optionally holds the directory in which the traffic of each
+	// WebSocket connection is logged to a separate file. If empty, traffic is
+	// logged to the standard logger.
+	LogDir string
+
+	// CaptureDir optionally holds the directory in which the JSON frames of
+	// each WebSocket connection are captured to a separate file, with
+	// sensitive values hidden using Redactor. Captures can be compared with
+	// "guiproxy diff".
+	CaptureDir string
+
+	// LogMaxSize holds the size in bytes after which per connection log files
+	// are rotated. Zero means no rotation.
+	LogMaxSize int64
+
+	// LogFilter optionally holds the filter used to decide which WebSocket
+	// frames are logged. All frames are logged if the filter is nil.
+	LogFilter *wsproxy.Filter
+
+	// Redactor optionally holds the redactor used to hide sensitive values in
+	// logged WebSocket frames.
+	Redactor *wsproxy.Redactor
+
+	// AllowedHosts optionally holds additional addresses, like controller
+	// endpoints, the proxy is allowed to connect to when requested by the
+	// GUI, in the "host[:port]" form, with optional shell style wildcards.
+	// The controller and jujushell addresses are always allowed.
+	AllowedHosts []string
+
+	// Access optionally holds the access control settings enforced on all
+	// requests to the proxy.
+	Access Access
+
+	// allowed holds the resulting allowlist of hosts.
+	allowed hostAllowlist
+
+	// versions holds the tracker of the Juju version reported on login.
+	versions *versionTracker
+}
+
+// bufferSize returns the WebSocket buffer size to use.
+func (p Params) bufferSize() int {
+	if p.BufferSize > 0 {
+		return p.BufferSize
+	}
+	return webSocketBufferSize
+}
+
+// transformers returns the transformers used to modify WebSocket frames.
+func (p Params) transformers() []wsproxy.Transformer {
+	if len(p.FacadeVersions) == 0 {
+		return p.Transformers
+	}
+	fv := wsproxy.NewFacadeVersions(p.FacadeVersions, p.RewriteFacadeVersions)
+	return append(append([]wsproxy.Transformer(nil), p.Transformers...), fv)
 }
 
 // newWebSocketProxy returns a WebSocket handler that proxies the WebSocket
 // frames from the Juju GUI to Juju and vice versa. WebSocket addresses are
-// translated using the given source and destination templates.
-func newWebSocketProxy(dstTemplate, srcTemplate string, noColor bool) http.Handler {
+// translated using the given source and destination templates. Each proxied
+// connection is identified by a unique id, used as log prefix, and is tracked
+// in the given sessions registry while open.
+func newWebSocketProxy(dstTemplate, srcTemplate string, p Params, sessions *sessions) http.Handler {
 	upgrader := websocket.Upgrader{
-		ReadBufferSize:  webSocketBufferSize,
-		WriteBufferSize: webSocketBufferSize,
+		ReadBufferSize:    p.bufferSize(),
+		WriteBufferSize:   p.bufferSize(),
+		EnableCompression: p.Compress,
 	}
+	transformers := p.transformers()
 	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		// Validate the requested target before upgrading the connection, so
+		// that the proxy cannot be used to reach arbitrary hosts.
+		target, err := resolveWebSocketAddress(req.URL, dstTemplate)
+		if err != nil {
+			log.Printf("invalid WebSocket request %s: %s", req.URL, err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if host := targetHost(target); !p.allowed.allows(host) {
+			log.Printf("rejected WebSocket request %s: host %q not allowed", req.URL, host)
+			http.Error(w, fmt.Sprintf("host %q not allowed", host), http.StatusForbidden)
+			return
+		}
+
 		// Upgrade the HTTP connection.
 		guiConn, err := upgrader.Upgrade(w, req, nil)
 		if err != nil {
@@ -103,33 +286,154 @@ func newWebSocketProxy(dstTemplate, srcTemplate string, noColor bool) http.Handl
 			return
 		}
 		defer guiConn.Close()
+		id := sessions.newID()
+		connLog := log.New(log.Writer(), fmt.Sprintf("%s[%d] ", log.Prefix(), id), log.Flags())
 
 		// Open the WebSocket connection to the remote server.
-		target := resolveWebSocketAddress(req.URL, dstTemplate)
-		log.Printf("opening %s\n", target)
-		targetConn, err := wsDial(target)
+		connLog.Printf("opening %s\n", target)
+		var wire wireCounter
+		targetConn, err := wsDial(target, forwardedHeader(req.Header), &wire, p)
 		if err != nil {
-			log.Printf("cannot dial %s: %s", target, err)
+			connLog.Printf("cannot dial %s: %s", target, err)
 			return
 		}
 		defer targetConn.Close()
+		if p.ReadLimit > 0 {
+			guiConn.SetReadLimit(p.ReadLimit)
+			targetConn.SetReadLimit(p.ReadLimit)
+		}
+
+		// Set up the log file for this connection if required.
+		var logFile io.Writer
+		if p.LogDir != "" {
+			f, path, err := openLogFile(p.LogDir, id, endpointName(srcTemplate), req.URL, p.LogMaxSize)
+			if err != nil {
+				connLog.Printf("cannot log traffic for %s: %s", target, err)
+				return
+			}
+			defer f.Close()
+			connLog.Printf("logging %s traffic to %s\n", target, path)
+			logFile = f
+		}
+
+		// Log in using the macaroons in the cookie jar if required.
+		transformers, err := loginTransformers(transformers, p.CookieJar, target, connLog)
+		if err != nil {
+			connLog.Printf("cannot use cookie jar for %s: %s", target, err)
+			return
+		}
+
+		// Observe and capture the traffic if required, after all other
+		// changes to frames have been applied.
+		if len(p.Observers) != 0 {
+			transformers = append(append([]wsproxy.Transformer(nil), transformers...), p.Observers...)
+		}
+		if p.CaptureDir != "" {
+			f, path, err := openCaptureFile(p.CaptureDir, id, endpointName(srcTemplate), req.URL)
+			if err != nil {
+				connLog.Printf("cannot capture traffic for %s: %s", target, err)
+				return
+			}
+			defer f.Close()
+			connLog.Printf("capturing %s traffic to %s\n", target, path)
+			transformers = append(append([]wsproxy.Transformer(nil), transformers...), capture.NewWriter(f, p.Redactor))
+		}
+
+		// Track the session while open.
+		sess := &session{
+			id:         id,
+			endpoint:   endpointName(srcTemplate),
+			remoteAddr: req.RemoteAddr,
+			target:     target,
+			started:    time.Now(),
+		}
+		sessions.add(sess)
+		defer sessions.remove(id)
 
 		// Start copying WebSocket messages back and forth.
 		addr := targetConn.RemoteAddr().String()
-		inColor, outColor := logColors(strings.HasPrefix(srcTemplate, "/model/"), noColor)
-		err = wsproxy.Copy(
-			targetConn,
-			guiConn,
-			logger.New(logger.AddPrefix("<-- "+addr), inColor),
-			logger.New(logger.AddPrefix("--> "+addr), outColor),
-		)
-		log.Printf("closed %s: %s\n", target, err)
+		inColor, outColor := logColors(strings.HasPrefix(srcTemplate, "/model/"), p.NoColor)
+		err = wsproxy.Copy(targetConn, guiConn, wsproxy.Params{
+			Conn1Log:        newFrameLogger(logFile, fmt.Sprintf("[%d] <-- %s", id, addr), inColor, p),
+			Conn2Log:        newFrameLogger(logFile, fmt.Sprintf("[%d] --> %s", id, addr), outColor, p),
+			Filter:          p.LogFilter,
+			Redactor:        p.Redactor,
+			Stats:           &sess.stats,
+			Throttle:        p.Throttle,
+			PingInterval:    p.PingInterval,
+			ReadTimeout:     p.ReadTimeout,
+			IdleTimeout:     p.IdleTimeout,
+			Transformers:    transformers,
+			OnServerVersion: p.versions.set,
+		})
+		if _, ok := err.(*wsproxy.LivenessError); ok {
+			connLog.Printf("closed %s for liveness reasons: %s\n", target, err)
+		} else {
+			connLog.Printf("closed %s: %s\n", target, err)
+		}
+		if p.Compress {
+			payloadIn, payloadOut := sess.stats.Bytes()
+			wireIn, wireOut := wire.bytes()
+			connLog.Printf("%s traffic: %s\n", target, compressionReport(payloadIn, payloadOut, wireIn, wireOut))
+		}
 	})
 }
 
+// loginTransformers returns the given transformers, extended with one adding
+// the macaroons stored in the given cookie jar for the target address to
+// login requests. The given transformers are returned if the jar is nil or no
+// macaroons are found, in which case the latter is reported to the given
+// logger, as the GUI must then log in interactively.
+func loginTransformers(transformers []wsproxy.Transformer, jar *juju.CookieJar, target string, log *log.Logger) ([]wsproxy.Transformer, error) {
+	if jar == nil {
+		return transformers, nil
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+	ms, err := jar.Macaroons(u.Hostname())
+	if err != nil {
+		return nil, err
+	}
+	if len(ms) == 0 {
+		log.Printf("no valid macaroons in the cookie jar for %s: the GUI must log in interactively (run \"juju login\" to refresh the jar)\n", u.Hostname())
+		return transformers, nil
+	}
+	return append(append([]wsproxy.Transformer(nil), transformers...), wsproxy.NewLoginMacaroons(ms)), nil
+}
+
+// newFrameLogger returns a logger for WebSocket frames using the given prefix
+// and color. When pretty printing is enabled only the prefix is colorized,
+// and JSON frames are highlighted. If the given writer is not nil, frames are
+// logged to that writer without colors.
+func newFrameLogger(w io.Writer, prefix string, color colorFunc, p Params) logger.Interface {
+	noColor := p.NoColor || w != nil
+	if noColor {
+		color = nil
+	}
+	var modifiers []func(string) string
+	if p.PrettyLog {
+		modifiers = append(modifiers, logger.PrettyJSON(jsonStyle(color, noColor), p.LogLimit))
+		if color != nil {
+			prefix = color(prefix)
+		}
+		modifiers = append(modifiers, logger.AddPrefix(prefix))
+	} else {
+		modifiers = append(modifiers, logger.AddPrefix(prefix), color)
+	}
+	if w != nil {
+		return logger.NewWriter(w, modifiers...)
+	}
+	return logger.New(modifiers...)
+}
+
 // resolveWebSocketAddress returns a Juju WebSocket address based on the given
 // regular expression, current request path and destination socket template.
-func resolveWebSocketAddress(u *url.URL, dstTemplate string) string {
+// Query parameters not used by the template, like the ones used to configure
+// debug-log streams, are forwarded. An error is returned if a query parameter
+// required by the template is missing.
+func resolveWebSocketAddress(u *url.URL, dstTemplate string) (string, error) {
 	query := u.Query()
 	fields := []string{"controller", "model", "uuid"}
 	oldnew := make([]string, 0, len(fields)*2)
@@ -139,56 +443,127 @@ func resolveWebSocketAddress(u *url.URL, dstTemplate string) string {
 		}
 		value := query.Get(field)
 		if value == "" {
-			log.Fatalf("invalid WebSocket URL %q: %q query not present", u, field)
+			return "", fmt.Errorf("%q query not present", field)
 		}
 		oldnew = append(oldnew, "$"+field, value)
 	}
+	for _, field := range fields {
+		query.Del(field)
+	}
 	r := strings.NewReplacer(oldnew...)
-	return r.Replace(dstTemplate)
+	addr := r.Replace(dstTemplate)
+	if len(query) != 0 {
+		addr += "?" + query.Encode()
+	}
+	return addr, nil
 }
 
-// wsDial opens a secure WebSocket client connection to the given address. The
-// TLS certificate verification is skipped. The returned connection must be
-// closed by callers.
-func wsDial(addr string) (*websocket.Conn, error) {
+// targetHost returns the "host:port" address of the given WebSocket target.
+// An empty string is returned if the target is not a valid URL.
+func targetHost(target string) string {
+	u, err := url.Parse(target)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
+
+// forwardedHeaders holds the names of the HTTP headers sent by the GUI that
+// are forwarded when opening WebSocket connections to Juju. For instance,
+// the debug-log endpoint requires HTTP basic authentication. Proxy basic
+// authentication credentials are removed before, see Access.
+var forwardedHeaders = []string{"Authorization"}
+
+// forwardedHeader returns the HTTP header to be used when connecting to Juju,
+// based on the given header included in the GUI request.
+func forwardedHeader(h http.Header) http.Header {
+	header := make(http.Header)
+	for _, name := range forwardedHeaders {
+		if values := h[name]; len(values) != 0 {
+			header[name] = values
+		}
+	}
+	return header
+}
+
+// wsDial opens a secure WebSocket client connection to the given address,
+// using the given request header. The TLS certificate verification is
+// skipped. The bytes transferred over the network are recorded in the given
+// wire counter. The returned connection must be closed by callers.
+func wsDial(addr string, header http.Header, wire *wireCounter, p Params) (*websocket.Conn, error) {
 	dialer := &websocket.Dialer{
-		Proxy: http.ProxyFromEnvironment,
+		NetDial: wire.dial,
+		Proxy:   http.ProxyFromEnvironment,
 		TLSClientConfig: &tls.Config{
 			InsecureSkipVerify: true,
 		},
-		ReadBufferSize:  webSocketBufferSize,
-		WriteBufferSize: webSocketBufferSize,
+		ReadBufferSize:    p.bufferSize(),
+		WriteBufferSize:   p.bufferSize(),
+		EnableCompression: p.Compress,
 	}
-	conn, _, err := dialer.Dial(addr, nil)
+	conn, _, err := dialer.Dial(addr, header)
 	if err != nil {
 		return nil, fmt.Errorf("cannot dial %s: %s", addr, err)
 	}
 	return conn, nil
 }
 
+// Config returns the Juju GUI JavaScript configuration file served by a proxy
+// server created with the given parameters, when reached at the given
+// "host:port" address.
+func Config(p Params, host string) string {
+	version := newVersionTracker(p.JujuVersion, p.LegacyJuju).get()
+	return guiConfig(p.ControllerAddr, p.GUIConfig, p.LegacyJuju, p.ShellURL != "", version, host)
+}
+
 // serveConfig returns an HTTP handler that serves the Juju GUI JavaScript
 // configuration file. The configuration is dynamically generated using the
-// given controller address, configuration overrides and whether a legacy Juju
-// is in use.
-func serveConfig(addr string, configOverrides map[string]interface{}, legacyJuju bool, log logger.Interface) func(w http.ResponseWriter, req *http.Request) {
-	controller, model := controllerSrcTemplate, modelSrcTemplate
-	version := jujuVersion
-	if legacyJuju {
-		controller, model = "", legacyModelSrcTemplate
-		version = legacyJujuVersion
-	}
-	cfg := guiconfig.New(guiconfig.Context{
-		Address:            addr,
-		JujuVersion:        version,
-		ControllerTemplate: controller,
-		ModelTemplate:      model,
-	}, configOverrides)
+// given controller address, configuration overrides, Juju version and whether
+// a legacy Juju is in use. If shell is true, the jujushell URL is set to point
+// to the shell WebSocket proxied by this server.
+func serveConfig(addr string, configOverrides map[string]interface{}, legacyJuju, shell bool, versions *versionTracker, log logger.Interface) func(w http.ResponseWriter, req *http.Request) {
 	return func(w http.ResponseWriter, req *http.Request) {
+		cfg := guiConfig(addr, configOverrides, legacyJuju, shell, versions.get(), req.Host)
 		log.Print(fmt.Sprintf("%s %s: %d OK\n%s", req.Method, req.URL, http.StatusOK, cfg))
 		w.Header().Set("Content-Type", jsMimeType)
 		fmt.Fprint(w, cfg)
 	}
 }
 
+// guiConfig generates the Juju GUI JavaScript configuration file, as described
+// in serveConfig, for a proxy server reached at the given host.
+func guiConfig(addr string, configOverrides map[string]interface{}, legacyJuju, shell bool, jujuVersion, host string) string {
+	ctx := guiconfig.Context{
+		Address:            addr,
+		JujuVersion:        jujuVersion,
+		ControllerTemplate: controllerSrcTemplate,
+		ModelTemplate:      modelSrcTemplate,
+		LogTemplate:        logSrcTemplate,
+		CommandsTemplate:   commandsSrcTemplate,
+	}
+	if legacyJuju {
+		ctx.ControllerTemplate, ctx.ModelTemplate = "", legacyModelSrcTemplate
+		ctx.LogTemplate, ctx.CommandsTemplate = legacyLogSrcTemplate, ""
+	}
+	overrides := configOverrides
+	if shell {
+		// The shell URL must be absolute, so it depends on the host used to
+		// reach the proxy.
+		overrides = withShellURL(configOverrides, "ws://"+host+shellSrcPath)
+	}
+	return guiconfig.New(ctx, overrides)
+}
+
+// withShellURL returns a copy of the given configuration overrides including
+// the given jujushell URL.
+func withShellURL(configOverrides map[string]interface{}, shellURL string) map[string]interface{} {
+	overrides := make(map[string]interface{}, len(configOverrides)+1)
+	for k, v := range configOverrides {
+		overrides[k] = v
+	}
+	overrides["jujushellURL"] = shellURL
+	return overrides
+}
+
 // jsMimeType holds the mime type used to serve the GUI configuration.
 var jsMimeType = mime.TypeByExtension(".js")
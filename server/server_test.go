@@ -8,12 +8,16 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	qt "github.com/frankban/quicktest"
 	"github.com/gorilla/websocket"
 
+	"github.com/juju/guiproxy/internal/capture"
 	it "github.com/juju/guiproxy/internal/testing"
 	"github.com/juju/guiproxy/server"
 )
@@ -66,15 +70,68 @@ func TestNew(t *testing.T) {
 	defer customConfigProxy.Close()
 	customConfigServerURL := it.MustParseURL(t, customConfigProxy.URL)
 
+	shell := httptest.NewServer(newShellServer())
+	defer shell.Close()
+	shellProxy := httptest.NewServer(server.New(server.Params{
+		ControllerAddr: jujuURL.Host,
+		GUIURL:         guiURL,
+		ShellURL:       "ws://" + it.MustParseURL(t, shell.URL).Host + "/ws/",
+	}))
+	defer shellProxy.Close()
+	shellServerURL := it.MustParseURL(t, shellProxy.URL)
+
+	compressProxy := httptest.NewServer(server.New(server.Params{
+		ControllerAddr: jujuURL.Host,
+		GUIURL:         guiURL,
+		Compress:       true,
+		BufferSize:     1024,
+		ReadLimit:      512,
+	}))
+	defer compressProxy.Close()
+	compressServerURL := it.MustParseURL(t, compressProxy.URL)
+
+	logDir := c.Mkdir()
+	logDirProxy := httptest.NewServer(server.New(server.Params{
+		ControllerAddr: jujuURL.Host,
+		GUIURL:         guiURL,
+		LogDir:         logDir,
+		PrettyLog:      true,
+	}))
+	defer logDirProxy.Close()
+	logDirServerURL := it.MustParseURL(t, logDirProxy.URL)
+
+	captureDir := c.Mkdir()
+	captureDirProxy := httptest.NewServer(server.New(server.Params{
+		ControllerAddr: jujuURL.Host,
+		GUIURL:         guiURL,
+		CaptureDir:     captureDir,
+	}))
+	defer captureDirProxy.Close()
+	captureDirServerURL := it.MustParseURL(t, captureDirProxy.URL)
+
 	controllerPath := fmt.Sprintf("/controller/?controller=%s", jujuURL.Host)
 	modelPath1 := fmt.Sprintf("/model/?model=%s&uuid=uuid", jujuURL.Host)
 	modelPath2 := fmt.Sprintf("/model/?model=%s&uuid=another-uuid", jujuURL.Host)
 	legacyModelPath := fmt.Sprintf("/model/?model=%s", legacyJujuURL.Host)
+	logPath := fmt.Sprintf("/model/log/?model=%s&uuid=uuid&replay=true", jujuURL.Host)
+	logsinkPath := fmt.Sprintf("/model/logsink/?model=%s&uuid=uuid", jujuURL.Host)
+	commandsPath := fmt.Sprintf("/model/commands/?model=%s&uuid=uuid", jujuURL.Host)
+	legacyLogPath := fmt.Sprintf("/model/log/?model=%s&lines=10", legacyJujuURL.Host)
 
 	c.Run("testJujuWebSocket Controller", testJujuWebSocket(serverURL, "/api", controllerPath))
 	c.Run("testJujuWebSocket Model1", testJujuWebSocket(serverURL, "/model/uuid/api", modelPath1))
 	c.Run("testJujuWebSocket Model2", testJujuWebSocket(serverURL, "/model/another-uuid/api", modelPath2))
 	c.Run("testJujuWebSocket Legacy", testJujuWebSocket(legacyServerURL, "/", legacyModelPath))
+	c.Run("testJujuWebSocket Log", testJujuWebSocket(serverURL, "/model/uuid/log?replay=true", logPath))
+	c.Run("testJujuWebSocket Logsink", testJujuWebSocket(serverURL, "/model/uuid/logsink", logsinkPath))
+	c.Run("testJujuWebSocket Commands", testJujuWebSocket(serverURL, "/model/uuid/commands", commandsPath))
+	c.Run("testJujuWebSocket Legacy Log", testJujuWebSocket(legacyServerURL, "/log?lines=10", legacyLogPath))
+
+	c.Run("testJujuWebSocket Compress", testJujuWebSocket(compressServerURL, "/model/uuid/api", modelPath1))
+	c.Run("testJujuWebSocketReadLimit", testJujuWebSocketReadLimit(compressServerURL, modelPath1))
+	c.Run("testJujuWebSocket Shell", testJujuWebSocket(shellServerURL, "/ws/", "/shell/"))
+	c.Run("testJujuWebSocketLogDir", testJujuWebSocketLogDir(logDirServerURL, logDir, modelPath1))
+	c.Run("testJujuWebSocketCaptureDir", testJujuWebSocketCaptureDir(captureDirServerURL, captureDir, modelPath1))
 
 	c.Run("testJujuHTTPS", testJujuHTTPS(serverURL))
 	c.Run("testJujuHTTPS Legacy", testJujuHTTPS(legacyServerURL))
@@ -83,6 +140,8 @@ func TestNew(t *testing.T) {
 		serverURL,
 		fmt.Sprintf(`"controllerSocketTemplate": %s`, jsonMarshalString(server.ControllerSrcTemplate)),
 		fmt.Sprintf(`"socketTemplate": %s`, jsonMarshalString(server.ModelSrcTemplate)),
+		fmt.Sprintf(`"logSocketTemplate": %s`, jsonMarshalString(server.LogSrcTemplate)),
+		fmt.Sprintf(`"commandsSocketTemplate": %s`, jsonMarshalString(server.CommandsSrcTemplate)),
 		fmt.Sprintf(`"apiAddress": "%s"`, jujuURL.Host),
 		fmt.Sprintf(`"jujuCoreVersion": "%s"`, server.JujuVersion),
 		`"jujuEnvUUID": ""`,
@@ -92,6 +151,8 @@ func TestNew(t *testing.T) {
 		legacyServerURL,
 		`"controllerSocketTemplate": ""`,
 		fmt.Sprintf(`"socketTemplate": %s`, jsonMarshalString(server.LegacyModelSrcTemplate)),
+		fmt.Sprintf(`"logSocketTemplate": %s`, jsonMarshalString(server.LegacyLogSrcTemplate)),
+		`"commandsSocketTemplate": ""`,
 		fmt.Sprintf(`"apiAddress": "%s"`, legacyJujuURL.Host),
 		fmt.Sprintf(`"jujuCoreVersion": "%s"`, server.LegacyJujuVersion),
 		`"jujuEnvUUID": ""`,
@@ -109,6 +170,11 @@ func TestNew(t *testing.T) {
 		`"jujuEnvUUID": ""`,
 	))
 
+	c.Run("testGUIConfig Shell", testGUIConfig(
+		shellServerURL,
+		fmt.Sprintf(`"jujushellURL": "ws://%s/shell/"`, shellServerURL.Host),
+	))
+
 	c.Run("testGUIStaticFiles", testGUIStaticFiles(serverURL))
 	c.Run("testGUIStaticFiles Legacy", testGUIStaticFiles(legacyServerURL))
 
@@ -140,6 +206,86 @@ func testJujuWebSocket(serverURL *url.URL, dstPath, srcPath string) func(c *qt.C
 	}
 }
 
+func testJujuWebSocketReadLimit(serverURL *url.URL, srcPath string) func(c *qt.C) {
+	u := *serverURL
+	u.Scheme = "ws"
+	socketURL := u.String() + srcPath
+	return func(c *qt.C) {
+		// Connect to the remote WebSocket.
+		dialer := websocket.Dialer{
+			EnableCompression: true,
+		}
+		conn, _, err := dialer.Dial(socketURL, nil)
+		c.Assert(err, qt.Equals, nil)
+		defer conn.Close()
+		// Send a message exceeding the read limit.
+		err = conn.WriteJSON(jsonMessage{
+			Request: strings.Repeat("exterminate ", 100),
+		})
+		c.Assert(err, qt.Equals, nil)
+		// The connection is closed by the proxy.
+		var msg jsonMessage
+		err = conn.ReadJSON(&msg)
+		c.Assert(err, qt.Not(qt.IsNil))
+	}
+}
+
+func testJujuWebSocketLogDir(serverURL *url.URL, logDir, srcPath string) func(c *qt.C) {
+	return func(c *qt.C) {
+		// Exchange a message on the WebSocket connection.
+		testJujuWebSocket(serverURL, "/model/uuid/api", srcPath)(c)
+		// The traffic has been logged to a file in the log directory.
+		var files []os.FileInfo
+		var content string
+		for a := waitAttempts(); a.next(); {
+			var err error
+			files, err = ioutil.ReadDir(logDir)
+			c.Assert(err, qt.Equals, nil)
+			if len(files) != 1 {
+				continue
+			}
+			b, err := ioutil.ReadFile(filepath.Join(logDir, files[0].Name()))
+			c.Assert(err, qt.Equals, nil)
+			content = string(b)
+			if strings.Count(content, "my api request") == 2 {
+				break
+			}
+		}
+		c.Assert(files, qt.HasLen, 1)
+		c.Assert(files[0].Name(), qt.Matches, `\d{8}-\d{6}\.\d{3}-\d+-model-uuid\.log`)
+		c.Assert(content, qt.Matches, `(?s).* --> 127\.0\.0\.1:\d+: \{\n  "Request": "my api request",\n  "Response": ""\n\}\n.*`)
+		c.Assert(content, qt.Matches, `(?s).* <-- 127\.0\.0\.1:\d+: \{\n  "Request": "my api request",\n  "Response": "/model/uuid/api"\n\}\n.*`)
+	}
+}
+
+func testJujuWebSocketCaptureDir(serverURL *url.URL, captureDir, srcPath string) func(c *qt.C) {
+	return func(c *qt.C) {
+		// Exchange a message on the WebSocket connection.
+		testJujuWebSocket(serverURL, "/model/uuid/api", srcPath)(c)
+		// The frames have been captured to a file in the capture directory.
+		var files []os.FileInfo
+		var entries []capture.Entry
+		for a := waitAttempts(); a.next(); {
+			var err error
+			files, err = ioutil.ReadDir(captureDir)
+			c.Assert(err, qt.Equals, nil)
+			if len(files) != 1 {
+				continue
+			}
+			entries, err = capture.ReadFile(filepath.Join(captureDir, files[0].Name()))
+			c.Assert(err, qt.Equals, nil)
+			if len(entries) == 2 {
+				break
+			}
+		}
+		c.Assert(files, qt.HasLen, 1)
+		c.Assert(files[0].Name(), qt.Matches, `\d{8}-\d{6}\.\d{3}-\d+-model-uuid\.capture`)
+		c.Assert(entries, qt.HasLen, 2)
+		c.Assert(string(entries[0].Content), qt.Equals, `{"Request":"my api request","Response":""}`)
+		c.Assert(string(entries[1].Content), qt.Equals, `{"Request":"my api request","Response":"/model/uuid/api"}`)
+	}
+}
+
 func testJujuHTTPS(serverURL *url.URL) func(c *qt.C) {
 	return func(c *qt.C) {
 		// Make the HTTP request to retrieve a Juju HTTPS API endpoint.
@@ -216,6 +362,33 @@ func testGUIRedirect(serverURL *url.URL, baseURL string) func(c *qt.C) {
 	}
 }
 
+// waitAttempts returns an attempt strategy used to wait for asynchronous
+// events to happen.
+func waitAttempts() *attempts {
+	return &attempts{
+		deadline: time.Now().Add(2 * time.Second),
+	}
+}
+
+// attempts implements a simple attempt strategy.
+type attempts struct {
+	deadline time.Time
+	started  bool
+}
+
+// next reports whether another attempt must be made, sleeping if required.
+func (a *attempts) next() bool {
+	if !a.started {
+		a.started = true
+		return true
+	}
+	if time.Now().After(a.deadline) {
+		return false
+	}
+	time.Sleep(10 * time.Millisecond)
+	return true
+}
+
 // newGUIServer creates and returns a new test server simulating a remote Juju
 // GUI run in sandbox mode.
 func newGUIServer() http.Handler {
@@ -238,6 +411,14 @@ func newJujuServer() http.Handler {
 	return mux
 }
 
+// newShellServer creates and returns a new test server simulating a jujushell
+// server.
+func newShellServer() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/ws/", http.HandlerFunc(echoHandler))
+	return mux
+}
+
 // newLegacyJujuServer creates and returns a new test server simulating a
 // remote Juju 1 model.
 func newLegacyJujuServer() http.Handler {
@@ -266,7 +447,7 @@ func echoHandler(w http.ResponseWriter, req *http.Request) {
 		if err != nil {
 			panic(err)
 		}
-		msg.Response = req.URL.Path
+		msg.Response = req.URL.RequestURI()
 		if err = conn.WriteJSON(msg); err != nil {
 			panic(err)
 		}
@@ -286,3 +467,211 @@ func jsonMarshalString(s interface{}) string {
 	}
 	return string(b)
 }
+
+func TestSessions(t *testing.T) {
+	c := qt.New(t)
+	defer c.Cleanup()
+	// Set up test servers.
+	juju := httptest.NewTLSServer(newJujuServer())
+	defer juju.Close()
+	jujuURL := it.MustParseURL(t, juju.URL)
+	proxy := httptest.NewServer(server.New(server.Params{
+		ControllerAddr: jujuURL.Host,
+		GUIURL:         it.MustParseURL(t, "http://1.2.3.4/"),
+	}))
+	defer proxy.Close()
+
+	// Open a WebSocket connection and exchange a message.
+	socketURL := strings.Replace(proxy.URL, "http://", "ws://", 1) + fmt.Sprintf("/model/?model=%s&uuid=uuid", jujuURL.Host)
+	conn, _, err := websocket.DefaultDialer.Dial(socketURL, nil)
+	c.Assert(err, qt.Equals, nil)
+	defer conn.Close()
+	err = conn.WriteJSON(jsonMessage{Request: "my api request"})
+	c.Assert(err, qt.Equals, nil)
+	var msg jsonMessage
+	err = conn.ReadJSON(&msg)
+	c.Assert(err, qt.Equals, nil)
+
+	// The open session is listed. Frame counts are updated asynchronously.
+	var sessions []map[string]interface{}
+	for a := waitAttempts(); a.next(); {
+		sessions = getSessions(c, proxy.URL)
+		if len(sessions) == 1 && sessions[0]["juju-frames"] == float64(1) {
+			break
+		}
+	}
+	c.Assert(sessions, qt.HasLen, 1)
+	c.Assert(sessions[0]["id"], qt.Equals, float64(1))
+	c.Assert(sessions[0]["endpoint"], qt.Equals, "model")
+	c.Assert(sessions[0]["remote-addr"], qt.Matches, `127\.0\.0\.1:\d+`)
+	c.Assert(sessions[0]["target"], qt.Equals, fmt.Sprintf("wss://%s/model/uuid/api", jujuURL.Host))
+	c.Assert(sessions[0]["gui-frames"], qt.Equals, float64(1))
+	c.Assert(sessions[0]["juju-frames"], qt.Equals, float64(1))
+
+	// Closed sessions are removed.
+	conn.Close()
+	for a := waitAttempts(); a.next(); {
+		sessions = getSessions(c, proxy.URL)
+		if len(sessions) == 0 {
+			break
+		}
+	}
+	c.Assert(sessions, qt.HasLen, 0)
+}
+
+// getSessions retrieves the open WebSocket sessions from the proxy at the
+// given URL.
+func getSessions(c *qt.C, proxyURL string) []map[string]interface{} {
+	resp, err := http.Get(proxyURL + "/_guiproxy/sessions")
+	c.Assert(err, qt.Equals, nil)
+	defer resp.Body.Close()
+	c.Assert(resp.StatusCode, qt.Equals, http.StatusOK)
+	c.Assert(resp.Header.Get("Content-Type"), qt.Equals, "application/json")
+	var sessions []map[string]interface{}
+	err = json.NewDecoder(resp.Body).Decode(&sessions)
+	c.Assert(err, qt.Equals, nil)
+	return sessions
+}
+
+func TestJujuVersion(t *testing.T) {
+	c := qt.New(t)
+	defer c.Cleanup()
+	// Set up test servers.
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api", loginHandler("2.4.0"))
+	juju := httptest.NewTLSServer(mux)
+	defer juju.Close()
+	jujuURL := it.MustParseURL(t, juju.URL)
+	proxy := httptest.NewServer(server.New(server.Params{
+		ControllerAddr: jujuURL.Host,
+		GUIURL:         it.MustParseURL(t, "http://1.2.3.4/"),
+		JujuVersion:    "2.3.1",
+	}))
+	defer proxy.Close()
+	proxyURL := it.MustParseURL(t, proxy.URL)
+
+	// The detected version is initially used.
+	testGUIConfig(proxyURL, `"jujuCoreVersion": "2.3.1"`)(c)
+
+	// Log in to the controller.
+	socketURL := strings.Replace(proxy.URL, "http://", "ws://", 1) + fmt.Sprintf("/controller/?controller=%s", jujuURL.Host)
+	conn, _, err := websocket.DefaultDialer.Dial(socketURL, nil)
+	c.Assert(err, qt.Equals, nil)
+	defer conn.Close()
+	err = conn.WriteJSON(map[string]interface{}{
+		"request-id": 1,
+		"type":       "Admin",
+		"version":    3,
+		"request":    "Login",
+	})
+	c.Assert(err, qt.Equals, nil)
+	var resp map[string]interface{}
+	err = conn.ReadJSON(&resp)
+	c.Assert(err, qt.Equals, nil)
+
+	// The version reported by Juju is now used.
+	testGUIConfig(proxyURL, `"jujuCoreVersion": "2.4.0"`)(c)
+}
+
+func TestConfig(t *testing.T) {
+	c := qt.New(t)
+	cfg := server.Config(server.Params{
+		ControllerAddr: "1.2.3.4:17070",
+		GUIConfig:      map[string]interface{}{"gisf": true},
+		JujuVersion:    "2.3.1",
+		ShellURL:       "wss://shell.example.com/ws/",
+	}, "localhost:8042")
+	for _, fragment := range []string{
+		`"apiAddress": "1.2.3.4:17070"`,
+		fmt.Sprintf(`"controllerSocketTemplate": %s`, jsonMarshalString(server.ControllerSrcTemplate)),
+		`"jujuCoreVersion": "2.3.1"`,
+		`"gisf": true`,
+		`"jujushellURL": "ws://localhost:8042/shell/"`,
+	} {
+		if !strings.Contains(cfg, fragment) {
+			c.Fatalf("invalid GUI config: %q not included in %q", fragment, cfg)
+		}
+	}
+}
+
+// loginHandler returns a WebSocket handler responding to RPC requests with
+// login responses including the given server version.
+func loginHandler(version string) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		upgrader := websocket.Upgrader{}
+		conn, err := upgrader.Upgrade(w, req, nil)
+		if err != nil {
+			panic(err)
+		}
+		defer conn.Close()
+		for {
+			var msg struct {
+				RequestID int `json:"request-id"`
+			}
+			if err := conn.ReadJSON(&msg); err != nil {
+				return
+			}
+			err := conn.WriteJSON(map[string]interface{}{
+				"request-id": msg.RequestID,
+				"response": map[string]interface{}{
+					"server-version": version,
+				},
+			})
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+func TestWebSocketTargets(t *testing.T) {
+	c := qt.New(t)
+	defer c.Cleanup()
+	// Set up test servers.
+	juju := httptest.NewTLSServer(newJujuServer())
+	defer juju.Close()
+	jujuURL := it.MustParseURL(t, juju.URL)
+	proxy := httptest.NewServer(server.New(server.Params{
+		ControllerAddr: "1.2.3.4:17070",
+		GUIURL:         it.MustParseURL(t, "http://1.2.3.4/"),
+		AllowedHosts:   []string{"127.0.0.*"},
+	}))
+	defer proxy.Close()
+	socketURL := strings.Replace(proxy.URL, "http://", "ws://", 1)
+
+	tests := []struct {
+		about          string
+		path           string
+		expectedStatus int
+	}{{
+		about:          "missing query",
+		path:           "/model/?uuid=uuid",
+		expectedStatus: http.StatusBadRequest,
+	}, {
+		about:          "host not allowed",
+		path:           "/model/?model=evil.com:443&uuid=uuid",
+		expectedStatus: http.StatusForbidden,
+	}, {
+		about:          "host smuggled in user info",
+		path:           "/controller/?controller=" + url.QueryEscape("127.0.0.1:443@evil.com"),
+		expectedStatus: http.StatusForbidden,
+	}, {
+		about:          "allowed host",
+		path:           fmt.Sprintf("/model/?model=%s&uuid=uuid", jujuURL.Host),
+		expectedStatus: http.StatusSwitchingProtocols,
+	}}
+	for _, test := range tests {
+		c.Run(test.about, func(c *qt.C) {
+			conn, resp, err := websocket.DefaultDialer.Dial(socketURL+test.path, nil)
+			if conn != nil {
+				conn.Close()
+			}
+			if test.expectedStatus == http.StatusSwitchingProtocols {
+				c.Assert(err, qt.Equals, nil)
+			} else {
+				c.Assert(err, qt.Equals, websocket.ErrBadHandshake)
+			}
+			c.Assert(resp.StatusCode, qt.Equals, test.expectedStatus)
+		})
+	}
+}
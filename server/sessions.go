@@ -0,0 +1,103 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/juju/guiproxy/wsproxy"
+)
+
+// sessionsPath holds the path on which the list of open WebSocket sessions is
+// served.
+const sessionsPath = "/_guiproxy/sessions"
+
+// sessions keeps track of the open WebSocket sessions.
+type sessions struct {
+	mu     sync.Mutex
+	lastID int
+	open   map[int]*session
+}
+
+// newSessions returns a new empty sessions registry.
+func newSessions() *sessions {
+	return &sessions{
+		open: make(map[int]*session),
+	}
+}
+
+// session holds information about a proxied WebSocket connection.
+type session struct {
+	id         int
+	endpoint   string
+	remoteAddr string
+	target     string
+	started    time.Time
+	stats      wsproxy.Stats
+}
+
+// newID returns a new unique connection identifier.
+func (s *sessions) newID() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastID++
+	return s.lastID
+}
+
+// add registers the given open session.
+func (s *sessions) add(sess *session) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.open[sess.id] = sess
+}
+
+// remove unregisters the session with the given id.
+func (s *sessions) remove(id int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.open, id)
+}
+
+// sessionInfo holds the JSON representation of an open session.
+type sessionInfo struct {
+	ID         int       `json:"id"`
+	Endpoint   string    `json:"endpoint"`
+	RemoteAddr string    `json:"remote-addr"`
+	Target     string    `json:"target"`
+	Started    time.Time `json:"started"`
+	GUIFrames  int       `json:"gui-frames"`
+	JujuFrames int       `json:"juju-frames"`
+}
+
+// list returns information about the open sessions, sorted by id.
+func (s *sessions) list() []sessionInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	infos := make([]sessionInfo, 0, len(s.open))
+	for _, sess := range s.open {
+		jujuFrames, guiFrames := sess.stats.Frames()
+		infos = append(infos, sessionInfo{
+			ID:         sess.id,
+			Endpoint:   sess.endpoint,
+			RemoteAddr: sess.remoteAddr,
+			Target:     sess.target,
+			Started:    sess.started,
+			GUIFrames:  guiFrames,
+			JujuFrames: jujuFrames,
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].ID < infos[j].ID
+	})
+	return infos
+}
+
+// ServeHTTP implements http.Handler by serving the open sessions as JSON.
+func (s *sessions) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(s.list())
+}
@@ -0,0 +1,41 @@
+package server
+
+import "sync"
+
+// newVersionTracker returns a version tracker with the given initial Juju
+// version. If the given version is empty, the default version for Juju 2 or
+// Juju 1 is used, depending on legacyJuju.
+func newVersionTracker(version string, legacyJuju bool) *versionTracker {
+	if version == "" {
+		version = jujuVersion
+		if legacyJuju {
+			version = legacyJujuVersion
+		}
+	}
+	return &versionTracker{
+		version: version,
+	}
+}
+
+// versionTracker keeps track of the Juju version declared in the GUI
+// configuration file, updated with the one reported by Juju when the GUI logs
+// in.
+type versionTracker struct {
+	mu      sync.Mutex
+	version string
+}
+
+// get returns the current Juju version.
+func (v *versionTracker) get() string {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.version
+}
+
+// set records the given Juju version, as reported in login responses. It is
+// used as wsproxy.Params.OnServerVersion.
+func (v *versionTracker) set(version string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.version = version
+}
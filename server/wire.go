@@ -0,0 +1,78 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"sync/atomic"
+)
+
+// wireCounter counts the bytes read and written on network connections.
+type wireCounter struct {
+	read, written int64
+}
+
+// dial opens a TCP connection to the given address. The returned connection
+// updates the counter when reading and writing.
+func (c *wireCounter) dial(network, addr string) (net.Conn, error) {
+	conn, err := net.Dial(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	return &countingConn{
+		Conn:    conn,
+		counter: c,
+	}, nil
+}
+
+// bytes returns the number of bytes read and written.
+func (c *wireCounter) bytes() (read, written int64) {
+	return atomic.LoadInt64(&c.read), atomic.LoadInt64(&c.written)
+}
+
+// countingConn is a net.Conn updating a wire counter.
+type countingConn struct {
+	net.Conn
+	counter *wireCounter
+}
+
+// Read implements net.Conn.Read.
+func (c *countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	atomic.AddInt64(&c.counter.read, int64(n))
+	return n, err
+}
+
+// Write implements net.Conn.Write.
+func (c *countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	atomic.AddInt64(&c.counter.written, int64(n))
+	return n, err
+}
+
+// compressionReport returns a message describing the traffic exchanged with
+// the remote server, comparing the given payload bytes with the bytes
+// actually transferred over the network.
+func compressionReport(payloadIn, payloadOut, wireIn, wireOut int64) string {
+	return fmt.Sprintf("received %s (%s on the wire), sent %s (%s on the wire), compression ratio %s",
+		formatBytes(payloadIn), formatBytes(wireIn), formatBytes(payloadOut), formatBytes(wireOut),
+		ratio(payloadIn+payloadOut, wireIn+wireOut))
+}
+
+// ratio returns the ratio between the given payload and wire sizes.
+func ratio(payload, wire int64) string {
+	if wire == 0 {
+		return "n/a"
+	}
+	return fmt.Sprintf("%.2f", float64(payload)/float64(wire))
+}
+
+// formatBytes returns a human readable representation of the given size.
+func formatBytes(n int64) string {
+	switch {
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MiB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KiB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%d B", n)
+}
@@ -0,0 +1,43 @@
+package server_test
+
+import (
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/juju/guiproxy/server"
+)
+
+var compressionReportTests = []struct {
+	about                 string
+	payloadIn, payloadOut int64
+	wireIn, wireOut       int64
+	expected              string
+}{{
+	about:    "no traffic",
+	expected: "received 0 B (0 B on the wire), sent 0 B (0 B on the wire), compression ratio n/a",
+}, {
+	about:      "compressed traffic",
+	payloadIn:  4 << 20,
+	payloadOut: 2048,
+	wireIn:     1 << 20,
+	wireOut:    1024,
+	expected:   "received 4.0 MiB (1.0 MiB on the wire), sent 2.0 KiB (1.0 KiB on the wire), compression ratio 4.00",
+}, {
+	about:      "small messages",
+	payloadIn:  100,
+	payloadOut: 100,
+	wireIn:     150,
+	wireOut:    250,
+	expected:   "received 100 B (150 B on the wire), sent 100 B (250 B on the wire), compression ratio 0.50",
+}}
+
+func TestCompressionReport(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range compressionReportTests {
+		c.Run(test.about, func(c *qt.C) {
+			report := server.CompressionReport(test.payloadIn, test.payloadOut, test.wireIn, test.wireOut)
+			c.Assert(report, qt.Equals, test.expected)
+		})
+	}
+}
@@ -0,0 +1,7 @@
+package throttle
+
+var (
+	RandFloat64 = &randFloat64
+	Sleep       = &sleep
+	TimeNow     = &timeNow
+)
@@ -0,0 +1,66 @@
+package throttle
+
+import "net/http"
+
+// Handler returns an HTTP handler emulating the network conditions described
+// by the given profile around the given handler. Requests are delayed by the
+// time taken to send them over the link, and responses are delayed by the
+// link latency and written at the profile bandwidth. The given handler is
+// returned unchanged if the profile is nil.
+func Handler(h http.Handler, p *Profile) http.Handler {
+	if p == nil {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		size := 0
+		if req.ContentLength > 0 {
+			size = int(req.ContentLength)
+		}
+		p.Sleep(size)
+		h.ServeHTTP(&responseWriter{
+			ResponseWriter: w,
+			profile:        p,
+		}, req)
+	})
+}
+
+// responseWriter is an http.ResponseWriter throttling responses.
+type responseWriter struct {
+	http.ResponseWriter
+	profile *Profile
+	started bool
+}
+
+// WriteHeader implements http.ResponseWriter.WriteHeader.
+func (w *responseWriter) WriteHeader(code int) {
+	w.start()
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// Write implements http.ResponseWriter.Write.
+func (w *responseWriter) Write(b []byte) (int, error) {
+	w.start()
+	if d := w.profile.Transfer(len(b)); d > 0 {
+		sleep(d)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush implements http.Flusher, so that streamed responses are throttled
+// as they are sent.
+func (w *responseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// start delays the beginning of the response by the link latency.
+func (w *responseWriter) start() {
+	if w.started {
+		return
+	}
+	w.started = true
+	if d := w.profile.latency(); d > 0 {
+		sleep(d)
+	}
+}
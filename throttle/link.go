@@ -0,0 +1,59 @@
+package throttle
+
+import (
+	"sync"
+	"time"
+)
+
+// NewLink returns a link carrying messages in a single direction under the
+// network conditions described by the given profile. A nil profile returns a
+// nil link, which delivers messages immediately.
+func NewLink(p *Profile) *Link {
+	if p == nil {
+		return nil
+	}
+	return &Link{
+		profile: p,
+	}
+}
+
+// Link schedules the delivery of messages sent in a single direction. As on
+// a real link, transfers are serialized according to the bandwidth, while
+// latency applies to each message independently, so that a burst of messages
+// is delayed by about one latency. Messages are delivered in order.
+type Link struct {
+	profile *Profile
+
+	mu sync.Mutex
+	// busyUntil holds the time at which the link finishes transferring the
+	// messages already scheduled.
+	busyUntil time.Time
+	// lastDelivery holds the delivery time of the last scheduled message.
+	lastDelivery time.Time
+}
+
+// Schedule returns the time at which a message of n bytes sent now is
+// delivered.
+func (l *Link) Schedule(n int) time.Time {
+	now := timeNow()
+	if l == nil {
+		return now
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	start := now
+	if l.busyUntil.After(start) {
+		start = l.busyUntil
+	}
+	l.busyUntil = start.Add(l.profile.Transfer(n))
+	delivery := l.busyUntil.Add(l.profile.latency())
+	if delivery.Before(l.lastDelivery) {
+		// Jitter cannot reorder messages.
+		delivery = l.lastDelivery
+	}
+	l.lastDelivery = delivery
+	return delivery
+}
+
+// timeNow is defined as a variable for testing purposes.
+var timeNow = time.Now
@@ -0,0 +1,56 @@
+package throttle_test
+
+import (
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/juju/guiproxy/throttle"
+)
+
+func TestLink(t *testing.T) {
+	c := qt.New(t)
+	defer c.Cleanup()
+	now := time.Date(2018, 1, 18, 10, 0, 0, 0, time.UTC)
+	c.Patch(throttle.TimeNow, func() time.Time {
+		return now
+	})
+	rand := 0.5
+	c.Patch(throttle.RandFloat64, func() float64 {
+		return rand
+	})
+	l := throttle.NewLink(&throttle.Profile{
+		// One byte per millisecond.
+		Bandwidth: 1000,
+		Latency:   100 * time.Millisecond,
+		Jitter:    50 * time.Millisecond,
+	})
+
+	// A burst of messages is delayed by about one latency, while transfers
+	// are serialized.
+	c.Assert(l.Schedule(10), qt.DeepEquals, now.Add(110*time.Millisecond))
+	c.Assert(l.Schedule(10), qt.DeepEquals, now.Add(120*time.Millisecond))
+	c.Assert(l.Schedule(10), qt.DeepEquals, now.Add(130*time.Millisecond))
+
+	// Jitter does not reorder messages.
+	rand = 0
+	c.Assert(l.Schedule(0), qt.DeepEquals, now.Add(130*time.Millisecond))
+
+	// Once the link is idle, only the latency and transfer time apply.
+	now = now.Add(time.Second)
+	rand = 1
+	c.Assert(l.Schedule(20), qt.DeepEquals, now.Add(170*time.Millisecond))
+}
+
+func TestNilLink(t *testing.T) {
+	c := qt.New(t)
+	defer c.Cleanup()
+	now := time.Date(2018, 1, 18, 10, 0, 0, 0, time.UTC)
+	c.Patch(throttle.TimeNow, func() time.Time {
+		return now
+	})
+	l := throttle.NewLink(nil)
+	c.Assert(l, qt.IsNil)
+	c.Assert(l.Schedule(1000), qt.DeepEquals, now)
+}
@@ -0,0 +1,266 @@
+// Package throttle emulates network conditions, like limited bandwidth,
+// latency, jitter and packet loss, on proxied traffic.
+package throttle
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Profile describes the network conditions to emulate.
+type Profile struct {
+	// Bandwidth holds the link bandwidth in bytes per second. Zero means
+	// unlimited bandwidth.
+	Bandwidth int64
+
+	// Latency holds the one way latency of the link.
+	Latency time.Duration
+
+	// Jitter holds the maximum random variation applied to the latency.
+	Jitter time.Duration
+
+	// Loss holds the packet loss probability, between 0 and 1. Since proxied
+	// traffic goes over TCP, lost packets are emulated as retransmissions
+	// delaying data rather than dropping it.
+	Loss float64
+}
+
+// Profiles holds the predefined network condition profiles.
+var Profiles = map[string]Profile{
+	"2g": {
+		Bandwidth: 250 * kbit,
+		Latency:   300 * time.Millisecond,
+		Jitter:    50 * time.Millisecond,
+	},
+	"3g": {
+		Bandwidth: 750 * kbit,
+		Latency:   100 * time.Millisecond,
+		Jitter:    20 * time.Millisecond,
+	},
+	"slow-3g": {
+		Bandwidth: 400 * kbit,
+		Latency:   200 * time.Millisecond,
+		Jitter:    50 * time.Millisecond,
+		Loss:      0.01,
+	},
+	"dsl": {
+		Bandwidth: 2 * mbit,
+		Latency:   25 * time.Millisecond,
+		Jitter:    5 * time.Millisecond,
+	},
+	"slow-vpn": {
+		Bandwidth: 1 * mbit,
+		Latency:   150 * time.Millisecond,
+		Jitter:    50 * time.Millisecond,
+		Loss:      0.01,
+	},
+	"lossy": {
+		Bandwidth: 10 * mbit,
+		Latency:   50 * time.Millisecond,
+		Jitter:    10 * time.Millisecond,
+		Loss:      0.05,
+	},
+}
+
+// ProfileNames returns the sorted names of the predefined profiles.
+func ProfileNames() []string {
+	names := make([]string, 0, len(Profiles))
+	for name := range Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+const (
+	kbit = 1000 / 8
+	mbit = 1000 * kbit
+	gbit = 1000 * mbit
+)
+
+// Parse returns the profile described by the given string. The string is a
+// comma separated list starting with an optional predefined profile name,
+// followed by "key=value" settings overriding or defining the profile, for
+// instance "3g", "slow-vpn,loss=5%" or
+// "bandwidth=2mbit,latency=100ms,jitter=10ms,loss=0.5%".
+// Bandwidth can be expressed in bit/s (using the "kbit", "mbit" and "gbit"
+// suffixes) or bytes/s (using no suffix or the "kb" and "mb" suffixes).
+// A nil profile is returned if the given string is empty.
+func Parse(s string) (*Profile, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var p Profile
+	for i, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) == 1 {
+			profile, ok := Profiles[part]
+			if i != 0 || !ok {
+				return nil, fmt.Errorf("invalid throttling profile %q: expected one of %s, or key=value settings", part, strings.Join(ProfileNames(), ", "))
+			}
+			p = profile
+			continue
+		}
+		key, value := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+		var err error
+		switch key {
+		case "bandwidth":
+			p.Bandwidth, err = parseBandwidth(value)
+		case "latency":
+			p.Latency, err = time.ParseDuration(value)
+		case "jitter":
+			p.Jitter, err = time.ParseDuration(value)
+		case "loss":
+			p.Loss, err = parseLoss(value)
+		default:
+			err = fmt.Errorf("unknown setting")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid throttling setting %q: %s", part, err)
+		}
+	}
+	return &p, nil
+}
+
+// parseBandwidth parses the given bandwidth string and returns the bandwidth
+// in bytes per second.
+func parseBandwidth(s string) (int64, error) {
+	units := []struct {
+		suffix string
+		size   int64
+	}{
+		{"gbit", gbit},
+		{"mbit", mbit},
+		{"kbit", kbit},
+		{"mb", 1000 * 1000},
+		{"kb", 1000},
+	}
+	mult := int64(1)
+	for _, unit := range units {
+		if strings.HasSuffix(s, unit.suffix) {
+			s, mult = strings.TrimSuffix(s, unit.suffix), unit.size
+			break
+		}
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("invalid bandwidth")
+	}
+	return int64(v * float64(mult)), nil
+}
+
+// parseLoss parses the given packet loss string, expressed as a percentage
+// (like "1%") or as a probability (like "0.01").
+func parseLoss(s string) (float64, error) {
+	percent := strings.HasSuffix(s, "%")
+	v, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid packet loss")
+	}
+	if percent {
+		v /= 100
+	}
+	if v < 0 || v > 1 {
+		return 0, fmt.Errorf("packet loss out of range")
+	}
+	return v, nil
+}
+
+// String implements fmt.Stringer by describing the profile.
+func (p *Profile) String() string {
+	bandwidth := "unlimited"
+	if p.Bandwidth > 0 {
+		bandwidth = fmt.Sprintf("%g kbit/s", float64(p.Bandwidth)/kbit)
+	}
+	return fmt.Sprintf("bandwidth %s, latency %s, jitter %s, loss %g%%", bandwidth, p.Latency, p.Jitter, p.Loss*100)
+}
+
+// Delay returns the time taken to deliver a message of n bytes over the link,
+// including latency, jitter, transfer time and retransmissions.
+func (p *Profile) Delay(n int) time.Duration {
+	if p == nil {
+		return 0
+	}
+	return p.latency() + p.Transfer(n)
+}
+
+// Transfer returns the time taken to transfer n bytes over the link once the
+// connection is established, including retransmissions but not latency.
+func (p *Profile) Transfer(n int) time.Duration {
+	if p == nil {
+		return 0
+	}
+	var d time.Duration
+	if p.Bandwidth > 0 {
+		d = time.Duration(float64(n) / float64(p.Bandwidth) * float64(time.Second))
+	}
+	if p.Loss > 0 {
+		packets := (n + packetSize - 1) / packetSize
+		if packets == 0 {
+			packets = 1
+		}
+		for i := 0; i < packets; i++ {
+			if randFloat64() < p.Loss {
+				d += p.retransmission()
+			}
+		}
+	}
+	return d
+}
+
+// packetSize holds the payload size of emulated TCP packets.
+const packetSize = 1460
+
+// minRetransmission holds the minimum TCP retransmission timeout.
+const minRetransmission = 200 * time.Millisecond
+
+// retransmission returns the time required to retransmit a lost packet.
+func (p *Profile) retransmission() time.Duration {
+	rto := 2 * p.Latency
+	if rto < minRetransmission {
+		rto = minRetransmission
+	}
+	return rto + p.Latency
+}
+
+// latency returns the link latency with jitter applied.
+func (p *Profile) latency() time.Duration {
+	d := p.Latency
+	if p.Jitter > 0 {
+		d += time.Duration((randFloat64()*2 - 1) * float64(p.Jitter))
+	}
+	if d < 0 {
+		return 0
+	}
+	return d
+}
+
+// Sleep sleeps for the time taken to deliver a message of n bytes.
+func (p *Profile) Sleep(n int) {
+	if d := p.Delay(n); d > 0 {
+		sleep(d)
+	}
+}
+
+// randFloat64 and sleep are defined as variables for testing purposes.
+var (
+	randFloat64 = lockedRand()
+	sleep       = time.Sleep
+)
+
+// lockedRand returns a goroutine safe function returning random numbers.
+func lockedRand() func() float64 {
+	var mu sync.Mutex
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	return func() float64 {
+		mu.Lock()
+		defer mu.Unlock()
+		return r.Float64()
+	}
+}
@@ -0,0 +1,204 @@
+package throttle_test
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/juju/guiproxy/throttle"
+)
+
+var parseTests = []struct {
+	about           string
+	s               string
+	expectedProfile *throttle.Profile
+	expectedError   string
+}{{
+	about: "empty",
+}, {
+	about: "predefined profile",
+	s:     "3g",
+	expectedProfile: &throttle.Profile{
+		Bandwidth: 93750,
+		Latency:   100 * time.Millisecond,
+		Jitter:    20 * time.Millisecond,
+	},
+}, {
+	about: "predefined profile with overrides",
+	s:     "slow-vpn, loss=5%, latency=1s",
+	expectedProfile: &throttle.Profile{
+		Bandwidth: 125000,
+		Latency:   time.Second,
+		Jitter:    50 * time.Millisecond,
+		Loss:      0.05,
+	},
+}, {
+	about: "custom profile",
+	s:     "bandwidth=2mbit,latency=100ms,jitter=10ms,loss=0.005",
+	expectedProfile: &throttle.Profile{
+		Bandwidth: 250000,
+		Latency:   100 * time.Millisecond,
+		Jitter:    10 * time.Millisecond,
+		Loss:      0.005,
+	},
+}, {
+	about: "bandwidth in bytes",
+	s:     "bandwidth=1.5kb",
+	expectedProfile: &throttle.Profile{
+		Bandwidth: 1500,
+	},
+}, {
+	about: "bandwidth in bytes without suffix",
+	s:     "bandwidth=42",
+	expectedProfile: &throttle.Profile{
+		Bandwidth: 42,
+	},
+}, {
+	about:         "unknown profile",
+	s:             "5g",
+	expectedError: `invalid throttling profile "5g": expected one of 2g, 3g, dsl, lossy, slow-3g, slow-vpn, or key=value settings`,
+}, {
+	about:         "profile name not first",
+	s:             "latency=1s,3g",
+	expectedError: `invalid throttling profile "3g": .*`,
+}, {
+	about:         "unknown setting",
+	s:             "speed=42",
+	expectedError: `invalid throttling setting "speed=42": unknown setting`,
+}, {
+	about:         "invalid bandwidth",
+	s:             "bandwidth=fast",
+	expectedError: `invalid throttling setting "bandwidth=fast": invalid bandwidth`,
+}, {
+	about:         "invalid latency",
+	s:             "latency=42",
+	expectedError: `invalid throttling setting "latency=42": .*`,
+}, {
+	about:         "loss out of range",
+	s:             "loss=200%",
+	expectedError: `invalid throttling setting "loss=200%": packet loss out of range`,
+}}
+
+func TestParse(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range parseTests {
+		c.Run(test.about, func(c *qt.C) {
+			p, err := throttle.Parse(test.s)
+			if test.expectedError != "" {
+				c.Assert(err, qt.ErrorMatches, test.expectedError)
+				c.Assert(p, qt.IsNil)
+				return
+			}
+			c.Assert(err, qt.Equals, nil)
+			c.Assert(p, qt.DeepEquals, test.expectedProfile)
+		})
+	}
+}
+
+func TestProfileString(t *testing.T) {
+	c := qt.New(t)
+	p := throttle.Profiles["slow-vpn"]
+	c.Assert(p.String(), qt.Equals, "bandwidth 1000 kbit/s, latency 150ms, jitter 50ms, loss 1%")
+	c.Assert((&throttle.Profile{}).String(), qt.Equals, "bandwidth unlimited, latency 0s, jitter 0s, loss 0%")
+}
+
+var delayTests = []struct {
+	about    string
+	profile  *throttle.Profile
+	n        int
+	rand     float64
+	expected time.Duration
+}{{
+	about: "nil profile",
+	n:     1000,
+}, {
+	about: "bandwidth and latency",
+	profile: &throttle.Profile{
+		Bandwidth: 1000,
+		Latency:   100 * time.Millisecond,
+	},
+	n:        500,
+	expected: 600 * time.Millisecond,
+}, {
+	about: "jitter",
+	profile: &throttle.Profile{
+		Latency: 100 * time.Millisecond,
+		Jitter:  50 * time.Millisecond,
+	},
+	rand:     0,
+	expected: 50 * time.Millisecond,
+}, {
+	about: "packet loss",
+	profile: &throttle.Profile{
+		Latency: 10 * time.Millisecond,
+		Loss:    0.5,
+	},
+	n:    3000,
+	rand: 0.1,
+	// Three packets are all retransmitted.
+	expected: 10*time.Millisecond + 3*210*time.Millisecond,
+}, {
+	about: "no packet loss",
+	profile: &throttle.Profile{
+		Latency: 10 * time.Millisecond,
+		Loss:    0.5,
+	},
+	n:        3000,
+	rand:     0.6,
+	expected: 10 * time.Millisecond,
+}}
+
+func TestDelay(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range delayTests {
+		c.Run(test.about, func(c *qt.C) {
+			defer c.Cleanup()
+			c.Patch(throttle.RandFloat64, func() float64 {
+				return test.rand
+			})
+			c.Assert(test.profile.Delay(test.n), qt.Equals, test.expected)
+		})
+	}
+}
+
+func TestHandler(t *testing.T) {
+	c := qt.New(t)
+	defer c.Cleanup()
+	var slept []time.Duration
+	c.Patch(throttle.Sleep, func(d time.Duration) {
+		slept = append(slept, d)
+	})
+	h := throttle.Handler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		io.WriteString(w, strings.Repeat("x", 2000))
+	}), &throttle.Profile{
+		Bandwidth: 1000,
+		Latency:   100 * time.Millisecond,
+	})
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	resp, err := http.Post(srv.URL, "text/plain", strings.NewReader(strings.Repeat("y", 500)))
+	c.Assert(err, qt.Equals, nil)
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(b, qt.HasLen, 2000)
+	// The request, the response latency and the response body are delayed.
+	c.Assert(slept, qt.DeepEquals, []time.Duration{
+		600 * time.Millisecond,
+		100 * time.Millisecond,
+		2 * time.Second,
+	})
+}
+
+func TestHandlerNilProfile(t *testing.T) {
+	c := qt.New(t)
+	h := http.NewServeMux()
+	c.Assert(throttle.Handler(h, nil), qt.Equals, h)
+}
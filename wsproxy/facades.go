@@ -0,0 +1,100 @@
+package wsproxy
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"github.com/juju/guiproxy/internal/jsonpath"
+)
+
+// Paths used to access facade information in RPC messages. Paths are matched
+// case insensitively, so that Juju 1 messages are also supported.
+var (
+	facadesPath  = jsonpath.Path{"response", "facades"}
+	namePath     = jsonpath.Path{"name"}
+	versionsPath = jsonpath.Path{"versions"}
+	typePath     = jsonpath.Path{"type"}
+	versionPath  = jsonpath.Path{"version"}
+)
+
+// NewFacadeVersions returns a transformer overriding the facade versions
+// advertised by Juju in Admin.Login responses. The given versions map facade
+// names to the only version to advertise for that facade: a zero version
+// removes the facade from the advertised ones, as if it was not supported.
+// If rewriteRequests is true, the version included in subsequent requests to
+// the overridden facades is also rewritten.
+func NewFacadeVersions(versions map[string]int, rewriteRequests bool) *FacadeVersions {
+	return &FacadeVersions{
+		versions:        versions,
+		rewriteRequests: rewriteRequests,
+	}
+}
+
+// FacadeVersions implements Transformer by overriding facade versions.
+type FacadeVersions struct {
+	versions        map[string]int
+	rewriteRequests bool
+}
+
+// Transform implements Transformer by changing facade versions in login
+// responses and, if required, in requests.
+func (fv *FacadeVersions) Transform(f *Frame) bool {
+	if f.Request {
+		return fv.rewriteRequest(f)
+	}
+	if f.Method != "Admin.Login" {
+		return false
+	}
+	changed := false
+	facadesPath.Replace(f.Message, func(old interface{}) interface{} {
+		facades, ok := old.([]interface{})
+		if !ok {
+			return old
+		}
+		result := make([]interface{}, 0, len(facades))
+		for _, facade := range facades {
+			version, ok := fv.versions[stringValue(namePath, facade)]
+			if !ok {
+				result = append(result, facade)
+				continue
+			}
+			changed = true
+			if version == 0 {
+				continue
+			}
+			versionsPath.Set(facade, []interface{}{versionNumber(version)})
+			result = append(result, facade)
+		}
+		return result
+	})
+	return changed
+}
+
+// rewriteRequest rewrites the facade version in the given request frame, and
+// reports whether the frame has been changed.
+func (fv *FacadeVersions) rewriteRequest(f *Frame) bool {
+	if !fv.rewriteRequests {
+		return false
+	}
+	version := fv.versions[stringValue(typePath, f.Message)]
+	if version == 0 {
+		return false
+	}
+	return versionPath.Set(f.Message, versionNumber(version))
+}
+
+// stringValue returns the string value at the given path in the given decoded
+// JSON document, or an empty string if the value is not present.
+func stringValue(p jsonpath.Path, doc interface{}) string {
+	for _, v := range p.Get(doc) {
+		if s, ok := v.(string); ok {
+			return s
+		}
+	}
+	return ""
+}
+
+// versionNumber returns the given version as a JSON number.
+func versionNumber(version int) json.Number {
+	return json.Number(strconv.Itoa(version))
+}
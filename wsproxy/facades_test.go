@@ -0,0 +1,104 @@
+package wsproxy_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/juju/guiproxy/wsproxy"
+)
+
+var facadeVersionsTests = []struct {
+	about           string
+	versions        map[string]int
+	rewriteRequests bool
+	method          string
+	request         bool
+	msg             string
+	expectedChanged bool
+	expectedMsg     string
+}{{
+	about:           "login response",
+	versions:        map[string]int{"Client": 1, "Application": 0},
+	method:          "Admin.Login",
+	msg:             `{"request-id": 1, "response": {"facades": [{"name": "Client", "versions": [1, 2, 3]}, {"name": "Application", "versions": [1]}, {"name": "Pinger", "versions": [1]}]}}`,
+	expectedChanged: true,
+	expectedMsg:     `{"request-id":1,"response":{"facades":[{"name":"Client","versions":[1]},{"name":"Pinger","versions":[1]}]}}`,
+}, {
+	about:           "legacy login response",
+	versions:        map[string]int{"Client": 0},
+	method:          "Admin.Login",
+	msg:             `{"RequestId": 1, "Response": {"Facades": [{"Name": "Client", "Versions": [0]}, {"Name": "Pinger", "Versions": [0]}]}}`,
+	expectedChanged: true,
+	expectedMsg:     `{"RequestId":1,"Response":{"Facades":[{"Name":"Pinger","Versions":[0]}]}}`,
+}, {
+	about:       "login response without overridden facades",
+	versions:    map[string]int{"Client": 1},
+	method:      "Admin.Login",
+	msg:         `{"request-id": 1, "response": {"facades": [{"name": "Pinger", "versions": [1]}]}}`,
+	expectedMsg: `{"request-id":1,"response":{"facades":[{"name":"Pinger","versions":[1]}]}}`,
+}, {
+	about:       "other responses",
+	versions:    map[string]int{"Client": 1},
+	method:      "Client.FullStatus",
+	msg:         `{"request-id": 2, "response": {"facades": [{"name": "Client", "versions": [1, 2]}]}}`,
+	expectedMsg: `{"request-id":2,"response":{"facades":[{"name":"Client","versions":[1,2]}]}}`,
+}, {
+	about:       "requests not rewritten",
+	versions:    map[string]int{"Client": 1},
+	method:      "Client.FullStatus",
+	request:     true,
+	msg:         `{"request-id": 2, "type": "Client", "version": 3, "request": "FullStatus"}`,
+	expectedMsg: `{"request":"FullStatus","request-id":2,"type":"Client","version":3}`,
+}, {
+	about:           "requests rewritten",
+	versions:        map[string]int{"Client": 1},
+	rewriteRequests: true,
+	method:          "Client.FullStatus",
+	request:         true,
+	msg:             `{"request-id": 2, "type": "Client", "version": 3, "request": "FullStatus"}`,
+	expectedChanged: true,
+	expectedMsg:     `{"request":"FullStatus","request-id":2,"type":"Client","version":1}`,
+}, {
+	about:           "legacy requests rewritten",
+	versions:        map[string]int{"Client": 1},
+	rewriteRequests: true,
+	method:          "Client.FullStatus",
+	request:         true,
+	msg:             `{"RequestId": 2, "Type": "Client", "Version": 0, "Request": "FullStatus"}`,
+	expectedChanged: true,
+	expectedMsg:     `{"Request":"FullStatus","RequestId":2,"Type":"Client","Version":1}`,
+}, {
+	about:           "requests to other facades",
+	versions:        map[string]int{"Client": 1},
+	rewriteRequests: true,
+	method:          "Application.Get",
+	request:         true,
+	msg:             `{"request-id": 2, "type": "Application", "version": 3, "request": "Get"}`,
+	expectedMsg:     `{"request":"Get","request-id":2,"type":"Application","version":3}`,
+}}
+
+func TestFacadeVersions(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range facadeVersionsTests {
+		c.Run(test.about, func(c *qt.C) {
+			fv := wsproxy.NewFacadeVersions(test.versions, test.rewriteRequests)
+			var msg interface{}
+			dec := json.NewDecoder(strings.NewReader(test.msg))
+			dec.UseNumber()
+			err := dec.Decode(&msg)
+			c.Assert(err, qt.Equals, nil)
+			f := &wsproxy.Frame{
+				Method:  test.method,
+				Request: test.request,
+				Message: msg,
+			}
+			c.Assert(fv.Transform(f), qt.Equals, test.expectedChanged)
+			b, err := json.Marshal(f.Message)
+			c.Assert(err, qt.Equals, nil)
+			c.Assert(string(b), qt.Equals, test.expectedMsg)
+		})
+	}
+}
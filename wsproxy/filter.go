@@ -0,0 +1,125 @@
+package wsproxy
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// NewFilter returns a filter used to decide which WebSocket frames are logged,
+// based on the given include and exclude expressions.
+//
+// Each expression is either a "Facade.Method" pattern, in which both the
+// facade and the method can include shell style wildcards (for instance
+// "AllWatcher.*" or "Pinger.Ping"), or a regular expression enclosed in
+// slashes (for instance "/error/") that is matched against the raw frame
+// content. A pattern without a dot, like "Pinger", matches all the methods in
+// the given facade. Responses are matched using the facade and method of the
+// corresponding requests.
+//
+// A frame is logged if it matches at least one include expression (or no
+// include expressions are provided) and does not match any exclude expression.
+func NewFilter(include, exclude []string) (*Filter, error) {
+	inc, err := newMatchers(include)
+	if err != nil {
+		return nil, fmt.Errorf("invalid include expression: %s", err)
+	}
+	exc, err := newMatchers(exclude)
+	if err != nil {
+		return nil, fmt.Errorf("invalid exclude expression: %s", err)
+	}
+	return &Filter{
+		include: inc,
+		exclude: exc,
+	}, nil
+}
+
+// Filter decides whether WebSocket frames must be logged.
+type Filter struct {
+	include []matcher
+	exclude []matcher
+}
+
+// Match reports whether a frame with the given content, sent as part of a
+// call to the given "Facade.Method", must be logged. A nil filter matches all
+// frames.
+func (f *Filter) Match(method, msg string) bool {
+	if f == nil {
+		return true
+	}
+	if len(f.include) != 0 && !matchAny(f.include, method, msg) {
+		return false
+	}
+	return !matchAny(f.exclude, method, msg)
+}
+
+// matcher is implemented by filter expressions.
+type matcher interface {
+	match(method, msg string) bool
+}
+
+// newMatchers returns matchers for the given filter expressions.
+func newMatchers(exprs []string) ([]matcher, error) {
+	matchers := make([]matcher, 0, len(exprs))
+	for _, expr := range exprs {
+		m, err := newMatcher(expr)
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, m)
+	}
+	return matchers, nil
+}
+
+// newMatcher returns a matcher for the given filter expression.
+func newMatcher(expr string) (matcher, error) {
+	if len(expr) > 1 && strings.HasPrefix(expr, "/") && strings.HasSuffix(expr, "/") {
+		re, err := regexp.Compile(expr[1 : len(expr)-1])
+		if err != nil {
+			return nil, fmt.Errorf("cannot compile %q: %s", expr, err)
+		}
+		return regexpMatcher{re}, nil
+	}
+	if expr == "" {
+		return nil, fmt.Errorf("empty expression")
+	}
+	if !strings.Contains(expr, ".") {
+		expr += ".*"
+	}
+	// Validate the pattern.
+	if _, err := path.Match(expr, ""); err != nil {
+		return nil, fmt.Errorf("invalid pattern %q: %s", expr, err)
+	}
+	return methodMatcher(expr), nil
+}
+
+// methodMatcher matches frames based on the RPC facade and method.
+type methodMatcher string
+
+func (m methodMatcher) match(method, msg string) bool {
+	if method == "" {
+		return false
+	}
+	ok, _ := path.Match(string(m), method)
+	return ok
+}
+
+// regexpMatcher matches frames based on their raw content.
+type regexpMatcher struct {
+	re *regexp.Regexp
+}
+
+func (m regexpMatcher) match(method, msg string) bool {
+	return m.re.MatchString(msg)
+}
+
+// matchAny reports whether any of the given matchers matches.
+func matchAny(matchers []matcher, method, msg string) bool {
+	for _, m := range matchers {
+		if m.match(method, msg) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,103 @@
+package wsproxy_test
+
+import (
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/juju/guiproxy/wsproxy"
+)
+
+var filterTests = []struct {
+	about         string
+	include       []string
+	exclude       []string
+	method        string
+	msg           string
+	expectedMatch bool
+}{{
+	about:         "no expressions",
+	method:        "Pinger.Ping",
+	expectedMatch: true,
+}, {
+	about:         "included method",
+	include:       []string{"Pinger.Ping"},
+	method:        "Pinger.Ping",
+	expectedMatch: true,
+}, {
+	about:   "method not included",
+	include: []string{"Pinger.Ping"},
+	method:  "Client.FullStatus",
+}, {
+	about:         "included facade",
+	include:       []string{"AllWatcher"},
+	method:        "AllWatcher.Next",
+	expectedMatch: true,
+}, {
+	about:         "included wildcard",
+	include:       []string{"Client.Full*", "AllWatcher.*"},
+	method:        "Client.FullStatus",
+	expectedMatch: true,
+}, {
+	about:   "excluded wildcard",
+	exclude: []string{"*.Ping"},
+	method:  "Pinger.Ping",
+}, {
+	about:         "method not excluded",
+	exclude:       []string{"Pinger.Ping"},
+	method:        "Client.FullStatus",
+	expectedMatch: true,
+}, {
+	about:   "excluded after included",
+	include: []string{"Client"},
+	exclude: []string{"Client.FullStatus"},
+	method:  "Client.FullStatus",
+}, {
+	about:   "unknown method",
+	include: []string{"*"},
+	msg:     `{"request-id": 42}`,
+}, {
+	about:   "binary frame with include expressions",
+	include: []string{"Client.*", "/.+/"},
+}, {
+	about:         "binary frame with exclude expressions",
+	exclude:       []string{"Pinger", "/error/"},
+	expectedMatch: true,
+}, {
+	about:         "included regular expression",
+	include:       []string{`/"error":/`},
+	msg:           `{"request-id": 42, "error": "bad wolf"}`,
+	expectedMatch: true,
+}, {
+	about:   "excluded regular expression",
+	exclude: []string{`/application-\w+/`},
+	method:  "Application.Get",
+	msg:     `{"params": {"tag": "application-django"}}`,
+}}
+
+func TestFilter(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range filterTests {
+		c.Run(test.about, func(c *qt.C) {
+			f, err := wsproxy.NewFilter(test.include, test.exclude)
+			c.Assert(err, qt.Equals, nil)
+			c.Assert(f.Match(test.method, test.msg), qt.Equals, test.expectedMatch)
+		})
+	}
+}
+
+func TestNilFilter(t *testing.T) {
+	c := qt.New(t)
+	var f *wsproxy.Filter
+	c.Assert(f.Match("Pinger.Ping", "{}"), qt.Equals, true)
+}
+
+func TestNewFilterErrors(t *testing.T) {
+	c := qt.New(t)
+	_, err := wsproxy.NewFilter([]string{"/[/"}, nil)
+	c.Assert(err, qt.ErrorMatches, `invalid include expression: cannot compile "/\[/": .*`)
+	_, err = wsproxy.NewFilter(nil, []string{"Client.[", "Pinger"})
+	c.Assert(err, qt.ErrorMatches, `invalid exclude expression: invalid pattern "Client.\[": .*`)
+	_, err = wsproxy.NewFilter([]string{""}, nil)
+	c.Assert(err, qt.ErrorMatches, `invalid include expression: empty expression`)
+}
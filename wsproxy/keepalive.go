@@ -0,0 +1,130 @@
+package wsproxy
+
+import (
+	"fmt"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// LivenessError is returned by Copy when the connections are closed because
+// they are idle or one of the peers is not responding.
+type LivenessError struct {
+	// Reason holds a description of why the connections have been closed.
+	Reason string
+}
+
+// Error implements the error interface.
+func (e *LivenessError) Error() string {
+	return e.Reason
+}
+
+// writeTimeout holds the time allowed to send control frames.
+const writeTimeout = 10 * time.Second
+
+// keepAlive sets up liveness checks on the given connection: read deadlines
+// are extended when any frame, including pings and pongs, is received, and
+// pings are periodically sent until the done channel is closed.
+func (p *proxy) keepAlive(conn *websocket.Conn, done <-chan struct{}) {
+	if p.ReadTimeout > 0 {
+		p.extendDeadline(conn)
+		conn.SetPongHandler(func(string) error {
+			p.extendDeadline(conn)
+			return nil
+		})
+		conn.SetPingHandler(func(data string) error {
+			p.extendDeadline(conn)
+			err := conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(writeTimeout))
+			if e, ok := err.(net.Error); ok && e.Temporary() {
+				return nil
+			}
+			if err == websocket.ErrCloseSent {
+				return nil
+			}
+			return err
+		})
+	}
+	if p.PingInterval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(p.PingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeTimeout)); err != nil {
+					// Errors are detected and reported when reading.
+					return
+				}
+			}
+		}
+	}()
+}
+
+// extendDeadline extends the read deadline of the given connection.
+func (p *proxy) extendDeadline(conn *websocket.Conn) {
+	if p.ReadTimeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(p.ReadTimeout))
+	}
+}
+
+// touch records data activity on the connections.
+func (p *proxy) touch() {
+	atomic.StoreInt64(&p.lastActivity, time.Now().UnixNano())
+}
+
+// watchIdle sends a liveness error to the given channel when no data frames
+// are exchanged for longer than the idle timeout. It returns when the done
+// channel is closed.
+func (p *proxy) watchIdle(errCh chan<- error, done <-chan struct{}) {
+	if p.IdleTimeout <= 0 {
+		return
+	}
+	p.touch()
+	interval := p.IdleTimeout / 10
+	if interval > time.Second {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			last := time.Unix(0, atomic.LoadInt64(&p.lastActivity))
+			if time.Since(last) >= p.IdleTimeout {
+				errCh <- &LivenessError{
+					Reason: fmt.Sprintf("connection idle for more than %s", p.IdleTimeout),
+				}
+				return
+			}
+		}
+	}
+}
+
+// livenessError returns a liveness error if the given error, returned when
+// reading from the given connection, is caused by the read timeout expiring.
+// Otherwise the given error is returned.
+func (p *proxy) livenessError(conn *websocket.Conn, err error) error {
+	if e, ok := err.(net.Error); ok && e.Timeout() && p.ReadTimeout > 0 {
+		return &LivenessError{
+			Reason: fmt.Sprintf("no frames received from %s in %s", conn.RemoteAddr(), p.ReadTimeout),
+		}
+	}
+	return err
+}
+
+// closeForLiveness sends close frames with the given liveness error to the
+// given connections.
+func closeForLiveness(err *LivenessError, conns ...*websocket.Conn) {
+	data := websocket.FormatCloseMessage(websocket.CloseGoingAway, err.Reason)
+	for _, conn := range conns {
+		conn.WriteControl(websocket.CloseMessage, data, time.Now().Add(closeTimeout))
+	}
+}
@@ -0,0 +1,37 @@
+package wsproxy
+
+import "github.com/juju/guiproxy/internal/jsonpath"
+
+// macaroonsPath holds the path to the macaroons in login requests.
+var macaroonsPath = jsonpath.Path{"params", "macaroons"}
+
+// NewLoginMacaroons returns a transformer adding the given macaroon slices to
+// Admin.Login requests not already including macaroons, so that the login
+// succeeds without an interactive discharge by the client. The macaroons are
+// expected to be already discharged, for instance by "juju login": no discharge
+// is attempted, so if Juju still requires one, the discharge-required response
+// is passed through to the client, which can then perform the discharge itself.
+func NewLoginMacaroons(macaroons []interface{}) *LoginMacaroons {
+	return &LoginMacaroons{
+		macaroons: macaroons,
+	}
+}
+
+// LoginMacaroons implements Transformer by adding macaroons to login requests.
+type LoginMacaroons struct {
+	macaroons []interface{}
+}
+
+// Transform implements Transformer by adding macaroons to login requests.
+func (l *LoginMacaroons) Transform(f *Frame) bool {
+	if !f.Request || f.Method != "Admin.Login" || len(l.macaroons) == 0 {
+		return false
+	}
+	for _, v := range macaroonsPath.Get(f.Message) {
+		if ms, ok := v.([]interface{}); ok && len(ms) != 0 {
+			// The client provided its own macaroons.
+			return false
+		}
+	}
+	return macaroonsPath.Set(f.Message, cloneJSON(l.macaroons))
+}
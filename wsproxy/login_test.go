@@ -0,0 +1,86 @@
+package wsproxy_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/juju/guiproxy/wsproxy"
+)
+
+var loginMacaroonsTests = []struct {
+	about           string
+	macaroons       []interface{}
+	method          string
+	request         bool
+	msg             string
+	expectedChanged bool
+	expectedMsg     string
+}{{
+	about:           "login without macaroons",
+	macaroons:       []interface{}{[]interface{}{"m1", "d1"}},
+	method:          "Admin.Login",
+	request:         true,
+	msg:             `{"request-id": 1, "type": "Admin", "request": "Login", "params": {}}`,
+	expectedChanged: true,
+	expectedMsg:     `{"params":{"macaroons":[["m1","d1"]]},"request":"Login","request-id":1,"type":"Admin"}`,
+}, {
+	about:           "login with empty macaroons",
+	macaroons:       []interface{}{[]interface{}{"m1"}, []interface{}{"m2"}},
+	method:          "Admin.Login",
+	request:         true,
+	msg:             `{"request-id": 1, "type": "Admin", "request": "Login", "params": {"macaroons": []}}`,
+	expectedChanged: true,
+	expectedMsg:     `{"params":{"macaroons":[["m1"],["m2"]]},"request":"Login","request-id":1,"type":"Admin"}`,
+}, {
+	about:       "login with client macaroons",
+	macaroons:   []interface{}{[]interface{}{"m1"}},
+	method:      "Admin.Login",
+	request:     true,
+	msg:         `{"request-id": 1, "type": "Admin", "request": "Login", "params": {"macaroons": [["client"]]}}`,
+	expectedMsg: `{"params":{"macaroons":[["client"]]},"request":"Login","request-id":1,"type":"Admin"}`,
+}, {
+	about:       "no macaroons available",
+	method:      "Admin.Login",
+	request:     true,
+	msg:         `{"request-id": 1, "type": "Admin", "request": "Login", "params": {}}`,
+	expectedMsg: `{"params":{},"request":"Login","request-id":1,"type":"Admin"}`,
+}, {
+	about:       "login response",
+	macaroons:   []interface{}{[]interface{}{"m1"}},
+	method:      "Admin.Login",
+	msg:         `{"request-id": 1, "response": {}}`,
+	expectedMsg: `{"request-id":1,"response":{}}`,
+}, {
+	about:       "other requests",
+	macaroons:   []interface{}{[]interface{}{"m1"}},
+	method:      "Client.FullStatus",
+	request:     true,
+	msg:         `{"request-id": 2, "type": "Client", "request": "FullStatus", "params": {}}`,
+	expectedMsg: `{"params":{},"request":"FullStatus","request-id":2,"type":"Client"}`,
+}}
+
+func TestLoginMacaroons(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range loginMacaroonsTests {
+		c.Run(test.about, func(c *qt.C) {
+			l := wsproxy.NewLoginMacaroons(test.macaroons)
+			var msg interface{}
+			dec := json.NewDecoder(strings.NewReader(test.msg))
+			dec.UseNumber()
+			err := dec.Decode(&msg)
+			c.Assert(err, qt.Equals, nil)
+			f := &wsproxy.Frame{
+				Method:  test.method,
+				Request: test.request,
+				Message: msg,
+			}
+			c.Assert(l.Transform(f), qt.Equals, test.expectedChanged)
+			b, err := json.Marshal(f.Message)
+			c.Assert(err, qt.Equals, nil)
+			c.Assert(string(b), qt.Equals, test.expectedMsg)
+		})
+	}
+}
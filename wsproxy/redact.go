@@ -0,0 +1,139 @@
+package wsproxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/juju/guiproxy/internal/jsonpath"
+)
+
+// redacted holds the value used to replace sensitive information.
+const redacted = "[REDACTED]"
+
+// builtinRedactions holds the redaction rules for known sensitive Juju
+// parameters, in the same format accepted by NewRedactor.
+var builtinRedactions = []string{
+	// Login credentials and macaroons, including Juju 1 passwords.
+	"Admin.Login:params.credentials",
+	"Admin.Login:params.password",
+	"Admin.Login:params.macaroons",
+	"Admin.Login:response.discharge-required",
+	// Cloud credentials attributes, like passwords and secret keys.
+	"Cloud.AddCredentials:params.credentials.*.credential.attrs",
+	"Cloud.UpdateCredentials:params.credentials.*.credential.attrs",
+	"Cloud.UpdateCredentialsCheckModels:params.credentials.*.credential.attrs",
+	"Cloud.Credential:response.results.*.result.attrs",
+	"Cloud.CredentialContents:response.results.*.result.content.attrs",
+	// User passwords.
+	"UserManager.AddUser:params.users.*.password",
+	"UserManager.SetPassword:params.changes.*.password",
+	// SSH keys.
+	"KeyManager.AddKeys:params.ssh-keys",
+	"KeyManager.ListKeys:response.results.*.result",
+}
+
+// NewRedactor returns a redactor used to hide sensitive values in logged
+// WebSocket frames. If builtin is true, rules for known sensitive Juju
+// parameters (like login credentials and macaroons, cloud credentials,
+// passwords and SSH keys) are included. Additional rules can be provided in
+// the form "[Facade.Method:]path", where the optional method pattern has the
+// same syntax used in filter expressions, and the path is a dot separated
+// JSON path in the frame, like "params.config.*.secret". The "*" path
+// element matches any object key or array index.
+func NewRedactor(rules []string, builtin bool) (*Redactor, error) {
+	if builtin {
+		rules = append(append([]string(nil), builtinRedactions...), rules...)
+	}
+	r := &Redactor{
+		rules: make([]redactRule, 0, len(rules)),
+	}
+	for _, rule := range rules {
+		var expr string
+		parts := strings.SplitN(rule, ":", 2)
+		if len(parts) == 2 {
+			expr, parts = parts[0], parts[1:]
+		}
+		rr := redactRule{}
+		if expr != "" {
+			m, err := newMatcher(expr)
+			if err != nil {
+				return nil, fmt.Errorf("invalid redaction rule %q: %s", rule, err)
+			}
+			mm, ok := m.(methodMatcher)
+			if !ok {
+				return nil, fmt.Errorf("invalid redaction rule %q: regular expressions not allowed", rule)
+			}
+			rr.method = mm
+		}
+		p, err := jsonpath.Parse(parts[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid redaction rule %q: %s", rule, err)
+		}
+		rr.path = p
+		r.rules = append(r.rules, rr)
+	}
+	return r, nil
+}
+
+// Redactor hides sensitive information in WebSocket frames.
+type Redactor struct {
+	rules []redactRule
+}
+
+// redactRule holds a single redaction rule.
+type redactRule struct {
+	method methodMatcher
+	path   jsonpath.Path
+}
+
+// Redact returns the given frame content, sent as part of a call to the given
+// "Facade.Method", with sensitive values replaced. The content is returned
+// unchanged if the redactor is nil, no rules apply or the frame is not JSON.
+func (r *Redactor) Redact(method, msg string) string {
+	if r == nil || len(r.rules) == 0 {
+		return msg
+	}
+	doc, err := decodeJSON(msg)
+	if err != nil {
+		return msg
+	}
+	changed := false
+	for _, rule := range r.rules {
+		if rule.method != "" && !rule.method.match(method, msg) {
+			continue
+		}
+		if rule.path.Replace(doc, func(interface{}) interface{} { return redacted }) {
+			changed = true
+		}
+	}
+	if !changed {
+		return msg
+	}
+	return encodeJSON(doc)
+}
+
+// decodeJSON decodes the given JSON encoded string, preserving numbers.
+func decodeJSON(msg string) (interface{}, error) {
+	dec := json.NewDecoder(strings.NewReader(msg))
+	dec.UseNumber()
+	var doc interface{}
+	if err := dec.Decode(&doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// encodeJSON encodes the given value as a compact JSON string.
+func encodeJSON(doc interface{}) string {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(doc); err != nil {
+		// This should never happen, as the document has been decoded from
+		// JSON in the first place.
+		panic(err)
+	}
+	return strings.TrimSuffix(buf.String(), "\n")
+}
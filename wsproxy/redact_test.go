@@ -0,0 +1,98 @@
+package wsproxy_test
+
+import (
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/juju/guiproxy/wsproxy"
+)
+
+var redactTests = []struct {
+	about    string
+	rules    []string
+	builtin  bool
+	method   string
+	msg      string
+	expected string
+}{{
+	about:    "no rules",
+	method:   "Admin.Login",
+	msg:      `{"params": {"credentials": "secret"}}`,
+	expected: `{"params": {"credentials": "secret"}}`,
+}, {
+	about:    "login credentials",
+	builtin:  true,
+	method:   "Admin.Login",
+	msg:      `{"request-id": 1, "type": "Admin", "request": "Login", "version": 3, "params": {"auth-tag": "user-admin", "credentials": "secret", "macaroons": [[{"c": "d"}]]}}`,
+	expected: `{"params":{"auth-tag":"user-admin","credentials":"[REDACTED]","macaroons":"[REDACTED]"},"request":"Login","request-id":1,"type":"Admin","version":3}`,
+}, {
+	about:    "legacy login password",
+	builtin:  true,
+	method:   "Admin.Login",
+	msg:      `{"RequestId": 1, "Type": "Admin", "Request": "Login", "Params": {"AuthTag": "user-admin", "Password": "secret"}}`,
+	expected: `{"Params":{"AuthTag":"user-admin","Password":"[REDACTED]"},"Request":"Login","RequestId":1,"Type":"Admin"}`,
+}, {
+	about:    "login response",
+	builtin:  true,
+	method:   "Admin.Login",
+	msg:      `{"request-id": 1, "response": {"discharge-required": {"c": "d"}, "discharge-required-error": "need discharge"}}`,
+	expected: `{"request-id":1,"response":{"discharge-required":"[REDACTED]","discharge-required-error":"need discharge"}}`,
+}, {
+	about:    "cloud credentials",
+	builtin:  true,
+	method:   "Cloud.UpdateCredentials",
+	msg:      `{"params": {"credentials": [{"tag": "t1", "credential": {"auth-type": "userpass", "attrs": {"password": "secret"}}}, {"tag": "t2"}]}}`,
+	expected: `{"params":{"credentials":[{"credential":{"attrs":"[REDACTED]","auth-type":"userpass"},"tag":"t1"},{"tag":"t2"}]}}`,
+}, {
+	about:    "builtin rule not matching method",
+	builtin:  true,
+	method:   "Client.FullStatus",
+	msg:      `{"params": {"credentials": "not really"}}`,
+	expected: `{"params": {"credentials": "not really"}}`,
+}, {
+	about:    "custom rule for all methods",
+	rules:    []string{"params.config.*.secret"},
+	method:   "Application.Set",
+	msg:      `{"params": {"config": [{"secret": 42.0, "public": 47}]}}`,
+	expected: `{"params":{"config":[{"public":47,"secret":"[REDACTED]"}]}}`,
+}, {
+	about:    "custom rule with method pattern",
+	rules:    []string{"Application.*:params.config", ":params.other"},
+	method:   "Application.Deploy",
+	msg:      `{"params": {"config": "<secret>", "other": true}}`,
+	expected: `{"params":{"config":"[REDACTED]","other":"[REDACTED]"}}`,
+}, {
+	about:    "not JSON",
+	builtin:  true,
+	method:   "Admin.Login",
+	msg:      `these are the voyages`,
+	expected: `these are the voyages`,
+}}
+
+func TestRedact(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range redactTests {
+		c.Run(test.about, func(c *qt.C) {
+			r, err := wsproxy.NewRedactor(test.rules, test.builtin)
+			c.Assert(err, qt.Equals, nil)
+			c.Assert(r.Redact(test.method, test.msg), qt.Equals, test.expected)
+		})
+	}
+}
+
+func TestNilRedactor(t *testing.T) {
+	c := qt.New(t)
+	var r *wsproxy.Redactor
+	c.Assert(r.Redact("Admin.Login", `{"params": {"credentials": "secret"}}`), qt.Equals, `{"params": {"credentials": "secret"}}`)
+}
+
+func TestNewRedactorErrors(t *testing.T) {
+	c := qt.New(t)
+	_, err := wsproxy.NewRedactor([]string{"/Admin/:params.a"}, false)
+	c.Assert(err, qt.ErrorMatches, `invalid redaction rule "/Admin/:params.a": regular expressions not allowed`)
+	_, err = wsproxy.NewRedactor([]string{"Admin.[:params.a"}, true)
+	c.Assert(err, qt.ErrorMatches, `invalid redaction rule "Admin.\[:params.a": invalid pattern .*`)
+	_, err = wsproxy.NewRedactor([]string{"Admin.Login:"}, false)
+	c.Assert(err, qt.ErrorMatches, `invalid redaction rule "Admin.Login:": empty path`)
+}
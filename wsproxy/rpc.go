@@ -0,0 +1,88 @@
+package wsproxy
+
+import (
+	"encoding/json"
+	"sync"
+)
+
+// message holds the fields of a Juju RPC message that are relevant for the
+// proxy. Both Juju 2 and Juju 1 (legacy) message formats are supported.
+type message struct {
+	RequestID       uint64          `json:"request-id"`
+	LegacyRequestID uint64          `json:"RequestId"`
+	Type            string          `json:"type"`
+	Request         string          `json:"request"`
+	Response        json.RawMessage `json:"response"`
+}
+
+// decodeMessage decodes the given frame content as a Juju RPC message. The
+// resulting message is nil if the content is not a valid JSON object.
+func decodeMessage(data []byte) *message {
+	var m message
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil
+	}
+	return &m
+}
+
+// serverVersion returns the Juju version included in the given login response
+// frame content, or an empty string if the version cannot be found. Since
+// login responses are infrequent, the content is decoded again only for them.
+func serverVersion(data []byte) string {
+	var resp struct {
+		Response struct {
+			ServerVersion string `json:"server-version"`
+		} `json:"response"`
+	}
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return ""
+	}
+	return resp.Response.ServerVersion
+}
+
+// id returns the request id of the message.
+func (m *message) id() uint64 {
+	if m.RequestID != 0 {
+		return m.RequestID
+	}
+	return m.LegacyRequestID
+}
+
+// isRequest reports whether the message is an RPC request.
+func (m *message) isRequest() bool {
+	return m.Type != "" && m.Request != ""
+}
+
+// calls keeps track of in flight RPC calls, so that responses can be
+// associated with the facade and method of the corresponding requests.
+type calls struct {
+	mu      sync.Mutex
+	methods map[uint64]string
+}
+
+// newCalls returns a new empty calls tracker.
+func newCalls() *calls {
+	return &calls{
+		methods: make(map[uint64]string),
+	}
+}
+
+// method returns the "Facade.Method" string for the given message. Requests
+// are recorded so that their responses can be later resolved. An empty string
+// is returned if the method cannot be determined.
+func (c *calls) method(m *message) string {
+	if m == nil {
+		return ""
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	id := m.id()
+	if m.isRequest() {
+		method := m.Type + "." + m.Request
+		c.methods[id] = method
+		return method
+	}
+	method := c.methods[id]
+	delete(c.methods, id)
+	return method
+}
@@ -0,0 +1,180 @@
+package wsproxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+
+	"github.com/juju/guiproxy/internal/jsonpath"
+)
+
+// Rule holds a frame rewriting rule.
+type Rule struct {
+	// Method optionally holds a "Facade.Method" pattern, with the same
+	// syntax used in filter expressions, selecting the RPC calls the rule
+	// applies to. If empty, the rule applies to all frames.
+	Method string `json:"method,omitempty"`
+
+	// Direction optionally holds whether the rule applies to "request" or
+	// "response" frames. If empty, the rule applies to both.
+	Direction string `json:"direction,omitempty"`
+
+	// Path holds the dot separated JSON path selecting the values to change,
+	// for instance "response.facades.*.versions".
+	Path string `json:"path"`
+
+	// Action holds the change to make to the selected values:
+	//   - "set" sets the value, creating missing intermediate objects;
+	//   - "replace" replaces existing values only, optionally restricted to
+	//     the ones equal to Match;
+	//   - "delete" removes the values.
+	Action string `json:"action"`
+
+	// Value holds the new value for "set" and "replace" actions.
+	Value interface{} `json:"value,omitempty"`
+
+	// Match optionally restricts "replace" actions to values equal to it.
+	Match interface{} `json:"match,omitempty"`
+}
+
+// Actions available in rules.
+const (
+	actionSet     = "set"
+	actionReplace = "replace"
+	actionDelete  = "delete"
+)
+
+// NewRules returns a transformer rewriting frames according to the given
+// rules, applied in order.
+func NewRules(rules []Rule) (*Rules, error) {
+	r := &Rules{
+		rules: make([]rule, 0, len(rules)),
+	}
+	for i, rl := range rules {
+		compiled, err := newRule(rl)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rule %d: %s", i+1, err)
+		}
+		r.rules = append(r.rules, compiled)
+	}
+	return r, nil
+}
+
+// ReadRules reads the frame rewriting rules from the JSON file at the given
+// path, including a list of rules, and returns the corresponding transformer.
+func ReadRules(path string) (*Rules, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open rules file: %s", err)
+	}
+	defer f.Close()
+	dec := json.NewDecoder(f)
+	dec.UseNumber()
+	var rules []Rule
+	if err := dec.Decode(&rules); err != nil {
+		return nil, fmt.Errorf("cannot decode rules file %q: %s", path, err)
+	}
+	return NewRules(rules)
+}
+
+// Rules implements Transformer by rewriting frames based on rules.
+type Rules struct {
+	rules []rule
+}
+
+// Transform implements Transformer by applying all the matching rules.
+func (r *Rules) Transform(f *Frame) bool {
+	changed := false
+	for _, rl := range r.rules {
+		if rl.apply(f) {
+			changed = true
+		}
+	}
+	return changed
+}
+
+// rule holds a validated frame rewriting rule.
+type rule struct {
+	Rule
+	method methodMatcher
+	path   jsonpath.Path
+}
+
+// newRule validates the given rule and returns its compiled version.
+func newRule(r Rule) (rule, error) {
+	rl := rule{Rule: r}
+	if r.Method != "" {
+		m, err := newMatcher(r.Method)
+		if err != nil {
+			return rule{}, err
+		}
+		mm, ok := m.(methodMatcher)
+		if !ok {
+			return rule{}, fmt.Errorf("regular expressions not allowed in method %q", r.Method)
+		}
+		rl.method = mm
+	}
+	switch r.Direction {
+	case "", "request", "response":
+	default:
+		return rule{}, fmt.Errorf("invalid direction %q: must be \"request\" or \"response\"", r.Direction)
+	}
+	p, err := jsonpath.Parse(r.Path)
+	if err != nil {
+		return rule{}, err
+	}
+	rl.path = p
+	switch r.Action {
+	case actionSet, actionReplace, actionDelete:
+	default:
+		return rule{}, fmt.Errorf("invalid action %q: must be %q, %q or %q", r.Action, actionSet, actionReplace, actionDelete)
+	}
+	return rl, nil
+}
+
+// apply applies the rule to the given frame if it matches, and reports
+// whether the frame has been changed.
+func (r rule) apply(f *Frame) bool {
+	if r.method != "" && !r.method.match(f.Method, "") {
+		return false
+	}
+	if r.Direction == "request" && !f.Request || r.Direction == "response" && f.Request {
+		return false
+	}
+	switch r.Action {
+	case actionSet:
+		return r.path.Set(f.Message, cloneJSON(r.Value))
+	case actionDelete:
+		return r.path.Delete(f.Message)
+	}
+	changed := false
+	r.path.Replace(f.Message, func(old interface{}) interface{} {
+		if r.Match != nil && !reflect.DeepEqual(old, r.Match) {
+			return old
+		}
+		changed = true
+		return cloneJSON(r.Value)
+	})
+	return changed
+}
+
+// cloneJSON returns a deep copy of the given decoded JSON value, so that rule
+// values are never shared between frames.
+func cloneJSON(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, val := range v {
+			m[k] = cloneJSON(val)
+		}
+		return m
+	case []interface{}:
+		a := make([]interface{}, len(v))
+		for i, val := range v {
+			a[i] = cloneJSON(val)
+		}
+		return a
+	}
+	return v
+}
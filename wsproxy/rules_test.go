@@ -0,0 +1,289 @@
+package wsproxy_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/juju/guiproxy/wsproxy"
+)
+
+var rulesTests = []struct {
+	about           string
+	rules           []wsproxy.Rule
+	method          string
+	request         bool
+	msg             string
+	expectedChanged bool
+	expectedMsg     string
+}{{
+	about:       "no rules",
+	msg:         `{"response": {}}`,
+	expectedMsg: `{"response":{}}`,
+}, {
+	about: "set value",
+	rules: []wsproxy.Rule{{
+		Method:    "Admin.Login",
+		Direction: "response",
+		Path:      "response.server-version",
+		Action:    "set",
+		Value:     "2.42.0",
+	}},
+	method:          "Admin.Login",
+	msg:             `{"request-id": 1, "response": {}}`,
+	expectedChanged: true,
+	expectedMsg:     `{"request-id":1,"response":{"server-version":"2.42.0"}}`,
+}, {
+	about: "set value creating intermediate objects",
+	rules: []wsproxy.Rule{{
+		Path:   "response.applications.django.status",
+		Action: "set",
+		Value:  map[string]interface{}{"status": "blocked"},
+	}},
+	method:          "Client.FullStatus",
+	msg:             `{"request-id": 1, "response": {}}`,
+	expectedChanged: true,
+	expectedMsg:     `{"request-id":1,"response":{"applications":{"django":{"status":{"status":"blocked"}}}}}`,
+}, {
+	about: "method not matching",
+	rules: []wsproxy.Rule{{
+		Method: "Admin.*",
+		Path:   "response.server-version",
+		Action: "set",
+		Value:  "2.42.0",
+	}},
+	method:      "Client.FullStatus",
+	msg:         `{"request-id": 1, "response": {}}`,
+	expectedMsg: `{"request-id":1,"response":{}}`,
+}, {
+	about: "direction not matching",
+	rules: []wsproxy.Rule{{
+		Direction: "response",
+		Path:      "params.version",
+		Action:    "set",
+		Value:     4,
+	}},
+	method:      "Admin.Login",
+	request:     true,
+	msg:         `{"request-id": 1, "type": "Admin", "request": "Login", "params": {}}`,
+	expectedMsg: `{"params":{},"request":"Login","request-id":1,"type":"Admin"}`,
+}, {
+	about: "delete values",
+	rules: []wsproxy.Rule{{
+		Method: "Client.FullStatus",
+		Path:   "response.machines.*.series",
+		Action: "delete",
+	}},
+	method:          "Client.FullStatus",
+	msg:             `{"response": {"machines": {"0": {"series": "xenial", "id": "0"}, "1": {"series": "trusty", "id": "1"}}}}`,
+	expectedChanged: true,
+	expectedMsg:     `{"response":{"machines":{"0":{"id":"0"},"1":{"id":"1"}}}}`,
+}, {
+	about: "replace existing values",
+	rules: []wsproxy.Rule{{
+		Path:   "response.facades.*.versions",
+		Action: "replace",
+		Value:  []interface{}{json.Number("42")},
+	}},
+	method:          "Admin.Login",
+	msg:             `{"response": {"facades": [{"name": "Client", "versions": [1]}, {"name": "Pinger"}]}}`,
+	expectedChanged: true,
+	expectedMsg:     `{"response":{"facades":[{"name":"Client","versions":[42]},{"name":"Pinger"}]}}`,
+}, {
+	about: "replace matching values",
+	rules: []wsproxy.Rule{{
+		Path:   "response.machines.*.agent-status.status",
+		Action: "replace",
+		Match:  "started",
+		Value:  "down",
+	}},
+	method:          "Client.FullStatus",
+	msg:             `{"response": {"machines": {"0": {"agent-status": {"status": "started"}}, "1": {"agent-status": {"status": "pending"}}}}}`,
+	expectedChanged: true,
+	expectedMsg:     `{"response":{"machines":{"0":{"agent-status":{"status":"down"}},"1":{"agent-status":{"status":"pending"}}}}}`,
+}, {
+	about: "replace without matching values",
+	rules: []wsproxy.Rule{{
+		Path:   "response.machines.*.agent-status.status",
+		Action: "replace",
+		Match:  "error",
+		Value:  "down",
+	}},
+	method:      "Client.FullStatus",
+	msg:         `{"response": {"machines": {"0": {"agent-status": {"status": "started"}}}}}`,
+	expectedMsg: `{"response":{"machines":{"0":{"agent-status":{"status":"started"}}}}}`,
+}, {
+	about: "multiple rules",
+	rules: []wsproxy.Rule{{
+		Path:   "response.a",
+		Action: "delete",
+	}, {
+		Direction: "response",
+		Path:      "response.b",
+		Action:    "set",
+		Value:     true,
+	}},
+	msg:             `{"response": {"a": 1}}`,
+	expectedChanged: true,
+	expectedMsg:     `{"response":{"b":true}}`,
+}}
+
+func TestRules(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range rulesTests {
+		c.Run(test.about, func(c *qt.C) {
+			rules, err := wsproxy.NewRules(test.rules)
+			c.Assert(err, qt.Equals, nil)
+			var msg interface{}
+			dec := json.NewDecoder(strings.NewReader(test.msg))
+			dec.UseNumber()
+			err = dec.Decode(&msg)
+			c.Assert(err, qt.Equals, nil)
+			f := &wsproxy.Frame{
+				Method:  test.method,
+				Request: test.request,
+				Message: msg,
+			}
+			changed := rules.Transform(f)
+			c.Assert(changed, qt.Equals, test.expectedChanged)
+			b, err := json.Marshal(f.Message)
+			c.Assert(err, qt.Equals, nil)
+			c.Assert(string(b), qt.Equals, test.expectedMsg)
+		})
+	}
+}
+
+func TestRulesValuesNotShared(t *testing.T) {
+	c := qt.New(t)
+	rules, err := wsproxy.NewRules([]wsproxy.Rule{{
+		Path:   "response.status",
+		Action: "set",
+		Value:  map[string]interface{}{"current": "active"},
+	}})
+	c.Assert(err, qt.Equals, nil)
+	f := &wsproxy.Frame{
+		Message: map[string]interface{}{},
+	}
+	rules.Transform(f)
+	// Changing the resulting frame does not affect the rule.
+	f.Message.(map[string]interface{})["response"].(map[string]interface{})["status"].(map[string]interface{})["current"] = "error"
+	f = &wsproxy.Frame{
+		Message: map[string]interface{}{},
+	}
+	rules.Transform(f)
+	c.Assert(f.Message, qt.DeepEquals, map[string]interface{}{
+		"response": map[string]interface{}{
+			"status": map[string]interface{}{"current": "active"},
+		},
+	})
+}
+
+var newRulesErrorTests = []struct {
+	about         string
+	rule          wsproxy.Rule
+	expectedError string
+}{{
+	about: "invalid method",
+	rule: wsproxy.Rule{
+		Method: "Admin.[",
+		Path:   "response",
+		Action: "delete",
+	},
+	expectedError: `invalid rule 1: invalid pattern "Admin.\[": syntax error in pattern`,
+}, {
+	about: "regular expression method",
+	rule: wsproxy.Rule{
+		Method: "/Admin/",
+		Path:   "response",
+		Action: "delete",
+	},
+	expectedError: `invalid rule 1: regular expressions not allowed in method "/Admin/"`,
+}, {
+	about: "invalid direction",
+	rule: wsproxy.Rule{
+		Direction: "sideways",
+		Path:      "response",
+		Action:    "delete",
+	},
+	expectedError: `invalid rule 1: invalid direction "sideways": must be "request" or "response"`,
+}, {
+	about: "empty path",
+	rule: wsproxy.Rule{
+		Action: "delete",
+	},
+	expectedError: `invalid rule 1: empty path`,
+}, {
+	about: "invalid action",
+	rule: wsproxy.Rule{
+		Path:   "response",
+		Action: "exterminate",
+	},
+	expectedError: `invalid rule 1: invalid action "exterminate": must be "set", "replace" or "delete"`,
+}}
+
+func TestNewRulesErrors(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range newRulesErrorTests {
+		c.Run(test.about, func(c *qt.C) {
+			rules, err := wsproxy.NewRules([]wsproxy.Rule{test.rule})
+			c.Assert(err, qt.ErrorMatches, test.expectedError)
+			c.Assert(rules, qt.IsNil)
+		})
+	}
+}
+
+func TestReadRules(t *testing.T) {
+	c := qt.New(t)
+	defer c.Cleanup()
+	path := filepath.Join(c.Mkdir(), "rules.json")
+	err := ioutil.WriteFile(path, []byte(`[{
+		"method": "Admin.Login",
+		"direction": "response",
+		"path": "response.facades.*.versions",
+		"action": "replace",
+		"value": [1, 2, 3]
+	}]`), 0600)
+	c.Assert(err, qt.Equals, nil)
+	rules, err := wsproxy.ReadRules(path)
+	c.Assert(err, qt.Equals, nil)
+	f := &wsproxy.Frame{
+		Method: "Admin.Login",
+		Message: map[string]interface{}{
+			"response": map[string]interface{}{
+				"facades": []interface{}{
+					map[string]interface{}{"name": "Client", "versions": []interface{}{1}},
+				},
+			},
+		},
+	}
+	c.Assert(rules.Transform(f), qt.Equals, true)
+	b, err := json.Marshal(f.Message)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(string(b), qt.Equals, `{"response":{"facades":[{"name":"Client","versions":[1,2,3]}]}}`)
+}
+
+func TestReadRulesErrors(t *testing.T) {
+	c := qt.New(t)
+	defer c.Cleanup()
+	dir := c.Mkdir()
+	rules, err := wsproxy.ReadRules(filepath.Join(dir, "no-such-file.json"))
+	c.Assert(err, qt.ErrorMatches, "cannot open rules file: .*")
+	c.Assert(rules, qt.IsNil)
+
+	path := filepath.Join(dir, "rules.json")
+	err = ioutil.WriteFile(path, []byte(`{"bad": "wolf"}`), 0600)
+	c.Assert(err, qt.Equals, nil)
+	rules, err = wsproxy.ReadRules(path)
+	c.Assert(err, qt.ErrorMatches, `cannot decode rules file ".*": .*`)
+	c.Assert(rules, qt.IsNil)
+
+	err = ioutil.WriteFile(path, []byte(`[{"path": "response", "action": "bad"}]`), 0600)
+	c.Assert(err, qt.Equals, nil)
+	rules, err = wsproxy.ReadRules(path)
+	c.Assert(err, qt.ErrorMatches, `invalid rule 1: invalid action "bad": .*`)
+	c.Assert(rules, qt.IsNil)
+}
@@ -0,0 +1,39 @@
+package wsproxy
+
+import "sync"
+
+// Stats collects statistics about the frames copied between two WebSocket
+// connections. It is safe to read statistics while frames are being copied.
+type Stats struct {
+	mu     sync.Mutex
+	frames [2]int
+	bytes  [2]int64
+}
+
+// Frames returns the number of data frames sent by the first and the second
+// connection respectively.
+func (s *Stats) Frames() (int, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.frames[0], s.frames[1]
+}
+
+// Bytes returns the number of payload bytes sent by the first and the second
+// connection respectively.
+func (s *Stats) Bytes() (int64, int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.bytes[0], s.bytes[1]
+}
+
+// add records a frame of the given size sent by the connection with the given
+// index (0 for the first connection and 1 for the second one).
+func (s *Stats) add(conn, size int) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.frames[conn]++
+	s.bytes[conn] += int64(size)
+}
@@ -0,0 +1,53 @@
+package wsproxy
+
+// Transformer is implemented by types modifying JSON frames while they are
+// copied between WebSocket connections.
+type Transformer interface {
+	// Transform modifies the given frame in place, and reports whether the
+	// frame has been changed.
+	Transform(f *Frame) bool
+}
+
+// Frame holds a decoded JSON text frame.
+type Frame struct {
+	// Method holds the "Facade.Method" of the RPC call the frame is part of,
+	// or an empty string if the method cannot be determined.
+	Method string
+
+	// Request holds whether the frame is an RPC request. Responses and other
+	// JSON frames are not requests.
+	Request bool
+
+	// Message holds the decoded JSON content of the frame. Numbers are
+	// decoded as json.Number values.
+	Message interface{}
+}
+
+// transform applies the configured transformers to the given text frame,
+// whose decoded RPC message and method are also provided, and returns the
+// resulting frame content. The content is returned unchanged if there are no
+// transformers, no changes are made or the frame is not JSON.
+func (p *proxy) transform(method string, m *message, data []byte) []byte {
+	if len(p.Transformers) == 0 || m == nil {
+		return data
+	}
+	doc, err := decodeJSON(string(data))
+	if err != nil {
+		return data
+	}
+	f := &Frame{
+		Method:  method,
+		Request: m.isRequest(),
+		Message: doc,
+	}
+	changed := false
+	for _, t := range p.Transformers {
+		if t.Transform(f) {
+			changed = true
+		}
+	}
+	if !changed {
+		return data
+	}
+	return []byte(encodeJSON(f.Message))
+}
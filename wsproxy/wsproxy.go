@@ -1,47 +1,289 @@
 package wsproxy
 
 import (
-	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
 
 	"github.com/gorilla/websocket"
 
 	"github.com/juju/guiproxy/logger"
+	"github.com/juju/guiproxy/throttle"
 )
 
 // Copy copies messages back and forth between the provided WebSocket
-// connections. JSON encoded traffic is logged via the given loggers.
-func Copy(conn1, conn2 *websocket.Conn, conn1Log, conn2Log logger.Interface) error {
+// connections. Frames are copied verbatim preserving their message type, and
+// text frames are logged via the loggers included in the given parameters.
+// Control frames are handled separately on each connection, except for close
+// frames, which are forwarded to the other connection before returning.
+//
+// Liveness checks can be configured with the given parameters: in that case,
+// when connections are closed because idle or unresponsive, close frames are
+// sent to both peers and a *LivenessError is returned.
+func Copy(conn1, conn2 *websocket.Conn, p Params) error {
+	prx := &proxy{
+		Params: p,
+		calls:  newCalls(),
+	}
+	done := make(chan struct{})
+	defer close(done)
+	prx.keepAlive(conn1, done)
+	prx.keepAlive(conn2, done)
 	// Start copying WebSocket messages back and forth.
-	errCh := make(chan error, 2)
-	go cp(conn1, conn2, errCh, conn2Log)
-	go cp(conn2, conn1, errCh, conn1Log)
-	return <-errCh
+	// Errors can be sent by the copying and delivering goroutines in both
+	// directions, and by the idle watcher.
+	errCh := make(chan error, 5)
+	go prx.cp(conn1, conn2, 1, errCh, p.Conn2Log)
+	go prx.cp(conn2, conn1, 0, errCh, p.Conn1Log)
+	go prx.watchIdle(errCh, done)
+	err := <-errCh
+	if livenessErr, ok := err.(*LivenessError); ok {
+		closeForLiveness(livenessErr, conn1, conn2)
+	}
+	return err
+}
+
+// Params holds parameters for copying WebSocket messages.
+type Params struct {
+	// Conn1Log and Conn2Log hold the loggers used to log frames sent by the
+	// first and the second connection respectively. Nil loggers can be used
+	// to disable logging.
+	Conn1Log, Conn2Log logger.Interface
+
+	// Filter optionally holds the filter used to decide which frames are
+	// logged. All frames are logged if the filter is nil.
+	Filter *Filter
+
+	// Redactor optionally holds the redactor used to hide sensitive values
+	// in logged frames. Frames are logged verbatim if the redactor is nil.
+	Redactor *Redactor
+
+	// Stats optionally holds the statistics updated while copying frames.
+	Stats *Stats
+
+	// Throttle optionally holds the network conditions to emulate. If not
+	// nil, frames are queued in each direction and delivered in order as
+	// scheduled by a throttle.Link: transfers are serialized according to
+	// the bandwidth, while latency applies to each frame independently.
+	Throttle *throttle.Profile
+
+	// PingInterval optionally holds the interval at which pings are sent to
+	// both connections, so that intermediate proxies do not consider them
+	// idle. Zero means no pings are sent.
+	PingInterval time.Duration
+
+	// ReadTimeout optionally holds the maximum time to wait for any frame,
+	// including pongs, from each connection, before considering the peer
+	// unresponsive. Zero means no timeout.
+	ReadTimeout time.Duration
+
+	// IdleTimeout optionally holds the maximum time without data frames in
+	// either direction before closing the connections. Zero means no timeout.
+	IdleTimeout time.Duration
+
+	// Transformers optionally holds the transformers used to modify JSON
+	// text frames before they are sent, applied in order. Logged frames
+	// include the resulting changes.
+	Transformers []Transformer
+
+	// OnServerVersion optionally holds a function called with the Juju
+	// version included in login responses, as sent after applying the
+	// transformers.
+	OnServerVersion func(version string)
+}
+
+// proxy holds the state shared while copying frames in both directions.
+type proxy struct {
+	Params
+	calls *calls
+
+	// lastActivity holds the time of the last data frame, in nanoseconds
+	// since the epoch. It must be accessed atomically.
+	lastActivity int64
 }
 
 // cp copies all frames sent from the src WebSocket connection to the dst one,
 // and sends errors to the given error channel. The content of each frame is
-// also logged using the given logger.
-func cp(dst, src *websocket.Conn, errCh chan error, apiLog logger.Interface) {
-	var msg string
-	var err error
+// also logged using the given logger. JSON text frames are modified by the
+// configured transformers before being sent. The srcIndex argument identifies
+// the source connection in statistics.
+func (p *proxy) cp(dst, src *websocket.Conn, srcIndex int, errCh chan error, apiLog logger.Interface) {
+	s := p.newSender(dst, srcIndex, apiLog, errCh)
 	for {
-		msg, err = copyJSON(dst, src)
+		msgType, data, err := src.ReadMessage()
 		if err != nil {
-			errCh <- err
+			// Deliver pending frames before forwarding the close frame.
+			s.flush()
+			if closeErr, ok := err.(*websocket.CloseError); ok {
+				forwardClose(dst, closeErr)
+			}
+			errCh <- p.livenessError(src, err)
+			return
+		}
+		p.touch()
+		f := frame{
+			msgType: msgType,
+			data:    data,
+		}
+		if msgType == websocket.TextMessage {
+			m := decodeMessage(data)
+			f.method = p.calls.method(m)
+			f.data = p.transform(f.method, m, data)
+			if p.OnServerVersion != nil && f.method == "Admin.Login" && !m.isRequest() {
+				if version := serverVersion(f.data); version != "" {
+					p.OnServerVersion(version)
+				}
+			}
+		}
+		if !s.send(f) {
+			return
+		}
+		// The deadline is extended once the frame has been handed over, so
+		// that blocking writes do not count against the read timeout.
+		p.extendDeadline(src)
+	}
+}
+
+// frame holds a data frame to be sent.
+type frame struct {
+	msgType int
+	data    []byte
+	// method holds the "Facade.Method" of the call the frame belongs to.
+	method string
+	// due holds the time at which the frame must be delivered.
+	due time.Time
+}
+
+// queueSize holds the maximum number of frames waiting to be delivered in
+// each direction when emulating network conditions.
+const queueSize = 256
+
+// newSender returns a sender writing frames to the given connection. When
+// emulating network conditions, frames are queued and delivered by a separate
+// goroutine at the time scheduled by the link, so that reading from the
+// source is not blocked by the emulated delays. Write errors are sent to the
+// given error channel.
+func (p *proxy) newSender(dst *websocket.Conn, srcIndex int, apiLog logger.Interface, errCh chan<- error) *sender {
+	s := &sender{
+		proxy:    p,
+		dst:      dst,
+		srcIndex: srcIndex,
+		log:      apiLog,
+		errCh:    errCh,
+		link:     throttle.NewLink(p.Throttle),
+	}
+	if s.link != nil {
+		s.queue = make(chan frame, queueSize)
+		s.done = make(chan struct{})
+		go s.run()
+	}
+	return s
+}
+
+// sender sends frames to a connection.
+type sender struct {
+	proxy    *proxy
+	dst      *websocket.Conn
+	srcIndex int
+	log      logger.Interface
+	errCh    chan<- error
+	link     *throttle.Link
+
+	// queue and done are only used when emulating network conditions: the
+	// done channel is closed when the delivering goroutine exits.
+	queue chan frame
+	done  chan struct{}
+}
+
+// send sends the given frame, or queues it for delivery when emulating
+// network conditions. It reports whether frames can still be sent.
+func (s *sender) send(f frame) bool {
+	if s.link == nil {
+		if err := s.write(f); err != nil {
+			s.errCh <- err
+			return false
+		}
+		return true
+	}
+	f.due = s.link.Schedule(len(f.data))
+	select {
+	case <-s.done:
+		return false
+	default:
+	}
+	select {
+	case s.queue <- f:
+		return true
+	case <-s.done:
+		return false
+	}
+}
+
+// run delivers queued frames at their due time.
+func (s *sender) run() {
+	defer close(s.done)
+	for f := range s.queue {
+		if d := time.Until(f.due); d > 0 {
+			time.Sleep(d)
+		}
+		if err := s.write(f); err != nil {
+			s.errCh <- err
 			return
 		}
-		apiLog.Print(msg)
 	}
 }
 
-// copyJSON copies a single JSON frame sent by src to dst.
-func copyJSON(dst, src *websocket.Conn) (string, error) {
-	var m *json.RawMessage
-	if err := src.ReadJSON(&m); err != nil {
-		return "", err
+// flush waits for queued frames to be delivered.
+func (s *sender) flush() {
+	if s.link == nil {
+		return
+	}
+	close(s.queue)
+	<-s.done
+}
+
+// write writes the given frame, and logs it.
+func (s *sender) write(f frame) error {
+	if err := s.dst.WriteMessage(f.msgType, f.data); err != nil {
+		return err
+	}
+	p := s.proxy
+	p.Stats.add(s.srcIndex, len(f.data))
+	if s.log == nil {
+		return nil
 	}
-	if err := dst.WriteJSON(m); err != nil {
-		return "", err
+	if f.msgType != websocket.TextMessage {
+		// Binary frames have no method or content to match, so they are
+		// only logged when no include expressions are provided.
+		if p.Filter.Match("", "") {
+			s.log.Print(fmt.Sprintf("binary frame (%d bytes)", len(f.data)))
+		}
+		return nil
+	}
+	// Text frames are often terminated by a new line, which is not relevant
+	// for logging.
+	msg := p.Redactor.Redact(f.method, strings.TrimSpace(string(f.data)))
+	if p.Filter.Match(f.method, msg) {
+		s.log.Print(msg)
+	}
+	return nil
+}
+
+// closeTimeout holds the time allowed to forward close frames.
+const closeTimeout = time.Second
+
+// forwardClose sends a close frame to the given connection, reproducing the
+// given close error.
+func forwardClose(conn *websocket.Conn, closeErr *websocket.CloseError) {
+	var data []byte
+	switch closeErr.Code {
+	case websocket.CloseNoStatusReceived:
+		// No status code must be sent in this case.
+	case websocket.CloseAbnormalClosure, websocket.CloseTLSHandshake:
+		// These codes cannot be sent in close frames.
+		data = websocket.FormatCloseMessage(websocket.CloseGoingAway, closeErr.Text)
+	default:
+		data = websocket.FormatCloseMessage(closeErr.Code, closeErr.Text)
 	}
-	return string(*m), nil
+	conn.WriteControl(websocket.CloseMessage, data, time.Now().Add(closeTimeout))
 }
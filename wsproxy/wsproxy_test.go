@@ -2,7 +2,7 @@ package wsproxy_test
 
 import (
 	"encoding/json"
-	"io"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"strings"
@@ -13,6 +13,7 @@ import (
 	qt "github.com/frankban/quicktest"
 	"github.com/gorilla/websocket"
 
+	"github.com/juju/guiproxy/throttle"
 	"github.com/juju/guiproxy/wsproxy"
 )
 
@@ -25,7 +26,12 @@ func TestCopy(t *testing.T) {
 
 	// Set up the WebSocket proxy that copies the messages back and forth.
 	conn1Log, conn2Log := &logStorage{}, &logStorage{}
-	proxy := httptest.NewServer(newProxyHandler(wsURL(ping.URL), conn1Log, conn2Log))
+	var stats wsproxy.Stats
+	proxy := httptest.NewServer(newProxyHandler(wsURL(ping.URL), wsproxy.Params{
+		Conn1Log: conn1Log,
+		Conn2Log: conn2Log,
+		Stats:    &stats,
+	}))
 	defer proxy.Close()
 
 	// Connect to the proxy.
@@ -58,10 +64,394 @@ func TestCopy(t *testing.T) {
 			c.Assert(err, qt.Equals, nil)
 			messages[i] = string(b)
 		}
-		c.Assert(ls.messages, qt.DeepEquals, messages)
+		c.Assert(ls.Messages(), qt.DeepEquals, messages)
 	}
 	assertLogs(conn1Log, "ping", "bad wolf")
 	assertLogs(conn2Log, "ping pong", "bad wolf pong")
+
+	// Statistics have been collected.
+	frames1, frames2 := stats.Frames()
+	c.Assert(frames1, qt.Equals, 2)
+	c.Assert(frames2, qt.Equals, 2)
+	bytes1, bytes2 := stats.Bytes()
+	c.Assert(bytes1, qt.Equals, int64(len(`{"Content":"ping"}`+"\n"+`{"Content":"bad wolf"}`+"\n")))
+	c.Assert(bytes2, qt.Equals, int64(len(`{"Content":"ping pong"}`+"\n"+`{"Content":"bad wolf pong"}`+"\n")))
+}
+
+func TestCopyWithFilter(t *testing.T) {
+	c := qt.New(t)
+	defer c.Cleanup()
+	// Set up a target WebSocket server.
+	rpc := httptest.NewServer(http.HandlerFunc(rpcHandler))
+	defer rpc.Close()
+
+	// Set up the WebSocket proxy only logging some of the frames.
+	filter, err := wsproxy.NewFilter([]string{"Client.*", "AllWatcher.Next"}, []string{"/secret/"})
+	c.Assert(err, qt.Equals, nil)
+	conn1Log, conn2Log := &logStorage{}, &logStorage{}
+	proxy := httptest.NewServer(newProxyHandler(wsURL(rpc.URL), wsproxy.Params{
+		Conn1Log: conn1Log,
+		Conn2Log: conn2Log,
+		Filter:   filter,
+	}))
+	defer proxy.Close()
+
+	// Connect to the proxy.
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL(proxy.URL), nil)
+	c.Assert(err, qt.Equals, nil)
+	defer conn.Close()
+
+	// Make some RPC calls.
+	call := func(id int, facade, method, param string) {
+		err := conn.WriteJSON(map[string]interface{}{
+			"request-id": id,
+			"type":       facade,
+			"request":    method,
+			"params":     param,
+		})
+		c.Assert(err, qt.Equals, nil)
+		var resp map[string]interface{}
+		err = conn.ReadJSON(&resp)
+		c.Assert(err, qt.Equals, nil)
+	}
+	call(1, "Pinger", "Ping", "")
+	call(2, "Client", "FullStatus", "")
+	call(3, "AllWatcher", "Stop", "")
+	call(4, "Client", "AddCharm", "secret")
+	call(5, "AllWatcher", "Next", "")
+
+	// Only requests and responses matching the filter have been logged.
+	waitForMessages(conn1Log, 2)
+	c.Assert(conn1Log.Messages(), qt.DeepEquals, []string{
+		`{"params":"","request":"FullStatus","request-id":2,"type":"Client"}`,
+		`{"params":"","request":"Next","request-id":5,"type":"AllWatcher"}`,
+	})
+	waitForMessages(conn2Log, 3)
+	c.Assert(conn2Log.Messages(), qt.DeepEquals, []string{
+		`{"request-id":2,"response":{}}`,
+		`{"request-id":4,"response":{}}`,
+		`{"request-id":5,"response":{}}`,
+	})
+}
+
+func TestCopyWithRedactor(t *testing.T) {
+	c := qt.New(t)
+	defer c.Cleanup()
+	// Set up a target WebSocket server.
+	rpc := httptest.NewServer(http.HandlerFunc(rpcHandler))
+	defer rpc.Close()
+
+	// Set up the WebSocket proxy hiding sensitive information.
+	redactor, err := wsproxy.NewRedactor(nil, true)
+	c.Assert(err, qt.Equals, nil)
+	conn1Log := &logStorage{}
+	proxy := httptest.NewServer(newProxyHandler(wsURL(rpc.URL), wsproxy.Params{
+		Conn1Log: conn1Log,
+		Redactor: redactor,
+	}))
+	defer proxy.Close()
+
+	// Connect to the proxy and log in.
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL(proxy.URL), nil)
+	c.Assert(err, qt.Equals, nil)
+	defer conn.Close()
+	err = conn.WriteJSON(map[string]interface{}{
+		"request-id": 1,
+		"type":       "Admin",
+		"request":    "Login",
+		"params": map[string]string{
+			"auth-tag":    "user-who",
+			"credentials": "tardis",
+		},
+	})
+	c.Assert(err, qt.Equals, nil)
+	var resp map[string]interface{}
+	err = conn.ReadJSON(&resp)
+	c.Assert(err, qt.Equals, nil)
+
+	// The password is not logged.
+	waitForMessages(conn1Log, 1)
+	c.Assert(conn1Log.Messages(), qt.DeepEquals, []string{
+		`{"params":{"auth-tag":"user-who","credentials":"[REDACTED]"},"request":"Login","request-id":1,"type":"Admin"}`,
+	})
+}
+
+func TestCopyWithTransformers(t *testing.T) {
+	c := qt.New(t)
+	defer c.Cleanup()
+	// Set up a target WebSocket server.
+	rpc := httptest.NewServer(http.HandlerFunc(rpcHandler))
+	defer rpc.Close()
+
+	// Set up the WebSocket proxy rewriting login responses.
+	rules, err := wsproxy.NewRules([]wsproxy.Rule{{
+		Method:    "Admin.Login",
+		Direction: "response",
+		Path:      "response.server-version",
+		Action:    "set",
+		Value:     "2.42.0",
+	}})
+	c.Assert(err, qt.Equals, nil)
+	conn2Log := &logStorage{}
+	versionCh := make(chan string, 1)
+	proxy := httptest.NewServer(newProxyHandler(wsURL(rpc.URL), wsproxy.Params{
+		Conn2Log:     conn2Log,
+		Transformers: []wsproxy.Transformer{rules},
+		OnServerVersion: func(version string) {
+			versionCh <- version
+		},
+	}))
+	defer proxy.Close()
+
+	// Connect to the proxy.
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL(proxy.URL), nil)
+	c.Assert(err, qt.Equals, nil)
+	defer conn.Close()
+	call := func(id int, facade, method string) string {
+		err := conn.WriteJSON(map[string]interface{}{
+			"request-id": id,
+			"type":       facade,
+			"request":    method,
+		})
+		c.Assert(err, qt.Equals, nil)
+		_, data, err := conn.ReadMessage()
+		c.Assert(err, qt.Equals, nil)
+		return strings.TrimSpace(string(data))
+	}
+
+	// Only the matching response is modified.
+	c.Assert(call(1, "Admin", "Login"), qt.Equals, `{"request-id":1,"response":{"server-version":"2.42.0"}}`)
+	c.Assert(call(2, "Client", "FullStatus"), qt.Equals, `{"request-id":2,"response":{}}`)
+
+	// The resulting server version is reported.
+	c.Assert(<-versionCh, qt.Equals, "2.42.0")
+
+	// The modified frame is logged.
+	waitForMessages(conn2Log, 2)
+	c.Assert(conn2Log.Messages(), qt.DeepEquals, []string{
+		`{"request-id":1,"response":{"server-version":"2.42.0"}}`,
+		`{"request-id":2,"response":{}}`,
+	})
+}
+
+func TestCopyRawFrames(t *testing.T) {
+	c := qt.New(t)
+	defer c.Cleanup()
+	// Set up a target WebSocket server.
+	closeCh := make(chan error, 1)
+	echo := httptest.NewServer(newEchoHandler(closeCh))
+	defer echo.Close()
+
+	// Set up the WebSocket proxy that copies the messages back and forth.
+	conn1Log, conn2Log := &logStorage{}, &logStorage{}
+	proxy := httptest.NewServer(newProxyHandler(wsURL(echo.URL), wsproxy.Params{
+		Conn1Log: conn1Log,
+		Conn2Log: conn2Log,
+	}))
+	defer proxy.Close()
+
+	// Connect to the proxy.
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL(proxy.URL), nil)
+	c.Assert(err, qt.Equals, nil)
+	defer conn.Close()
+
+	// Send binary and non JSON text frames, and check they are echoed back.
+	send := func(msgType int, data string) {
+		err := conn.WriteMessage(msgType, []byte(data))
+		c.Assert(err, qt.Equals, nil)
+		gotType, got, err := conn.ReadMessage()
+		c.Assert(err, qt.Equals, nil)
+		c.Assert(gotType, qt.Equals, msgType)
+		c.Assert(string(got), qt.Equals, data)
+	}
+	send(websocket.BinaryMessage, "\x00\x01binary")
+	send(websocket.TextMessage, "these are the voyages")
+	send(websocket.TextMessage, `{"json": true}`)
+
+	// Frames have been logged.
+	waitForMessages(conn1Log, 3)
+	c.Assert(conn1Log.Messages(), qt.DeepEquals, []string{
+		"binary frame (8 bytes)",
+		"these are the voyages",
+		`{"json": true}`,
+	})
+
+	// Close frames are forwarded.
+	err = conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(4000, "bye"))
+	c.Assert(err, qt.Equals, nil)
+	select {
+	case err := <-closeCh:
+		c.Assert(websocket.IsCloseError(err, 4000), qt.Equals, true, qt.Commentf("error: %v", err))
+	case <-time.After(time.Second):
+		c.Fatalf("close frame not received by the target server")
+	}
+}
+
+func TestCopyWithThrottle(t *testing.T) {
+	c := qt.New(t)
+	defer c.Cleanup()
+	// Set up a target WebSocket server.
+	ping := httptest.NewServer(http.HandlerFunc(pingHandler))
+	defer ping.Close()
+
+	// Set up the WebSocket proxy emulating a slow link.
+	proxy := httptest.NewServer(newProxyHandler(wsURL(ping.URL), wsproxy.Params{
+		Throttle: &throttle.Profile{
+			Latency: 50 * time.Millisecond,
+		},
+	}))
+	defer proxy.Close()
+
+	// Connect to the proxy.
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL(proxy.URL), nil)
+	c.Assert(err, qt.Equals, nil)
+	defer conn.Close()
+
+	// Frames are delayed in both directions.
+	start := time.Now()
+	msg := jsonMessage{
+		Content: "ping",
+	}
+	err = conn.WriteJSON(msg)
+	c.Assert(err, qt.Equals, nil)
+	err = conn.ReadJSON(&msg)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(msg.Content, qt.Equals, "ping pong")
+	elapsed := time.Since(start)
+	c.Assert(elapsed >= 100*time.Millisecond, qt.Equals, true, qt.Commentf("elapsed: %s", elapsed))
+}
+
+func TestCopyWithThrottleBurst(t *testing.T) {
+	c := qt.New(t)
+	defer c.Cleanup()
+	// Set up a target WebSocket server.
+	closeCh := make(chan error, 1)
+	echo := httptest.NewServer(newEchoHandler(closeCh))
+	defer echo.Close()
+
+	// Set up the WebSocket proxy emulating a slow link.
+	proxy := httptest.NewServer(newProxyHandler(wsURL(echo.URL), wsproxy.Params{
+		Throttle: &throttle.Profile{
+			Latency: 100 * time.Millisecond,
+		},
+	}))
+	defer proxy.Close()
+
+	// Connect to the proxy.
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL(proxy.URL), nil)
+	c.Assert(err, qt.Equals, nil)
+	defer conn.Close()
+
+	// A burst of frames is delayed by about one latency in each direction,
+	// and frames are delivered in order.
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		err = conn.WriteMessage(websocket.TextMessage, []byte(fmt.Sprint(i)))
+		c.Assert(err, qt.Equals, nil)
+	}
+	for i := 0; i < 5; i++ {
+		_, data, err := conn.ReadMessage()
+		c.Assert(err, qt.Equals, nil)
+		c.Assert(string(data), qt.Equals, fmt.Sprint(i))
+	}
+	elapsed := time.Since(start)
+	c.Assert(elapsed >= 200*time.Millisecond, qt.Equals, true, qt.Commentf("elapsed: %s", elapsed))
+	c.Assert(elapsed < 500*time.Millisecond, qt.Equals, true, qt.Commentf("elapsed: %s", elapsed))
+}
+
+func TestCopyWithPings(t *testing.T) {
+	c := qt.New(t)
+	defer c.Cleanup()
+	// Set up a target WebSocket server.
+	closeCh := make(chan error, 1)
+	echo := httptest.NewServer(newEchoHandler(closeCh))
+	defer echo.Close()
+
+	// Set up the WebSocket proxy sending pings.
+	proxy := httptest.NewServer(newProxyHandler(wsURL(echo.URL), wsproxy.Params{
+		PingInterval: 10 * time.Millisecond,
+		ReadTimeout:  time.Second,
+	}))
+	defer proxy.Close()
+
+	// Connect to the proxy.
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL(proxy.URL), nil)
+	c.Assert(err, qt.Equals, nil)
+	defer conn.Close()
+	pingCh := make(chan struct{}, 10)
+	conn.SetPingHandler(func(data string) error {
+		select {
+		case pingCh <- struct{}{}:
+		default:
+		}
+		return conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+	})
+	go conn.ReadMessage()
+
+	// Pings are periodically sent.
+	for i := 0; i < 3; i++ {
+		select {
+		case <-pingCh:
+		case <-time.After(time.Second):
+			c.Fatalf("ping %d not received", i)
+		}
+	}
+}
+
+func TestCopyLiveness(t *testing.T) {
+	c := qt.New(t)
+	tests := []struct {
+		about          string
+		params         wsproxy.Params
+		expectedReason string
+	}{{
+		about: "read timeout",
+		params: wsproxy.Params{
+			ReadTimeout: 50 * time.Millisecond,
+		},
+		expectedReason: `no frames received from .* in 50ms`,
+	}, {
+		about: "idle timeout",
+		params: wsproxy.Params{
+			PingInterval: 10 * time.Millisecond,
+			ReadTimeout:  time.Second,
+			IdleTimeout:  100 * time.Millisecond,
+		},
+		expectedReason: "connection idle for more than 100ms",
+	}}
+	for _, test := range tests {
+		c.Run(test.about, func(c *qt.C) {
+			// Set up a target WebSocket server.
+			closeCh := make(chan error, 1)
+			echo := httptest.NewServer(newEchoHandler(closeCh))
+			defer echo.Close()
+
+			// Set up the WebSocket proxy.
+			proxy := httptest.NewServer(newProxyHandler(wsURL(echo.URL), test.params))
+			defer proxy.Close()
+
+			// Connect to the proxy and exchange a message.
+			conn, _, err := websocket.DefaultDialer.Dial(wsURL(proxy.URL), nil)
+			c.Assert(err, qt.Equals, nil)
+			defer conn.Close()
+			err = conn.WriteMessage(websocket.TextMessage, []byte("hello"))
+			c.Assert(err, qt.Equals, nil)
+			_, data, err := conn.ReadMessage()
+			c.Assert(err, qt.Equals, nil)
+			c.Assert(string(data), qt.Equals, "hello")
+
+			// When the connection is not alive, both peers are notified.
+			_, _, err = conn.ReadMessage()
+			c.Assert(websocket.IsCloseError(err, websocket.CloseGoingAway), qt.Equals, true, qt.Commentf("error: %v", err))
+			c.Assert(err.(*websocket.CloseError).Text, qt.Matches, test.expectedReason)
+			select {
+			case err := <-closeCh:
+				c.Assert(websocket.IsCloseError(err, websocket.CloseGoingAway), qt.Equals, true, qt.Commentf("error: %v", err))
+			case <-time.After(time.Second):
+				c.Fatalf("close frame not received by the target server")
+			}
+		})
+	}
 }
 
 func waitForMessages(ls *logStorage, expectedNum int) {
@@ -72,45 +462,95 @@ func waitForMessages(ls *logStorage, expectedNum int) {
 		case <-timeout:
 			return
 		case <-tick:
-			if len(ls.messages) == expectedNum {
+			if len(ls.Messages()) == expectedNum {
 				return
 			}
 		}
 	}
 }
 
-// pingHandler is a WebSocket handler responding to pings.
+// pingHandler is a WebSocket handler responding to pings. It returns when the
+// connection is closed, for instance by the proxy.
 func pingHandler(w http.ResponseWriter, req *http.Request) {
 	conn := upgrade(w, req)
+	if conn == nil {
+		return
+	}
 	defer conn.Close()
 	var msg jsonMessage
 	for {
-		err := conn.ReadJSON(&msg)
-		if err == io.EOF {
+		if err := conn.ReadJSON(&msg); err != nil {
 			return
 		}
-		if err != nil {
-			panic(err)
-		}
 		msg.Content += " pong"
-		if err = conn.WriteJSON(msg); err != nil {
-			panic(err)
+		if err := conn.WriteJSON(msg); err != nil {
+			return
 		}
 	}
 }
 
-// newCopyHandler returns a WebSocket handler copying from the given WebSocket
+// newEchoHandler returns a WebSocket handler echoing back all the received
+// frames, preserving their types. The read error terminating the connection
+// is sent to the given channel.
+func newEchoHandler(errCh chan error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		conn := upgrade(w, req)
+		if conn == nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			msgType, data, err := conn.ReadMessage()
+			if err != nil {
+				errCh <- err
+				return
+			}
+			if err := conn.WriteMessage(msgType, data); err != nil {
+				return
+			}
+		}
+	})
+}
+
+// rpcHandler is a WebSocket handler responding to RPC requests with empty
+// responses.
+func rpcHandler(w http.ResponseWriter, req *http.Request) {
+	conn := upgrade(w, req)
+	if conn == nil {
+		return
+	}
+	defer conn.Close()
+	for {
+		var msg struct {
+			RequestID int `json:"request-id"`
+		}
+		if err := conn.ReadJSON(&msg); err != nil {
+			return
+		}
+		resp := map[string]interface{}{
+			"request-id": msg.RequestID,
+			"response":   map[string]interface{}{},
+		}
+		if err := conn.WriteJSON(resp); err != nil {
+			return
+		}
+	}
+}
+
+// newProxyHandler returns a WebSocket handler copying from the given WebSocket
 // server.
-func newProxyHandler(srvURL string, conn1Log, conn2Log *logStorage) http.Handler {
+func newProxyHandler(srvURL string, p wsproxy.Params) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 		conn1 := upgrade(w, req)
+		if conn1 == nil {
+			return
+		}
 		conn2, _, err := websocket.DefaultDialer.Dial(srvURL, nil)
 		if err != nil {
-			panic(err)
-		}
-		if err := wsproxy.Copy(conn1, conn2, conn1Log, conn2Log); err != nil {
-			panic(err)
+			conn1.Close()
+			return
 		}
+		wsproxy.Copy(conn1, conn2, p)
 	})
 }
 
@@ -127,17 +567,25 @@ func (ls *logStorage) Print(msg string) {
 	ls.Unlock()
 }
 
+// Messages returns the stored log messages.
+func (ls *logStorage) Messages() []string {
+	ls.Lock()
+	defer ls.Unlock()
+	return append([]string(nil), ls.messages...)
+}
+
 // wsURL returns a WebSocket URL from the given HTTP URL.
 func wsURL(u string) string {
 	return strings.Replace(u, "http://", "ws://", 1)
 }
 
 // upgrade upgrades the given request and returns the resulting WebSocket
-// connection.
+// connection, or nil if the upgrade failed, in which case an error response
+// has already been sent.
 func upgrade(w http.ResponseWriter, req *http.Request) *websocket.Conn {
 	conn, err := upgrader.Upgrade(w, req, nil)
 	if err != nil {
-		panic(err)
+		return nil
 	}
 	return conn
 }